@@ -0,0 +1,168 @@
+package natsjobs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	resourceMemory    string = "memory"
+	resourceStorage   string = "storage"
+	resourceStreams   string = "streams"
+	resourceConsumers string = "consumers"
+)
+
+// Accounts runs a single JetStream account watcher per account (server address and credentials).
+// The pipelines of the same account share the watcher, the account info is queried via the connection
+// of any of them and the usage is reported for every pipeline.
+type Accounts struct {
+	mu       sync.Mutex
+	watchers map[string]*accountWatcher
+}
+
+// accountWatcher is the watcher of the single account, it exits when the last pipeline is stopped
+type accountWatcher struct {
+	members map[*Driver]struct{}
+	stopCh  chan struct{}
+	// resources above the threshold, the event is sent once per crossing
+	exceeded map[string]bool
+}
+
+func NewAccounts() *Accounts {
+	return &Accounts{
+		watchers: make(map[string]*accountWatcher),
+	}
+}
+
+// watch adds the pipeline to the watcher of the account, the watcher is started for the first pipeline.
+// The interval and the threshold are global options, so the same for all the pipelines.
+func (a *Accounts) watch(account string, c *Driver, interval time.Duration, threshold int) {
+	a.mu.Lock()
+	w, ok := a.watchers[account]
+	if !ok {
+		w = &accountWatcher{
+			members:  make(map[*Driver]struct{}),
+			stopCh:   make(chan struct{}),
+			exceeded: make(map[string]bool, 4),
+		}
+		a.watchers[account] = w
+		go a.run(w, interval, threshold)
+	}
+	w.members[c] = struct{}{}
+	a.mu.Unlock()
+
+	go func() {
+		<-c.closeCh
+		a.leave(account, c)
+	}()
+}
+
+// leave removes the stopped pipeline, the watcher is stopped with the last one
+func (a *Accounts) leave(account string, c *Driver) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	w, ok := a.watchers[account]
+	if !ok {
+		return
+	}
+
+	delete(w.members, c)
+	if len(w.members) == 0 {
+		close(w.stopCh)
+		delete(a.watchers, account)
+	}
+}
+
+// members returns the pipelines of the watcher, the connected ones first
+func (a *Accounts) members(w *accountWatcher) []*Driver {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	drivers := make([]*Driver, 0, len(w.members))
+	for c := range w.members {
+		if c.connected() {
+			drivers = append([]*Driver{c}, drivers...)
+			continue
+		}
+
+		drivers = append(drivers, c)
+	}
+
+	return drivers
+}
+
+// run periodically queries the JetStream account usage and limits ($JS.API.INFO),
+// reports them via metrics and notifies when the usage crosses the threshold
+func (a *Accounts) run(w *accountWatcher, interval time.Duration, threshold int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			drivers := a.members(w)
+			if len(drivers) == 0 {
+				continue
+			}
+
+			c := drivers[0]
+			ai, err := c.js.AccountInfo()
+			if err != nil {
+				// credentials might not permit the account info requests, log and keep trying
+				c.log.Warn("failed to get the JetStream account info", zap.Error(err))
+				continue
+			}
+
+			usage := map[string][2]float64{
+				resourceMemory:    {float64(ai.Memory), float64(ai.Limits.MaxMemory)},
+				resourceStorage:   {float64(ai.Store), float64(ai.Limits.MaxStore)},
+				resourceStreams:   {float64(ai.Streams), float64(ai.Limits.MaxStreams)},
+				resourceConsumers: {float64(ai.Consumers), float64(ai.Limits.MaxConsumers)},
+			}
+
+			pipes := make([]string, 0, len(drivers))
+			for i := 0; i < len(drivers); i++ {
+				pipes = append(pipes, (*drivers[i].pipeline.Load()).Name())
+			}
+
+			for res, v := range usage {
+				for i := 0; i < len(drivers); i++ {
+					drivers[i].metrics.account(pipes[i], res, v[0], v[1])
+				}
+
+				// no limit
+				if v[1] <= 0 || threshold <= 0 {
+					continue
+				}
+
+				pct := v[0] / v[1] * 100
+				switch {
+				case pct >= float64(threshold) && !w.exceeded[res]:
+					w.exceeded[res] = true
+					msg := fmt.Sprintf("JetStream account %s usage is %.1f%% of the limit (threshold: %d%%)", res, pct, threshold)
+					c.log.Warn(msg, zap.Strings("pipelines", pipes), zap.Float64("usage", v[0]), zap.Float64("limit", v[1]))
+					c.event(EventAccountQuota, msg)
+				case pct < float64(threshold) && w.exceeded[res]:
+					w.exceeded[res] = false
+					c.log.Info("JetStream account usage is back below the threshold", zap.Strings("pipelines", pipes), zap.String("resource", res))
+				}
+			}
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// accountWatcher joins the pipeline to the watcher of its account
+func (c *Driver) accountWatcher(addr, creds string, interval time.Duration, threshold int) {
+	if c.accounts == nil {
+		// not shared with the other pipelines
+		c.accounts = NewAccounts()
+	}
+
+	c.accounts.watch(addr+"|"+creds, c, interval, threshold)
+}
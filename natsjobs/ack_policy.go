@@ -0,0 +1,41 @@
+package natsjobs
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+)
+
+const (
+	// every message is acknowledged individually (default)
+	ackPolicyExplicit string = "explicit"
+	// messages are not acknowledged, the jobs are handled as auto-acked and can't be redelivered
+	ackPolicyNone string = "none"
+	// acknowledging a message acknowledges all the previous messages
+	ackPolicyAll string = "all"
+)
+
+func validateAckPolicy(policy string) error {
+	switch policy {
+	case "", ackPolicyExplicit, ackPolicyNone, ackPolicyAll:
+		return nil
+	default:
+		return errors.Errorf("unknown ack_policy: %s, available: explicit, none, all", policy)
+	}
+}
+
+// ackOpt returns the consumer ack policy option
+func (c *Driver) ackOpt() nats.SubOpt {
+	switch c.ackPolicy {
+	case ackPolicyNone:
+		return nats.AckNone()
+	case ackPolicyAll:
+		return nats.AckAll()
+	default:
+		return nats.AckExplicit()
+	}
+}
+
+// noAck checks if the messages should not be acknowledged at all
+func (c *Driver) noAck() bool {
+	return c.ackPolicy == ackPolicyNone
+}
@@ -0,0 +1,133 @@
+package natsjobs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// AckDecision is the JetStream ack sent for the job failed by the worker (Nack)
+type AckDecision int
+
+const (
+	// DecisionNak redelivers the message immediately
+	DecisionNak AckDecision = iota
+	// DecisionNakWithDelay redelivers the message after the delay
+	DecisionNakWithDelay
+	// DecisionTerm stops the redelivery (the message is copied to the DLQ if configured)
+	DecisionTerm
+	// DecisionAck acknowledges the failed message, it is not redelivered
+	DecisionAck
+)
+
+const (
+	ackStrategyDefault      string = "default"
+	ackStrategyAlwaysAck    string = "always-ack"
+	ackStrategyRetryTerm    string = "retry-then-term"
+	ackStrategyRetryBackoff string = "retry-backoff"
+)
+
+// AckStrategy decides how the failed jobs are acknowledged. Other plugins may provide their own strategies,
+// selected by name via the ack_strategy option.
+type AckStrategy interface {
+	// Name returns the strategy name used in the ack_strategy option
+	Name() string
+	// OnNack returns the decision for the failed job, attempts include the current one. The delay is used only
+	// with the DecisionNakWithDelay.
+	OnNack(item *Item, attempts uint64) (AckDecision, time.Duration)
+}
+
+type defaultStrategy struct{}
+
+func (defaultStrategy) Name() string { return ackStrategyDefault }
+
+func (defaultStrategy) OnNack(*Item, uint64) (AckDecision, time.Duration) {
+	return DecisionNak, 0
+}
+
+type alwaysAckStrategy struct{}
+
+func (alwaysAckStrategy) Name() string { return ackStrategyAlwaysAck }
+
+func (alwaysAckStrategy) OnNack(*Item, uint64) (AckDecision, time.Duration) {
+	return DecisionAck, 0
+}
+
+// retryTermStrategy redelivers the job up to the max retries, then terminates it
+type retryTermStrategy struct {
+	maxRetries uint64
+}
+
+func (s *retryTermStrategy) Name() string { return ackStrategyRetryTerm }
+
+func (s *retryTermStrategy) OnNack(_ *Item, attempts uint64) (AckDecision, time.Duration) {
+	if attempts > s.maxRetries {
+		return DecisionTerm, 0
+	}
+
+	return DecisionNak, 0
+}
+
+// retryBackoffStrategy redelivers the job with the exponential backoff, terminates it after the max retries (if set)
+type retryBackoffStrategy struct {
+	maxRetries uint64
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func (s *retryBackoffStrategy) Name() string { return ackStrategyRetryBackoff }
+
+func (s *retryBackoffStrategy) OnNack(_ *Item, attempts uint64) (AckDecision, time.Duration) {
+	if s.maxRetries > 0 && attempts > s.maxRetries {
+		return DecisionTerm, 0
+	}
+
+	delay := s.backoff
+	for i := uint64(1); i < attempts && delay < s.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > s.maxBackoff {
+		delay = s.maxBackoff
+	}
+
+	return DecisionNakWithDelay, delay
+}
+
+// ackStrategy resolves the strategy by its name, built-in strategies take precedence
+func ackStrategy(name string, maxRetries uint64, backoff, maxBackoff time.Duration, custom map[string]AckStrategy) (AckStrategy, error) {
+	switch name {
+	case "", ackStrategyDefault:
+		return defaultStrategy{}, nil
+	case ackStrategyAlwaysAck:
+		return alwaysAckStrategy{}, nil
+	case ackStrategyRetryTerm:
+		// 0 would terminate the job on the first failure
+		if maxRetries == 0 {
+			return nil, errors.Str("ack_strategy: retry-then-term requires the ack_max_retries")
+		}
+
+		return &retryTermStrategy{maxRetries: maxRetries}, nil
+	case ackStrategyRetryBackoff:
+		return &retryBackoffStrategy{maxRetries: maxRetries, backoff: backoff, maxBackoff: maxBackoff}, nil
+	default:
+		if s, ok := custom[name]; ok {
+			return s, nil
+		}
+
+		return nil, errors.Errorf("unknown ack_strategy: %s, available: default, always-ack, retry-then-term, retry-backoff or the strategy provided by a plugin", name)
+	}
+}
+
+// attempts returns the number of the delivery attempts saved in the headers on consume
+func (i *Item) attempts() uint64 {
+	if v, ok := i.Headers[AttemptsHeader]; ok && len(v) > 0 {
+		n, err := strconv.ParseUint(v[0], 10, 64)
+		if err == nil {
+			return n
+		}
+	}
+
+	return i.Options.delivered
+}
@@ -0,0 +1,46 @@
+package natsjobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the default ack_max_retries doesn't terminate the jobs on the first failure
+func TestAckStrategyDefaultRetries(t *testing.T) {
+	_, err := ackStrategy(ackStrategyRetryTerm, 0, 0, 0, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ack_max_retries")
+
+	s, err := ackStrategy(ackStrategyRetryBackoff, 0, time.Second, time.Second*10, nil)
+	require.NoError(t, err)
+	d, delay := s.OnNack(nil, 100)
+	assert.Equal(t, DecisionNakWithDelay, d)
+	assert.Equal(t, time.Second*10, delay)
+}
+
+func TestAckStrategyRetryTerm(t *testing.T) {
+	s, err := ackStrategy(ackStrategyRetryTerm, 2, 0, 0, nil)
+	require.NoError(t, err)
+
+	for attempts, want := range map[uint64]AckDecision{1: DecisionNak, 2: DecisionNak, 3: DecisionTerm} {
+		d, _ := s.OnNack(nil, attempts)
+		assert.Equalf(t, want, d, "attempt %d", attempts)
+	}
+}
+
+func TestAckStrategyRetryBackoff(t *testing.T) {
+	s, err := ackStrategy(ackStrategyRetryBackoff, 3, time.Second, time.Second*3, nil)
+	require.NoError(t, err)
+
+	for attempts, want := range map[uint64]time.Duration{1: time.Second, 2: time.Second * 2, 3: time.Second * 3} {
+		d, delay := s.OnNack(nil, attempts)
+		assert.Equal(t, DecisionNakWithDelay, d)
+		assert.Equalf(t, want, delay, "attempt %d", attempts)
+	}
+
+	d, _ := s.OnNack(nil, 4)
+	assert.Equal(t, DecisionTerm, d)
+}
@@ -0,0 +1,156 @@
+package natsjobs
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// adaptivePrefetch tunes the prefetch (consumer max_ack_pending and the pull fetch batch) between min and max by the
+// observed processing rate and latency. The in-flight messages are kept at twice the rate * latency (Little's law),
+// but not more than the workers can process within the half of the ack_wait. nil adaptivePrefetch is disabled.
+type adaptivePrefetch struct {
+	min      int
+	max      int
+	interval time.Duration
+	current  atomic.Int64
+	// processed jobs and their total processing time (ns) since the last tuning
+	finished atomic.Int64
+	busy     atomic.Int64
+}
+
+func newAdaptivePrefetch(enabled bool, minPrefetch, maxPrefetch, prefetch int, interval time.Duration) (*adaptivePrefetch, error) {
+	if !enabled {
+		return nil, nil
+	}
+
+	if minPrefetch <= 0 || maxPrefetch < minPrefetch {
+		return nil, errors.Errorf("prefetch_min (%d) should be positive and not greater than prefetch_max (%d)", minPrefetch, maxPrefetch)
+	}
+
+	a := &adaptivePrefetch{
+		min:      minPrefetch,
+		max:      maxPrefetch,
+		interval: interval,
+	}
+	a.current.Store(int64(clamp(prefetch, minPrefetch, maxPrefetch)))
+
+	return a, nil
+}
+
+// done records the processed job
+func (a *adaptivePrefetch) done(d time.Duration) {
+	if a == nil {
+		return
+	}
+
+	a.finished.Add(1)
+	a.busy.Add(int64(d))
+}
+
+// batch returns the current prefetch or the configured one if the adaptive mode is disabled
+func (a *adaptivePrefetch) batch(d int) int {
+	if a == nil {
+		return d
+	}
+
+	return int(a.current.Load())
+}
+
+// next calculates the prefetch for the elapsed interval, false if there is nothing to tune (no processed jobs)
+func (a *adaptivePrefetch) next(elapsed, ackWait time.Duration) (int, bool) {
+	n := a.finished.Swap(0)
+	busy := a.busy.Swap(0)
+	if n == 0 {
+		return 0, false
+	}
+
+	rate := float64(n) / elapsed.Seconds()
+	latency := time.Duration(busy / n).Seconds()
+
+	target := math.Ceil(rate * latency * 2)
+	if ackWait > 0 {
+		target = math.Min(target, math.Max(1, rate*ackWait.Seconds()/2))
+	}
+
+	return clamp(int(target), a.min, a.max), true
+}
+
+// tunePrefetch periodically applies the adaptive prefetch to the active consumers
+func (c *Driver) tunePrefetch() {
+	a := c.adaptive
+	if a == nil {
+		return
+	}
+
+	ackWait := c.opts.Load().ackWait
+	if ackWait == 0 {
+		// server default
+		ackWait = time.Second * 30
+	}
+
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		last := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				elapsed := now.Sub(last)
+				last = now
+
+				n, ok := a.next(elapsed, ackWait)
+				if !ok || !c.listening() || int64(n) == a.current.Load() {
+					continue
+				}
+
+				a.current.Store(int64(n))
+				c.applyPrefetch(n)
+			case <-c.closeCh:
+				return
+			}
+		}
+	}()
+}
+
+// applyPrefetch updates the max_ack_pending of the active consumers, the prefetch is split between the lanes
+func (c *Driver) applyPrefetch(n int) {
+	if len(c.lanes) > 1 {
+		n = int(math.Max(1, float64(n/len(c.lanes))))
+	}
+
+	subs := c.subscriptions()
+	for i := 0; i < len(subs); i++ {
+		ci, err := subs[i].ConsumerInfo()
+		if err != nil {
+			c.log.Warn("failed to get the consumer info", zap.Error(err))
+			continue
+		}
+
+		cfg := ci.Config
+		cfg.MaxAckPending = n
+		_, err = c.js.UpdateConsumer(ci.Stream, &cfg)
+		if err != nil {
+			c.log.Warn("failed to update the consumer max_ack_pending", zap.String("consumer", ci.Name), zap.Error(err))
+			continue
+		}
+	}
+
+	c.log.Debug("prefetch was tuned", zap.String("pipeline", (*c.pipeline.Load()).Name()), zap.Int("prefetch", n))
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+
+	if v > hi {
+		return hi
+	}
+
+	return v
+}
@@ -0,0 +1,42 @@
+//go:build !race
+
+package natsjobs
+
+import (
+	"testing"
+)
+
+// the allocations ceilings of the hot paths (the race detector allocates on its own), raise them only together with
+// the benchmark results
+func TestAllocs(t *testing.T) {
+	c := newBenchUnpacker(t)
+	jsonMsg, protoMsg := benchMessages(t, c)
+	job := newBenchJob("1")
+
+	meta, err := jsonMsg.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		max  float64
+		fn   func()
+	}{
+		{"marshal", 1, func() {
+			buf, _ := c.pools.marshal(job)
+			c.pools.putBuffer(buf)
+		}},
+		{"unpack json", 15, func() { _ = c.unpack(jsonMsg, meta, &Item{}) }},
+		{"unpack proto", 16, func() { _ = c.unpack(protoMsg, meta, &Item{}) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocs := testing.AllocsPerRun(100, tt.fn)
+			if allocs > tt.max {
+				t.Errorf("%s allocates %v times per run, the ceiling is %v", tt.name, allocs, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package natsjobs
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// archiveRecord is the processed job published to the archive subject
+type archiveRecord struct {
+	ID         string              `json:"id"`
+	Job        string              `json:"job"`
+	Pipeline   string              `json:"pipeline"`
+	Payload    string              `json:"payload"`
+	Headers    map[string][]string `json:"headers"`
+	Sequence   uint64              `json:"sequence"`
+	ReceivedAt time.Time           `json:"received_at"`
+	AckedAt    time.Time           `json:"acked_at"`
+	// Duration from the delivery to the ack in milliseconds
+	Duration int64 `json:"duration_ms"`
+}
+
+// archiver publishes the acknowledged jobs to the archive subject in batches, asynchronously to the acks
+type archiver struct {
+	subject  string
+	batch    int
+	interval time.Duration
+	records  chan *archiveRecord
+	done     chan struct{}
+}
+
+func newArchiver(subject string, batch int, interval time.Duration) (*archiver, error) {
+	if subject == "" {
+		return nil, nil
+	}
+
+	if batch <= 0 || interval <= 0 {
+		return nil, errors.Errorf("archive_batch (%d) and archive_interval (%s) should be positive", batch, interval)
+	}
+
+	return &archiver{
+		subject:  subject,
+		batch:    batch,
+		interval: interval,
+		// records are dropped when the archive can't keep up with the acks
+		records: make(chan *archiveRecord, batch*4),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// archive records the acknowledged job, the acks are never blocked by the archive
+func (c *Driver) archive(item *Item) {
+	now := time.Now()
+	rec := &archiveRecord{
+		ID:         item.Ident,
+		Job:        item.Job,
+		Pipeline:   item.Options.Pipeline,
+		Payload:    item.Payload,
+		Headers:    item.Headers,
+		Sequence:   item.Options.seq,
+		ReceivedAt: item.Options.received,
+		AckedAt:    now,
+		Duration:   now.Sub(item.Options.received).Milliseconds(),
+	}
+
+	select {
+	case c.archiver.records <- rec:
+	default:
+		c.log.Warn("archive buffer is full, record dropped", zap.String("id", item.Ident))
+	}
+}
+
+// startArchive publishes the records when the batch is full or the interval elapsed, the rest is flushed on stop
+func (c *Driver) startArchive() {
+	a := c.archiver
+	if a == nil {
+		return
+	}
+
+	go func() {
+		defer close(a.done)
+
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		batch := make([]*archiveRecord, 0, a.batch)
+		for {
+			select {
+			case rec := <-a.records:
+				batch = append(batch, rec)
+				if len(batch) >= a.batch {
+					batch = c.flushArchive(batch)
+				}
+			case <-ticker.C:
+				batch = c.flushArchive(batch)
+			case <-c.closeCh:
+				for {
+					select {
+					case rec := <-a.records:
+						batch = append(batch, rec)
+					default:
+						c.flushArchive(batch)
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// flushArchive publishes the batch without waiting for each ack and returns the emptied batch
+func (c *Driver) flushArchive(batch []*archiveRecord) []*archiveRecord {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	for i := 0; i < len(batch); i++ {
+		data, err := json.Marshal(batch[i])
+		if err != nil {
+			c.log.Error("failed to marshal the archive record", zap.String("id", batch[i].ID), zap.Error(err))
+			continue
+		}
+
+		_, err = c.js.PublishAsync(c.archiver.subject, data, nats.MsgId(batch[i].ID+"-archived"))
+		if err != nil {
+			c.log.Error("failed to archive the job", zap.String("id", batch[i].ID), zap.Error(err))
+		}
+	}
+
+	select {
+	case <-c.js.PublishAsyncComplete():
+	case <-time.After(time.Second * 5):
+		c.log.Warn("archive batch wasn't acknowledged in time", zap.Int("records", len(batch)))
+	}
+
+	return batch[:0]
+}
+
+// waitArchive waits for the last batch to be flushed
+func (a *archiver) wait() {
+	if a == nil {
+		return
+	}
+
+	<-a.done
+}
@@ -0,0 +1,125 @@
+package natsjobs
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	// interval to check the queue length while the consumption is paused
+	backpressureCheckInterval time.Duration = time.Millisecond * 100
+	// interval to send InProgress for the held message while the consumption is paused
+	backpressureProgressInterval time.Duration = time.Second * 5
+	// default jobs plugin pipeline_size
+	defaultQueueCapacity = 1_000_000
+)
+
+// saturated checks if the priority queue reached the high watermark
+func (c *Driver) saturated() bool {
+	return c.highWatermark > 0 && c.queue.Len() >= c.highWatermark
+}
+
+// waitQueue pauses the consumption while the priority queue is saturated. The held message is kept in progress,
+// the rest of the messages are held by the server, since the consumer max_ack_pending is limited by the prefetch.
+// It returns false if the listener was stopped while waiting.
+func (c *Driver) waitQueue(m *nats.Msg, stopCh <-chan struct{}) bool {
+	if !c.saturated() {
+		return true
+	}
+
+	if c.paused.CompareAndSwap(false, true) {
+		c.log.Warn("priority queue is saturated, consumption paused",
+			zap.String("pipeline", (*c.pipeline.Load()).Name()),
+			zap.Uint64("len", c.queue.Len()),
+			zap.Uint64("high_watermark", c.highWatermark),
+		)
+	}
+
+	ticker := time.NewTicker(backpressureCheckInterval)
+	defer ticker.Stop()
+
+	lastProgress := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			if c.queue.Len() <= c.lowWatermark {
+				if c.paused.CompareAndSwap(true, false) {
+					c.log.Info("priority queue drained, consumption resumed",
+						zap.String("pipeline", (*c.pipeline.Load()).Name()),
+						zap.Uint64("len", c.queue.Len()),
+						zap.Uint64("low_watermark", c.lowWatermark),
+					)
+				}
+
+				return true
+			}
+
+			if time.Since(lastProgress) >= backpressureProgressInterval {
+				err := m.InProgress()
+				if err != nil {
+					c.log.Warn("failed to send InProgress state", zap.Error(err))
+				}
+
+				lastProgress = time.Now()
+			}
+		case <-stopCh:
+			return false
+		}
+	}
+}
+
+// maxAckPending returns the max_ack_pending for the consumer, the prefetch is split between the lanes
+func (c *Driver) maxAckPending() int {
+	n := c.opts.Load().prefetch
+	if len(c.lanes) > 1 {
+		n /= len(c.lanes)
+	}
+
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
+
+// watermarks returns the high and low watermarks with the low watermark defaulted to the half of the high one
+func watermarks(high, low uint64) (uint64, uint64) {
+	if high > 0 && low == 0 {
+		low = high / 2
+	}
+
+	return high, low
+}
+
+// waitInsert waits until the priority queue has a room for the message, the insert blocks the listener otherwise.
+// Several workers might pass the check at once, so the queue might exceed the queue_capacity by the number of workers.
+// It returns false if the insert_timeout expired or the listener was stopped while waiting.
+func (c *Driver) waitInsert(stopCh <-chan struct{}) bool {
+	if c.insertTimeout == 0 || c.queue.Len() < c.queueCapacity {
+		return true
+	}
+
+	ticker := time.NewTicker(backpressureCheckInterval)
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(c.insertTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if c.queue.Len() < c.queueCapacity {
+				return true
+			}
+		case <-timeout.C:
+			pipe := (*c.pipeline.Load()).Name()
+			c.log.Warn("priority queue is full, message is NAKed to be redelivered", zap.String("pipeline", pipe), zap.Uint64("len", c.queue.Len()), zap.Duration("insert_timeout", c.insertTimeout))
+			c.metrics.insertTimeout(pipe)
+			return false
+		case <-stopCh:
+			return false
+		}
+	}
+}
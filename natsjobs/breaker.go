@@ -0,0 +1,102 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// breaker fails the publishes fast after the threshold of the consecutive failures,
+// the JetStream is probed in the background until it's available again
+type breaker struct {
+	threshold     int64
+	probeInterval time.Duration
+	failures      atomic.Int64
+	open          atomic.Bool
+}
+
+func newBreaker(threshold int, probeInterval time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &breaker{
+		threshold:     int64(threshold),
+		probeInterval: probeInterval,
+	}
+}
+
+// isOpen checks if the publishes should fail fast
+func (b *breaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+
+	return b.open.Load()
+}
+
+// breakerAllow returns an error if the circuit is open
+func (c *Driver) breakerAllow() error {
+	if c.breaker.isOpen() {
+		return errors.Str("circuit breaker is open, JetStream is unavailable")
+	}
+
+	return nil
+}
+
+// breakerResult records the publish result and opens the circuit after the threshold of the consecutive failures
+func (c *Driver) breakerResult(err error) {
+	b := c.breaker
+	if b == nil {
+		return
+	}
+
+	// the server responded (e.g. optimistic concurrency conflict), the JetStream is available
+	var apiErr *nats.APIError
+	if err == nil || stderr.As(err, &apiErr) {
+		b.failures.Store(0)
+		return
+	}
+
+	if b.failures.Add(1) < b.threshold || !b.open.CompareAndSwap(false, true) {
+		return
+	}
+
+	pipe := (*c.pipeline.Load()).Name()
+	c.log.Error("circuit breaker opened, publishes fail fast", zap.String("pipeline", pipe), zap.Int64("failures", b.failures.Load()), zap.Error(err))
+	c.event(EventCircuitOpen, "circuit breaker opened, pipeline: "+pipe)
+
+	go c.probe()
+}
+
+// probe checks the JetStream availability and closes the circuit once it responds
+func (c *Driver) probe() {
+	b := c.breaker
+	ticker := time.NewTicker(b.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, err := c.js.StreamInfo(c.stream)
+			if err != nil {
+				c.log.Debug("circuit breaker probe failed", zap.Error(err))
+				continue
+			}
+
+			b.failures.Store(0)
+			b.open.Store(false)
+
+			pipe := (*c.pipeline.Load()).Name()
+			c.log.Info("circuit breaker closed, JetStream is available", zap.String("pipeline", pipe))
+			c.event(EventCircuitClosed, "circuit breaker closed, pipeline: "+pipe)
+			return
+		case <-c.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package natsjobs
+
+import (
+	stderr "errors"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/sdk/v4/utils"
+	"go.uber.org/zap"
+)
+
+// headerClaimCheck contains the object name with the job payload stored in the object store
+const headerClaimCheck string = "Rr-Claim-Check"
+
+// initObjectStore creates (or binds to) the object store bucket used for the oversized payloads
+func initObjectStore(js bucketAdmin, bucket string) (nats.ObjectStore, error) {
+	obs, err := js.ObjectStore(bucket)
+	if err == nil {
+		return obs, nil
+	}
+
+	if !stderr.Is(err, nats.ErrStreamNotFound) && !stderr.Is(err, nats.ErrBucketNotFound) {
+		return nil, err
+	}
+
+	return js.CreateObjectStore(&nats.ObjectStoreConfig{
+		Bucket:      bucket,
+		Description: "RoadRunner oversized jobs payloads",
+	})
+}
+
+func validateObjectBucket(maxInline int, bucket string) error {
+	if maxInline > 0 && bucket == "" {
+		return errors.Str("object_bucket should be set for the max_inline_payload")
+	}
+
+	return nil
+}
+
+// oversized checks if the job payload should be stored in the object store
+func (c *Driver) oversized(item *Item) bool {
+	return c.obs != nil && len(item.Payload) > c.maxInlinePayload
+}
+
+// claimCheck stores the job payload in the object store and returns the job without the payload
+// and the headers with the reference to the stored object
+func (c *Driver) claimCheck(item *Item, hdr nats.Header) (*Item, nats.Header, error) {
+	name := item.Ident + "-" + nuid.Next()
+
+	_, err := c.obs.PutBytes(name, utils.AsBytes(item.Payload))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if hdr == nil {
+		hdr = nats.Header{}
+	}
+
+	hdr.Set(headerClaimCheck, name)
+
+	return &Item{
+		Job:     item.Job,
+		Ident:   item.Ident,
+		Headers: item.Headers,
+		Options: &Options{
+			Priority: item.Options.Priority,
+			Pipeline: item.Options.Pipeline,
+			AutoAck:  item.Options.AutoAck,
+		},
+	}, hdr, nil
+}
+
+// resolveClaim loads the payload referenced by the message from the object store
+func (c *Driver) resolveClaim(m *nats.Msg, item *Item) error {
+	name := m.Header.Get(headerClaimCheck)
+	if name == "" {
+		return nil
+	}
+
+	if c.obs == nil {
+		return errors.Errorf("message payload is stored in the object %s, but the object_bucket is not configured", name)
+	}
+
+	data, err := c.obs.GetBytes(name)
+	if err != nil {
+		return err
+	}
+
+	item.Payload = utils.AsString(data)
+	item.Options.claim = name
+	item.Options.claimDelete = c.deleteClaim
+
+	return nil
+}
+
+// deleteClaim removes the stored payload once the message is acknowledged or terminated
+func (c *Driver) deleteClaim(name string) {
+	err := c.obs.Delete(name)
+	if err != nil {
+		c.log.Warn("failed to delete the stored payload", zap.String("object", name), zap.Error(err))
+	}
+}
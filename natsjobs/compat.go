@@ -0,0 +1,130 @@
+package natsjobs
+
+import (
+	"strconv"
+
+	"github.com/goccy/go-json"
+	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/sdk/v4/utils"
+)
+
+const (
+	compatLaravel string = "laravel"
+	compatSymfony string = "symfony"
+	compatAuto    string = "auto"
+
+	// CompatHeader contains the framework the job was decoded from (laravel or symfony)
+	CompatHeader string = "rr_compat"
+)
+
+// laravelJob is the Laravel queue payload (Queue::createPayload)
+type laravelJob struct {
+	UUID        string `json:"uuid"`
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Job         string `json:"job"`
+	Attempts    uint64 `json:"attempts"`
+	Data        *struct {
+		CommandName string `json:"commandName"`
+	} `json:"data"`
+}
+
+// symfonyEnvelope is the Symfony Messenger envelope encoded by the Messenger Serializer
+type symfonyEnvelope struct {
+	Body    *string           `json:"body"`
+	Headers map[string]string `json:"headers"`
+}
+
+func validateCompat(compat string) error {
+	switch compat {
+	case "", compatLaravel, compatSymfony, compatAuto:
+		return nil
+	default:
+		return errors.Errorf("unknown compat mode: %s, available: laravel, symfony, auto", compat)
+	}
+}
+
+// compatItem decodes the Laravel or Symfony Messenger message into the item, returns false if the message isn't in
+// the configured format (then it's decoded as usual)
+func (c *Driver) compatItem(data []byte, item *Item) bool {
+	if (c.compat == compatLaravel || c.compat == compatAuto) && c.laravelItem(data, item) {
+		return true
+	}
+
+	if (c.compat == compatSymfony || c.compat == compatAuto) && c.symfonyItem(data, item) {
+		return true
+	}
+
+	return false
+}
+
+// laravelItem keeps the whole Laravel payload, so the worker can handle it as the Laravel queue worker does
+func (c *Driver) laravelItem(data []byte, item *Item) bool {
+	lj := &laravelJob{}
+	err := json.Unmarshal(data, lj)
+	// the job handler and the display name are always set by Laravel
+	if err != nil || lj.Job == "" || lj.DisplayName == "" {
+		return false
+	}
+
+	name := lj.DisplayName
+	if lj.Data != nil && lj.Data.CommandName != "" {
+		name = lj.Data.CommandName
+	}
+
+	id := lj.UUID
+	if id == "" {
+		id = lj.ID
+	}
+	if id == "" {
+		id = c.genID()
+	}
+
+	*item = Item{
+		Job:     name,
+		Ident:   id,
+		Payload: utils.AsString(data),
+		Headers: map[string][]string{
+			CompatHeader: {compatLaravel},
+		},
+		Options: &Options{
+			Priority: c.defaultPriority,
+			Pipeline: auto,
+		},
+	}
+
+	// attempts made before the migration, the delivery count is added by the setAttempts
+	if lj.Attempts > 0 {
+		item.Headers[AttemptsHeader] = []string{strconv.FormatUint(lj.Attempts, 10)}
+	}
+
+	return true
+}
+
+// symfonyItem passes the message body and the stamps headers, the job name is the message class
+func (c *Driver) symfonyItem(data []byte, item *Item) bool {
+	se := &symfonyEnvelope{}
+	err := json.Unmarshal(data, se)
+	if err != nil || se.Body == nil || se.Headers["type"] == "" {
+		return false
+	}
+
+	hdr := make(map[string][]string, len(se.Headers)+1)
+	for k, v := range se.Headers {
+		hdr[k] = []string{v}
+	}
+	hdr[CompatHeader] = []string{compatSymfony}
+
+	*item = Item{
+		Job:     se.Headers["type"],
+		Ident:   c.genID(),
+		Payload: *se.Body,
+		Headers: hdr,
+		Options: &Options{
+			Priority: c.defaultPriority,
+			Pipeline: auto,
+		},
+	}
+
+	return true
+}
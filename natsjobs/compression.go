@@ -0,0 +1,19 @@
+package natsjobs
+
+import (
+	"github.com/roadrunner-server/errors"
+)
+
+const (
+	compressionNone string = "none"
+	compressionS2   string = "s2"
+)
+
+func validateCompression(compression string) error {
+	switch compression {
+	case "", compressionNone, compressionS2:
+		return nil
+	default:
+		return errors.Errorf("unknown stream compression: %s, available: none, s2", compression)
+	}
+}
@@ -1,34 +1,443 @@
 package natsjobs
 
 import (
+	"strings"
+	"time"
+
 	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
 )
 
 const (
-	pipeSubject            string = "subject"
-	pipeStream             string = "stream"
-	pipePrefetch           string = "prefetch"
-	pipeDeleteAfterAck     string = "delete_after_ack"
-	pipeDeliverNew         string = "deliver_new"
-	pipeRateLimit          string = "rate_limit"
-	pipeDeleteStreamOnStop string = "delete_stream_on_stop"
-	pipeConsumeAll         string = "consume_all"
+	pipeSubject                     string = "subject"
+	pipeStream                      string = "stream"
+	pipePrefetch                    string = "prefetch"
+	pipeDeleteAfterAck              string = "delete_after_ack"
+	pipeDeliverNew                  string = "deliver_new"
+	pipeDeliverLastPerSubject       string = "deliver_last_per_subject"
+	pipeRateLimit                   string = "rate_limit"
+	pipeDeleteStreamOnStop          string = "delete_stream_on_stop"
+	pipeConsumeAll                  string = "consume_all"
+	pipeTermOnNack                  string = "term_on_nack"
+	pipeDLQSubject                  string = "dlq_subject"
+	pipeProgressInterval            string = "progress_interval"
+	pipeCanarySubject               string = "canary_subject"
+	pipeCanaryWeight                string = "canary_weight"
+	pipeRequeueRepublish            string = "requeue_republish"
+	pipeIDGenerator                 string = "id_generator"
+	pipeJobName                     string = "job_name"
+	pipeDefaultPriority             string = "default_priority"
+	pipeJobSubjects                 string = "job_subjects"
+	pipeRawPayload                  string = "raw_payload"
+	pipeTTL                         string = "ttl"
+	pipeServerTTL                   string = "server_ttl"
+	pipeIdleHeartbeat               string = "idle_heartbeat"
+	pipeFlowControl                 string = "flow_control"
+	pipeSlowConsumerReduce          string = "slow_consumer_reduce"
+	pipeConsumerReplicas            string = "consumer_replicas"
+	pipeInactiveThreshold           string = "inactive_threshold"
+	pipeDescription                 string = "description"
+	pipeDurable                     string = "durable"
+	pipeEphemeral                   string = "ephemeral"
+	pipeAckWait                     string = "ack_wait"
+	pipeMaxDeliver                  string = "max_deliver"
+	pipeConsumerUpdate              string = "consumer_update"
+	pipeUpdateStream                string = "update_stream"
+	pipeRecreateStream              string = "recreate_stream"
+	pipePriorityHeader              string = "priority_header"
+	pipePriorityMap                 string = "priority_map"
+	pipeWorkers                     string = "workers"
+	pipeQueueHighWatermark          string = "queue_high_watermark"
+	pipeQueueLowWatermark           string = "queue_low_watermark"
+	pipeOutboxSize                  string = "outbox_size"
+	pipeOutboxOverflow              string = "outbox_overflow"
+	pipePublishRetries              string = "publish_retries"
+	pipePublishRetryBackoff         string = "publish_retry_backoff"
+	pipeBreakerThreshold            string = "breaker_threshold"
+	pipeBreakerProbeInterval        string = "breaker_probe_interval"
+	pipeUniqueJobs                  string = "unique_jobs"
+	pipeKVBucket                    string = "kv_bucket"
+	pipeUniqueTTL                   string = "unique_ttl"
+	pipeMaxInlinePayload            string = "max_inline_payload"
+	pipeObjectBucket                string = "object_bucket"
+	pipeSchemaFile                  string = "schema_file"
+	pipeHeaders                     string = "headers"
+	pipeMirror                      string = "mirror"
+	pipeSources                     string = "sources"
+	pipeSourceDomain                string = "source_domain"
+	pipeSourceAPIPrefix             string = "source_api_prefix"
+	pipeSourceDeliverPrefix         string = "source_deliver_prefix"
+	pipeRePublishSource             string = "republish_source"
+	pipeRePublishDestination        string = "republish_destination"
+	pipeRePublishHeadersOnly        string = "republish_headers_only"
+	pipeStateCacheTTL               string = "state_cache_ttl"
+	pipeDeliverAll                  string = "deliver_all"
+	pipeAckPolicy                   string = "ack_policy"
+	pipePull                        string = "pull"
+	pipeMaxWaiting                  string = "max_waiting"
+	pipeFetchBatch                  string = "fetch_batch"
+	pipeFetchTimeout                string = "fetch_timeout"
+	pipeJobsPerSecond               string = "jobs_per_second"
+	pipeJobsBurst                   string = "jobs_burst"
+	pipeArchiveSubject              string = "archive_subject"
+	pipeArchiveBatch                string = "archive_batch"
+	pipeArchiveInterval             string = "archive_interval"
+	pipeRedeliveryThreshold         string = "redelivery_threshold"
+	pipeBroadcastSubjects           string = "broadcast_subjects"
+	pipeRouting                     string = "routing"
+	pipeCompression                 string = "compression"
+	pipePlacementCluster            string = "placement_cluster"
+	pipePlacementTags               string = "placement_tags"
+	pipeSubjectTransformSource      string = "subject_transform_source"
+	pipeSubjectTransformDestination string = "subject_transform_destination"
+	pipeAllowRollupHdrs             string = "allow_rollup_hdrs"
+	pipeDenyDelete                  string = "deny_delete"
+	pipeDenyPurge                   string = "deny_purge"
+	pipeHeadersOnly                 string = "headers_only"
+	pipeSampleFreq                  string = "sample_freq"
+	pipeMicroService                string = "micro_service"
+	pipeMicroVersion                string = "micro_version"
+	pipeResultSubject               string = "result_subject"
+	pipeStatusBucket                string = "status_bucket"
+	pipeStatusTTL                   string = "status_ttl"
+	pipeConsumerPause               string = "consumer_pause"
+	pipePauseDeadline               string = "pause_deadline"
+	pipeDrainTimeout                string = "drain_timeout"
+	pipeAccountCreds                string = "account_creds"
+	pipeFormat                      string = "format"
+	pipeCompat                      string = "compat"
+	pipeContentTypes                string = "content_types"
+	pipeForwardHeaders              string = "forward_headers"
+	pipeDropHeaders                 string = "drop_headers"
+	pipeRealtimeSubjects            string = "realtime_subjects"
+	pipeInsertTimeout               string = "insert_timeout"
+	pipeQueueCapacity               string = "queue_capacity"
+	pipeMiddleware                  string = "middleware"
+	pipeAckStrategy                 string = "ack_strategy"
+	pipeAckMaxRetries               string = "ack_max_retries"
+	pipeAckBackoff                  string = "ack_backoff"
+	pipeAckMaxBackoff               string = "ack_max_backoff"
+	pipeMaxLag                      string = "max_lag"
+	pipeLagCheckInterval            string = "lag_check_interval"
+	pipeAdaptivePrefetch            string = "adaptive_prefetch"
+	pipePrefetchMin                 string = "prefetch_min"
+	pipePrefetchMax                 string = "prefetch_max"
+	pipeAdaptiveInterval            string = "adaptive_interval"
+	pipePriorityGroup               string = "priority_group"
+	pipePriorityMinPending          string = "priority_min_pending"
+	pipePriorityMinAckPending       string = "priority_min_ack_pending"
+	pipeDeliverGroup                string = "deliver_group"
+	pipeProfile                     string = "profile"
+	pipeOnDecodeError               string = "on_decode_error"
+	pipeDeleteBatch                 string = "delete_batch"
+	pipeDeleteInterval              string = "delete_interval"
+	pipeEraseAfterAck               string = "erase_after_ack"
 )
 
 type config struct {
 	// global
 	// NATS URL
-	Addr string `mapstructure:"addr"`
+	Addr string `mapstructure:"addr" scope:"global"`
+	// MaxReconnects is the max number of reconnect attempts, -1 (default) - infinite
+	MaxReconnects int `mapstructure:"max_reconnects" scope:"global"`
+	// ReconnectWait is the wait time between the reconnect attempts
+	ReconnectWait time.Duration `mapstructure:"reconnect_wait" scope:"global"`
+	// PingInterval is the interval between the client pings
+	PingInterval time.Duration `mapstructure:"ping_interval" scope:"global"`
+	// ConnectTimeout is the timeout for the connection dial
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout" scope:"global"`
+	// ReconnectBufSize is the size of the buffer for the messages published while reconnecting
+	ReconnectBufSize int `mapstructure:"reconnect_buf_size" scope:"global"`
+	// RetryOnFailedConnect keeps trying to connect in the background if the initial connect failed
+	RetryOnFailedConnect bool `mapstructure:"retry_on_failed_connect" scope:"global"`
+	// StartupTimeout is the total time to retry the initial connect and stream setup, default - 0 (no retries)
+	StartupTimeout time.Duration `mapstructure:"startup_timeout" scope:"global"`
+	// StartupBackoff is the initial delay between the startup retries, doubled on every retry (up to 30s)
+	StartupBackoff time.Duration `mapstructure:"startup_backoff" scope:"global"`
+	// TLS configures the secure connection, the tls:// scheme of the addr is enough for the system CAs
+	TLS *tlsConfig `mapstructure:"tls" scope:"global"`
+	// Context is the nats CLI context name (~/.config/nats/context/<name>.json) or path, the url, credentials and TLS
+	// settings are taken from it unless set explicitly
+	Context string `mapstructure:"context" scope:"global"`
+	// Creds is the path to the user credentials file (JWT and NKey seed)
+	Creds string `mapstructure:"creds" scope:"global"`
+	// CredsWatchInterval is the interval to check the creds and tls cert files, the driver reconnects with the
+	// fresh credentials when they are changed, 0 - disabled
+	CredsWatchInterval time.Duration `mapstructure:"creds_watch_interval" scope:"global"`
+	// InboxPrefix is the custom prefix for the reply subjects, needed for the restrictive account permissions
+	InboxPrefix string `mapstructure:"inbox_prefix" scope:"global"`
+	// Name is the connection name, {pipeline}, {hostname} and {pid} placeholders are supported
+	Name string `mapstructure:"name" scope:"global"`
+
+	// loaded from the nats context
+	user     string
+	password string
+	token    string
 
-	ConsumeAll         bool   `mapstructure:"consume_all"`
-	Priority           int64  `mapstructure:"priority"`
-	Subject            string `mapstructure:"subject"`
-	Stream             string `mapstructure:"stream"`
-	Prefetch           int    `mapstructure:"prefetch"`
+	ConsumeAll bool   `mapstructure:"consume_all"`
+	Priority   int64  `mapstructure:"priority"`
+	Subject    string `mapstructure:"subject"`
+	Stream     string `mapstructure:"stream"`
+	Prefetch   int    `mapstructure:"prefetch"`
+	// RateLimit is the push consumer delivery rate in bits per second, not supported by the pull consumer
 	RateLimit          uint64 `mapstructure:"rate_limit"`
 	DeleteAfterAck     bool   `mapstructure:"delete_after_ack"`
 	DeliverNew         bool   `mapstructure:"deliver_new"`
 	DeleteStreamOnStop bool   `mapstructure:"delete_stream_on_stop"`
+	// TermOnNack terminates nacked messages instead of redelivering them
+	TermOnNack bool `mapstructure:"term_on_nack"`
+	// DLQSubject is an optional subject to copy terminated messages to
+	DLQSubject string `mapstructure:"dlq_subject"`
+	// ProgressInterval is the interval to send InProgress for the messages being processed, 0 - disabled
+	ProgressInterval time.Duration `mapstructure:"progress_interval"`
+	// CanarySubject receives CanaryWeight percent of the pushed jobs
+	CanarySubject string `mapstructure:"canary_subject"`
+	// CanaryWeight is the percentage [0..100] of the jobs routed to the CanarySubject
+	CanaryWeight int `mapstructure:"canary_weight"`
+	// RequeueRepublish publishes a new copy of the requeued message and deletes the original one instead of NAK
+	RequeueRepublish bool `mapstructure:"requeue_republish"`
+	// IDGenerator is the name of the ID generator for the consume_all mode: uuid (default), ulid, ksuid or custom
+	IDGenerator string `mapstructure:"id_generator"`
+	// JobName is the job name assigned to the foreign messages in the consume_all mode
+	JobName string `mapstructure:"job_name"`
+	// DefaultPriority is the priority assigned to the foreign messages in the consume_all mode
+	DefaultPriority int64 `mapstructure:"default_priority"`
+	// PriorityHeader is the NATS header with the priority of the foreign messages, overrides the DefaultPriority
+	PriorityHeader string `mapstructure:"priority_header"`
+	// JobSubjects maps the subjects (wildcards are supported) of the foreign messages to the job names
+	JobSubjects map[string]string `mapstructure:"job_subjects"`
+	// RawPayload controls how the non-JSON payloads are passed in the consume_all mode:
+	// empty - JSON encoded (legacy), bytes - as is, base64 - base64 encoded
+	RawPayload string `mapstructure:"raw_payload"`
+	// TTL is the default jobs TTL, expired jobs are dropped on consume, 0 - never expire
+	TTL time.Duration `mapstructure:"ttl"`
+	// ServerTTL sets the per-message TTL header, the stream should allow it
+	ServerTTL bool `mapstructure:"server_ttl" server:"2.11.0"`
+	// IdleHeartbeat enables the push consumer heartbeats to detect a dead delivery, 0 - disabled
+	IdleHeartbeat time.Duration `mapstructure:"idle_heartbeat"`
+	// FlowControl enables the push consumer flow control, requires idle_heartbeat
+	FlowControl bool `mapstructure:"flow_control"`
+	// SlowConsumerReduce halves the consumer max_ack_pending on every slow consumer error
+	SlowConsumerReduce bool `mapstructure:"slow_consumer_reduce"`
+	// ConsumerReplicas is the number of the consumer replicas, 0 - inherited from the stream
+	ConsumerReplicas int `mapstructure:"consumer_replicas"`
+	// InactiveThreshold is the idle time after which the ephemeral consumer is removed by the server
+	InactiveThreshold time.Duration `mapstructure:"inactive_threshold"`
+	// Description is the consumer description
+	Description string `mapstructure:"description"`
+	// Durable is the durable consumer name, auto - derived from the stream, pipeline name and subject
+	Durable string `mapstructure:"durable"`
+	// Ephemeral uses the server generated consumer names, the consumers are removed on pause and stop
+	// and after the inactive_threshold. Mutually exclusive with the durable.
+	Ephemeral bool `mapstructure:"ephemeral"`
+	// AckWait is the time to wait for the ack before the redelivery, 0 - server default
+	AckWait time.Duration `mapstructure:"ack_wait"`
+	// MaxDeliver is the max number of the delivery attempts, 0 - server default (unlimited)
+	MaxDeliver int `mapstructure:"max_deliver"`
+	// ConsumerUpdate updates the existing durable consumer if its configuration differs from the pipeline
+	ConsumerUpdate bool `mapstructure:"consumer_update"`
+	// PriorityMap binds the filter subjects to the RR priorities, every subject is consumed by its own consumer
+	PriorityMap map[string]int64 `mapstructure:"priority_map"`
+	// Workers is the number of goroutines unpacking the messages, 1 (default) - ordered mode
+	Workers int `mapstructure:"workers"`
+	// QueueHighWatermark pauses the consumption when the priority queue reaches this length, 0 - disabled
+	QueueHighWatermark uint64 `mapstructure:"queue_high_watermark"`
+	// QueueLowWatermark resumes the paused consumption, default - half of the high watermark
+	QueueLowWatermark uint64 `mapstructure:"queue_low_watermark"`
+	// OutboxSize is the max number of the pushes buffered while the connection is down, 0 - disabled
+	OutboxSize int `mapstructure:"outbox_size"`
+	// OutboxOverflow is the behavior when the outbox is full: error (default) or drop_oldest
+	OutboxOverflow string `mapstructure:"outbox_overflow"`
+	// PublishRetries is the number of the publish retries on the transient errors (timeouts, no responders), 0 - disabled
+	PublishRetries int `mapstructure:"publish_retries"`
+	// PublishRetryBackoff is the initial delay between the publish retries, doubled on every retry
+	PublishRetryBackoff time.Duration `mapstructure:"publish_retry_backoff"`
+	// BreakerThreshold is the number of the consecutive publish failures to open the circuit breaker, 0 - disabled
+	BreakerThreshold int `mapstructure:"breaker_threshold"`
+	// BreakerProbeInterval is the interval to probe the JetStream while the circuit is open
+	BreakerProbeInterval time.Duration `mapstructure:"breaker_probe_interval"`
+	// UniqueJobs records the pushed job IDs in the KV bucket and skips the duplicates within the UniqueTTL
+	UniqueJobs bool `mapstructure:"unique_jobs"`
+	// KVBucket is the KV bucket name for the unique jobs, created if not exists
+	KVBucket string `mapstructure:"kv_bucket"`
+	// UniqueTTL is the time the job ID is kept in the KV bucket, applied only on the bucket creation
+	UniqueTTL time.Duration `mapstructure:"unique_ttl"`
+	// MaxInlinePayload is the max payload size published inline, larger payloads are stored in the ObjectBucket, 0 - disabled
+	MaxInlinePayload int `mapstructure:"max_inline_payload"`
+	// ObjectBucket is the object store bucket for the oversized payloads, created if not exists
+	ObjectBucket string `mapstructure:"object_bucket"`
+	// SchemaFile is the JSON Schema file to validate the pushed jobs payloads against
+	SchemaFile string `mapstructure:"schema_file"`
+	// Headers are attached to every published message, {pipeline} and {hostname} placeholders are supported
+	Headers map[string]string `mapstructure:"headers"`
+	// Mirror creates the stream as a mirror of another stream, the pipeline consumes the read-replica
+	Mirror string `mapstructure:"mirror"`
+	// Sources creates the stream sourcing the messages from the other streams
+	Sources []string `mapstructure:"sources"`
+	// SourceDomain is the JetStream domain of the mirrored or sourced streams
+	SourceDomain string `mapstructure:"source_domain"`
+	// SourceAPIPrefix is the JetStream API prefix of the mirrored or sourced streams in another account
+	SourceAPIPrefix string `mapstructure:"source_api_prefix"`
+	// SourceDeliverPrefix is the deliver prefix of the mirrored or sourced streams in another account
+	SourceDeliverPrefix string `mapstructure:"source_deliver_prefix"`
+	// RePublishSource is the subject pattern of the stored messages to republish, empty - all the stream subjects
+	RePublishSource string `mapstructure:"republish_source"`
+	// RePublishDestination is the subject pattern to republish the stored messages to, empty - disabled
+	RePublishDestination string `mapstructure:"republish_destination"`
+	// RePublishHeadersOnly republishes only the headers without the payload
+	RePublishHeadersOnly bool `mapstructure:"republish_headers_only"`
+	// StateCacheTTL is the time the consumer info is cached for State(), default - 1s, negative - disabled
+	StateCacheTTL time.Duration `mapstructure:"state_cache_ttl"`
+	// DeliverAll replays all the stream messages, the progress is reported via State() and the ReplayStatus RPC
+	DeliverAll bool `mapstructure:"deliver_all"`
+	// AckPolicy is the consumer ack policy: explicit (default), none or all
+	AckPolicy string `mapstructure:"ack_policy"`
+	// Pull uses the pull consumer, the messages are fetched in batches instead of being pushed by the server
+	Pull bool `mapstructure:"pull"`
+	// MaxWaiting is the max number of the inflight fetch requests of the pull consumer, 0 - server default
+	MaxWaiting int `mapstructure:"max_waiting"`
+	// FetchBatch is the max number of the messages per fetch, default - prefetch
+	FetchBatch int `mapstructure:"fetch_batch"`
+	// FetchTimeout is the fetch long-poll expiry, lower - lower latency, higher - less chatter
+	FetchTimeout time.Duration `mapstructure:"fetch_timeout"`
+	// JobsPerSecond is the max number of the consumed jobs per second (push and pull consumers), 0 - unlimited
+	JobsPerSecond int `mapstructure:"jobs_per_second"`
+	// JobsBurst is the max number of the jobs consumed at once after the idle period, default - jobs_per_second
+	JobsBurst int `mapstructure:"jobs_burst"`
+	// Schedules are the recurring jobs published by the pipeline, supported only in the configuration file
+	Schedules []*schedule `mapstructure:"schedules"`
+	// ScheduleBucket is the KV bucket used to elect the instance publishing the schedule tick, default - rr_schedules
+	ScheduleBucket string `mapstructure:"schedule_bucket"`
+	// ArchiveSubject receives the acknowledged jobs with the processing timings for the audit, should be bound to a stream
+	ArchiveSubject string `mapstructure:"archive_subject"`
+	// ArchiveBatch is the max number of the archive records published at once, default - 100
+	ArchiveBatch int `mapstructure:"archive_batch"`
+	// ArchiveInterval is the max time the archive record waits for the batch, default - 1s
+	ArchiveInterval time.Duration `mapstructure:"archive_interval"`
+	// RedeliveryThreshold is the number of deliveries after which the message is reported as a possible poison message, 0 - disabled
+	RedeliveryThreshold uint64 `mapstructure:"redelivery_threshold"`
+	// ExtraStreams are consumed in addition to the pipeline stream, all the messages feed the pipeline priority queue.
+	// Supported only in the configuration file.
+	ExtraStreams []*extraStream `mapstructure:"extra_streams"`
+	// BroadcastSubjects receive every pushed job in addition to the pipeline subject, the push fails if any of the publishes failed
+	BroadcastSubjects []string `mapstructure:"broadcast_subjects"`
+	// Routing binds the job name patterns (* - any characters) to the subjects the jobs are pushed to,
+	// the subjects should be bound to the pipeline stream to be consumed by the pipeline
+	Routing map[string]string `mapstructure:"routing"`
+	// DeliverLastPerSubject starts the new consumer from the latest message of every subject matching the filter subject
+	DeliverLastPerSubject bool `mapstructure:"deliver_last_per_subject"`
+	// Compression of the stream created by the pipeline: none or s2, the existing streams are not updated
+	Compression string `mapstructure:"compression" server:"2.10.0"`
+	// Placement of the stream created by the pipeline, the pipelines declared at runtime use placement_cluster and placement_tags
+	Placement placement `mapstructure:"placement"`
+	// SubjectTransform of the stream created by the pipeline, the destination should be covered by the pipeline subject.
+	// The pipelines declared at runtime use subject_transform_source and subject_transform_destination.
+	SubjectTransform subjectTransform `mapstructure:"subject_transform" server:"2.10.0"`
+	// AllowRollupHdrs allows the Nats-Rollup header to purge the subject or the stream on publish (stream creation only)
+	AllowRollupHdrs bool `mapstructure:"allow_rollup_hdrs"`
+	// DenyDelete denies the messages deletion of the created stream, can't be used with the delete_after_ack
+	DenyDelete bool `mapstructure:"deny_delete"`
+	// DenyPurge denies the purge of the created stream, the Purge RPC fails
+	DenyPurge bool `mapstructure:"deny_purge"`
+	// HeadersOnly delivers only the headers and the metadata, the jobs have no payload,
+	// the omitted payload size is passed in the rr_nats_msg_size header
+	HeadersOnly bool `mapstructure:"headers_only"`
+	// SampleFreq is the percentage of the acks the server sends the ack metrics for, e.g. 10%,
+	// the sampled ack latencies are exported as the ack_latency_seconds metric
+	SampleFreq string `mapstructure:"sample_freq"`
+	// MicroService registers the pipeline as the NATS micro service with the name, empty - disabled
+	MicroService string `mapstructure:"micro_service"`
+	// MicroVersion is the SemVer version of the micro service, default - 1.0.0
+	MicroVersion string `mapstructure:"micro_version"`
+	// ResultSubject receives the envelope with the job ID, status (acked, nacked, requeued, terminated), duration
+	// and the worker response when the worker finished the job
+	ResultSubject string `mapstructure:"result_subject"`
+	// StatusBucket is the KV bucket the jobs states (queued, active, done, failed) are written to, empty - disabled
+	StatusBucket string `mapstructure:"status_bucket"`
+	// StatusTTL is the time the job state is kept in the status bucket, default - 24h
+	StatusTTL time.Duration `mapstructure:"status_ttl"`
+	// ConsumerPause pauses the consumers server-side on Pause instead of draining the subscriptions,
+	// the delivery state is kept. Falls back to the drain if the server doesn't support it.
+	ConsumerPause bool `mapstructure:"consumer_pause" server:"2.11.0"`
+	// PauseDeadline is the max time the consumers are paused server-side, the server resumes the delivery after it, default - 1 year
+	PauseDeadline time.Duration `mapstructure:"pause_deadline"`
+	// Format is the encoding of the pushed jobs: json (default) or proto (see envelope.proto), the consumers
+	// decode both
+	Format string `mapstructure:"format"`
+	// Compat decodes the Laravel queue and Symfony Messenger JSON messages: laravel, symfony or auto
+	Compat string `mapstructure:"compat"`
+	// ContentTypes maps the message Content-Type header to the decoder (rr-json, proto, raw, cloudevents), extends the
+	// defaults: application/x-rr-job+json, application/x-rr-job+proto, application/cloudevents+json, application/octet-stream
+	ContentTypes map[string]string `mapstructure:"content_types"`
+	// ForwardHeaders, if set, lists the only job headers passed to the workers and published to NATS,
+	// the trailing * matches any suffix
+	ForwardHeaders []string `mapstructure:"forward_headers"`
+	// DropHeaders lists the job headers removed before passing the job to the workers and publishing it to NATS
+	DropHeaders []string `mapstructure:"drop_headers"`
+	// RealtimeSubjects are the latency-critical subjects (wildcards supported), their messages bypass the listener order,
+	// the jobs rate limit and the queue backpressure, and are inserted with the highest priority
+	RealtimeSubjects []string `mapstructure:"realtime_subjects"`
+	// InsertTimeout is the max time to wait for the room in the full priority queue, the message is NAKed after it,
+	// 0 - wait forever
+	InsertTimeout time.Duration `mapstructure:"insert_timeout"`
+	// QueueCapacity is the priority queue size, should match the jobs pipeline_size, default - 1000000
+	QueueCapacity uint64 `mapstructure:"queue_capacity"`
+	// Middleware lists the names of the middlewares provided by the plugins, called in the listed order
+	Middleware []string `mapstructure:"middleware"`
+	// AckStrategy decides how the failed jobs are acknowledged: default (nak), always-ack, retry-then-term,
+	// retry-backoff or the strategy provided by a plugin
+	AckStrategy string `mapstructure:"ack_strategy"`
+	// AckMaxRetries is the number of redeliveries before the job is terminated, required by the retry-then-term,
+	// 0 - unlimited for the retry-backoff
+	AckMaxRetries uint64 `mapstructure:"ack_max_retries"`
+	// AckBackoff is the initial redelivery delay of the retry-backoff strategy, doubled on every attempt, default - 1s
+	AckBackoff time.Duration `mapstructure:"ack_backoff"`
+	// AckMaxBackoff is the max redelivery delay of the retry-backoff strategy, default - 1m
+	AckMaxBackoff time.Duration `mapstructure:"ack_max_backoff"`
+	// MaxLag is the number of the pending messages to notify about (log, metric, event), 0 - disabled
+	MaxLag uint64 `mapstructure:"max_lag"`
+	// LagCheckInterval is the interval to check the consumer lag, default - 10s
+	LagCheckInterval time.Duration `mapstructure:"lag_check_interval"`
+	// AdaptivePrefetch tunes the prefetch between the prefetch_min and prefetch_max by the processing rate and latency
+	AdaptivePrefetch bool `mapstructure:"adaptive_prefetch"`
+	// PrefetchMin is the min adaptive prefetch, default - 1
+	PrefetchMin int `mapstructure:"prefetch_min"`
+	// PrefetchMax is the max adaptive prefetch, default - prefetch
+	PrefetchMax int `mapstructure:"prefetch_max"`
+	// AdaptiveInterval is the interval to tune the prefetch, default - 10s
+	AdaptiveInterval time.Duration `mapstructure:"adaptive_interval"`
+	// PriorityGroup is the consumer priority group with the overflow policy (nats-server 2.11+), pull durable consumers only
+	PriorityGroup string `mapstructure:"priority_group"`
+	// PriorityMinPending - the instance receives the messages only if the consumer has more pending messages (standby)
+	PriorityMinPending int64 `mapstructure:"priority_min_pending"`
+	// PriorityMinAckPending - the instance receives the messages only if the consumer has more unacknowledged messages (standby)
+	PriorityMinAckPending int64 `mapstructure:"priority_min_ack_pending"`
+	// DeliverGroup is the push consumer deliver (queue) group, the RR instances share the durable consumer
+	DeliverGroup string `mapstructure:"deliver_group"`
+	// Profile is the preset of the grouped defaults: durability, throughput or latency, the explicit options take precedence
+	Profile string `mapstructure:"profile"`
+	// OnDecodeError is the malformed message handling: term, dlq or requeue, default - dlq if the dlq_subject is set, term otherwise
+	OnDecodeError string `mapstructure:"on_decode_error"`
+	// DeleteBatch deletes the acknowledged messages (delete_after_ack) in batches asynchronously to the acks, 0 - on ack
+	DeleteBatch int `mapstructure:"delete_batch"`
+	// DeleteInterval is the max delay of the batch deletes, default - 100ms
+	DeleteInterval time.Duration `mapstructure:"delete_interval"`
+	// EraseAfterAck overwrites the payloads of the deleted messages (slower secure delete), requires the delete_after_ack
+	EraseAfterAck bool `mapstructure:"erase_after_ack"`
+	// AccountCreds is the credentials file of the pipeline connection, overrides the global creds, so the pipeline
+	// can authenticate as a different NATS account
+	AccountCreds string `mapstructure:"account_creds"`
+	// DrainTimeout is the max time to drain the connection on stop, the connection is force-closed after it, default - 30s
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+	// UpdateStream adds the pipeline subject to the existing stream if the stream doesn't cover it
+	UpdateStream bool `mapstructure:"update_stream"`
+	// RecreateStream recreates the stream and the consumer if they were deleted at runtime
+	RecreateStream bool `mapstructure:"recreate_stream"`
+
+	// AccountInfoInterval is the interval to query the JetStream account usage, 0 - disabled
+	AccountInfoInterval time.Duration `mapstructure:"account_info_interval" scope:"global"`
+	// AccountUsageThreshold is the account resource usage percentage to notify about
+	AccountUsageThreshold int `mapstructure:"account_usage_threshold" scope:"global"`
 }
 
 func (c *config) InitDefaults() {
@@ -36,6 +445,30 @@ func (c *config) InitDefaults() {
 		c.Addr = nats.DefaultURL
 	}
 
+	if c.MaxReconnects == 0 {
+		c.MaxReconnects = -1
+	}
+
+	if c.ReconnectWait == 0 {
+		c.ReconnectWait = time.Second
+	}
+
+	if c.StartupBackoff == 0 {
+		c.StartupBackoff = time.Second
+	}
+
+	if c.PingInterval == 0 {
+		c.PingInterval = time.Second * 10
+	}
+
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = time.Minute
+	}
+
+	if c.ReconnectBufSize == 0 {
+		c.ReconnectBufSize = 20 * 1024 * 1024
+	}
+
 	if c.RateLimit == 0 {
 		c.RateLimit = 1000
 	}
@@ -55,4 +488,166 @@ func (c *config) InitDefaults() {
 	if c.Prefetch == 0 {
 		c.Prefetch = 10
 	}
+
+	if c.IDGenerator == "" {
+		c.IDGenerator = idGeneratorUUID
+	}
+
+	if c.AccountUsageThreshold == 0 {
+		c.AccountUsageThreshold = 80
+	}
+
+	if c.JobName == "" {
+		c.JobName = auto
+	}
+
+	if c.PublishRetryBackoff == 0 {
+		c.PublishRetryBackoff = time.Millisecond * 100
+	}
+
+	if c.BreakerProbeInterval == 0 {
+		c.BreakerProbeInterval = time.Second * 5
+	}
+
+	if c.UniqueTTL == 0 {
+		c.UniqueTTL = time.Hour
+	}
+
+	if c.StateCacheTTL == 0 {
+		c.StateCacheTTL = time.Second
+	}
+
+	if c.AckPolicy == "" {
+		c.AckPolicy = ackPolicyExplicit
+	}
+
+	if c.FetchBatch == 0 {
+		c.FetchBatch = c.Prefetch
+	}
+
+	if c.FetchTimeout == 0 {
+		c.FetchTimeout = time.Second * 5
+	}
+
+	if len(c.Schedules) > 0 && c.ScheduleBucket == "" {
+		c.ScheduleBucket = "rr_schedules"
+	}
+
+	if c.ArchiveBatch == 0 {
+		c.ArchiveBatch = 100
+	}
+
+	if c.ArchiveInterval == 0 {
+		c.ArchiveInterval = time.Second
+	}
+
+	if c.MicroService != "" && c.MicroVersion == "" {
+		c.MicroVersion = "1.0.0"
+	}
+
+	if c.StatusTTL == 0 {
+		c.StatusTTL = time.Hour * 24
+	}
+
+	if c.AckBackoff == 0 {
+		c.AckBackoff = time.Second
+	}
+
+	if c.AckMaxBackoff == 0 {
+		c.AckMaxBackoff = time.Minute
+	}
+
+	if c.PrefetchMin == 0 {
+		c.PrefetchMin = 1
+	}
+
+	if c.PrefetchMax == 0 {
+		c.PrefetchMax = c.Prefetch
+	}
+
+	if c.AdaptiveInterval == 0 {
+		c.AdaptiveInterval = time.Second * 10
+	}
+
+	if c.LagCheckInterval == 0 {
+		c.LagCheckInterval = time.Second * 10
+	}
+
+	if c.OnDecodeError == "" {
+		c.OnDecodeError = onDecodeErrorDefault(c.DLQSubject)
+	}
+
+	if c.DeleteInterval == 0 {
+		c.DeleteInterval = time.Millisecond * 100
+	}
+
+	if c.QueueCapacity == 0 {
+		c.QueueCapacity = defaultQueueCapacity
+	}
+
+	if c.Format == "" {
+		c.Format = formatJSON
+	}
+
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = time.Second * 30
+	}
+
+	if c.Workers == 0 {
+		c.Workers = 1
+	}
+
+	if c.DefaultPriority == 0 {
+		c.DefaultPriority = 10
+	}
+}
+
+// pipeList reads a list from the pipeline, the value might be a list or a comma separated string
+func pipeList(pipe jobs.Pipeline, name string) []string {
+	if !pipe.Has(name) {
+		return nil
+	}
+
+	switch v := pipe.Get(name).(type) {
+	case []string:
+		return v
+	case []any:
+		res := make([]string, 0, len(v))
+		for i := 0; i < len(v); i++ {
+			if s, ok := v[i].(string); ok && s != "" {
+				res = append(res, s)
+			}
+		}
+
+		return res
+	default:
+		res := make([]string, 0, 1)
+		for _, s := range strings.Split(pipe.String(name, ""), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				res = append(res, s)
+			}
+		}
+
+		return res
+	}
+}
+
+// pipeDuration reads a duration from the pipeline, the value might be a duration string (10s) or a number of seconds
+func pipeDuration(pipe jobs.Pipeline, name string, d time.Duration) time.Duration {
+	if !pipe.Has(name) {
+		return d
+	}
+
+	if s := pipe.String(name, ""); s != "" {
+		dur, err := time.ParseDuration(s)
+		if err == nil {
+			return dur
+		}
+	}
+
+	if i := pipe.Int(name, 0); i > 0 {
+		return time.Second * time.Duration(i)
+	}
+
+	return d
 }
@@ -0,0 +1,205 @@
+package natsjobs
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
+)
+
+// minRateLimit is the lowest sane push consumer rate limit (bits per second), lower values stall the delivery
+const minRateLimit uint64 = 8
+
+// ConfigError is the configuration validation error, Key is the offending option
+type ConfigError struct {
+	Key    string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return "nats configuration: " + e.Key + ": " + e.Reason
+}
+
+// ConfigErrors are all the validation errors of the configuration
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for i := 0; i < len(e); i++ {
+		msgs = append(msgs, e[i].Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the merged (global and pipeline) configuration with the defaults applied,
+// returns ConfigErrors listing all the offending options or nil
+func (c *config) Validate() error {
+	var errs ConfigErrors
+	add := func(key, reason string) {
+		errs = append(errs, &ConfigError{Key: key, Reason: reason})
+	}
+
+	auth := make([]string, 0, 3)
+	for _, addr := range strings.Split(c.Addr, ",") {
+		addr = strings.TrimSpace(addr)
+		// the client defaults to the nats scheme
+		if !strings.Contains(addr, "://") {
+			addr = "nats://" + addr
+		}
+
+		u, err := url.Parse(addr)
+		if err != nil {
+			add("addr", err.Error())
+			continue
+		}
+
+		switch u.Scheme {
+		case "nats", "tls", "ws", "wss":
+		default:
+			add("addr", "unsupported scheme of "+addr+", supported: nats, tls, ws, wss")
+			continue
+		}
+
+		if u.Host == "" {
+			add("addr", "no host in "+addr)
+		}
+
+		if u.User != nil && len(auth) == 0 {
+			auth = append(auth, "addr (user info)")
+		}
+	}
+
+	if c.Creds != "" {
+		auth = append(auth, "creds")
+	}
+
+	if c.token != "" {
+		auth = append(auth, "token")
+	}
+
+	if c.user != "" || c.password != "" {
+		auth = append(auth, "user/password")
+	}
+
+	if len(auth) > 1 {
+		add("creds", "authentication options are mutually exclusive, got: "+strings.Join(auth, ", "))
+	}
+
+	if c.Prefetch <= 0 {
+		add(pipePrefetch, "should be positive")
+	}
+
+	if !c.Pull && c.RateLimit < minRateLimit {
+		add(pipeRateLimit, "should be at least 8 bits per second")
+	}
+
+	if reason := subjectError(c.Subject, true); reason != "" {
+		add(pipeSubject, reason)
+	}
+
+	if reason := nameError(c.Stream); reason != "" {
+		add(pipeStream, reason)
+	}
+
+	if c.Durable != "" {
+		if reason := nameError(c.Durable); reason != "" {
+			add(pipeDurable, reason)
+		}
+	}
+
+	// the publish subjects can't have the wildcards
+	publish := [...][2]string{
+		{pipeDLQSubject, c.DLQSubject},
+		{pipeCanarySubject, c.CanarySubject},
+		{pipeArchiveSubject, c.ArchiveSubject},
+		{pipeResultSubject, c.ResultSubject},
+	}
+	for i := 0; i < len(publish); i++ {
+		if publish[i][1] == "" {
+			continue
+		}
+
+		if reason := subjectError(publish[i][1], false); reason != "" {
+			add(publish[i][0], reason)
+		}
+	}
+
+	for i := 0; i < len(c.BroadcastSubjects); i++ {
+		if reason := subjectError(c.BroadcastSubjects[i], false); reason != "" {
+			add(pipeBroadcastSubjects, reason)
+		}
+	}
+
+	for i := 0; i < len(c.RealtimeSubjects); i++ {
+		if reason := subjectError(c.RealtimeSubjects[i], true); reason != "" {
+			add(pipeRealtimeSubjects, reason)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// withPipeline sets the validated pipeline options of the pipeline declared at runtime
+func (c *config) withPipeline(pipe jobs.Pipeline) {
+	c.Subject = pipe.String(pipeSubject, "default")
+	c.Stream = pipe.String(pipeStream, "default-stream")
+	c.Durable = pipe.String(pipeDurable, "")
+	c.Prefetch = pipe.Int(pipePrefetch, 100)
+	c.Pull = pipe.Bool(pipePull, false)
+	c.RateLimit = uint64(pipe.Int(pipeRateLimit, 1000))
+	c.DLQSubject = pipe.String(pipeDLQSubject, "")
+	c.CanarySubject = pipe.String(pipeCanarySubject, "")
+	c.ArchiveSubject = pipe.String(pipeArchiveSubject, "")
+	c.ResultSubject = pipe.String(pipeResultSubject, "")
+	c.BroadcastSubjects = pipeList(pipe, pipeBroadcastSubjects)
+	c.RealtimeSubjects = pipeList(pipe, pipeRealtimeSubjects)
+}
+
+// subjectError returns the reason the subject is invalid or an empty string
+func subjectError(subject string, wildcards bool) string {
+	if subject == "" {
+		return "subject is empty"
+	}
+
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return "subject " + subject + " contains whitespaces"
+	}
+
+	tokens := strings.Split(subject, ".")
+	for i := 0; i < len(tokens); i++ {
+		switch {
+		case tokens[i] == "":
+			return "subject " + subject + " has an empty token"
+		case tokens[i] == "*" || tokens[i] == ">":
+			if !wildcards {
+				return "subject " + subject + " can't have the wildcards"
+			}
+
+			if tokens[i] == ">" && i != len(tokens)-1 {
+				return "subject " + subject + " has the > wildcard not as the last token"
+			}
+		case strings.ContainsAny(tokens[i], "*>"):
+			return "subject " + subject + " has the wildcard within the token " + tokens[i]
+		}
+	}
+
+	return ""
+}
+
+// nameError returns the reason the stream or consumer name is invalid or an empty string
+func nameError(name string) string {
+	if name == "" {
+		return "name is empty"
+	}
+
+	if strings.ContainsAny(name, " \t\r\n.*>/\\") {
+		return "name " + name + " can't contain whitespaces, ., *, >, / and \\"
+	}
+
+	return ""
+}
@@ -0,0 +1,130 @@
+package natsjobs
+
+import (
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// connOptions returns the NATS connection options from the global configuration
+func connOptions(conf *config, pipeline string, log *zap.Logger) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.NoEcho(),
+		nats.Timeout(conf.ConnectTimeout),
+		nats.MaxReconnects(conf.MaxReconnects),
+		nats.PingInterval(conf.PingInterval),
+		nats.ReconnectWait(conf.ReconnectWait),
+		nats.ReconnectBufSize(conf.ReconnectBufSize),
+		nats.RetryOnFailedConnect(conf.RetryOnFailedConnect),
+	}
+
+	if conf.InboxPrefix != "" {
+		opts = append(opts, nats.CustomInboxPrefix(conf.InboxPrefix))
+	}
+
+	if name := connName(conf.Name, pipeline); name != "" {
+		opts = append(opts, nats.Name(name))
+	}
+
+	if conf.user != "" {
+		opts = append(opts, nats.UserInfo(conf.user, conf.password))
+	}
+
+	if conf.token != "" {
+		opts = append(opts, nats.Token(conf.token))
+	}
+
+	// the files are read on every connect
+	if conf.Creds != "" {
+		opts = append(opts, nats.UserCredentials(conf.Creds))
+	}
+
+	tlsOpts, err := tlsOptions(conf.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(opts, tlsOpts...), nil
+}
+
+// driverHandlers returns the connection handlers bound to the driver
+func driverHandlers(drv *atomic.Pointer[Driver], log *zap.Logger) []nats.Option {
+	return []nats.Option{
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			if d := drv.Load(); d != nil {
+				d.asyncError(sub, err)
+				return
+			}
+
+			log.Error("nats async error", zap.Error(err))
+		}),
+		nats.ClosedHandler(func(*nats.Conn) {
+			if d := drv.Load(); d != nil {
+				d.connClosed()
+			}
+		}),
+		nats.LameDuckModeHandler(func(conn *nats.Conn) {
+			if d := drv.Load(); d != nil {
+				d.lameDuck(conn)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			reconnectHandler(log)(conn)
+			if d := drv.Load(); d != nil {
+				d.lifecycle(EventReconnected, "connection restored")
+				go d.flushOutbox()
+			}
+		}),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			disconnectHandler(log)(conn, err)
+			if d := drv.Load(); d != nil && !d.stopping.Load() {
+				d.lifecycle(EventDisconnected, "connection lost")
+			}
+		}),
+		// called only for the delayed initial connect (retry_on_failed_connect)
+		nats.ConnectHandler(func(*nats.Conn) {
+			if d := drv.Load(); d != nil {
+				d.lifecycle(EventConnected, "connection established")
+			}
+		}),
+	}
+}
+
+// connClosed is called when the connection is closed permanently (reconnects exhausted or closed by the driver)
+func (c *Driver) connClosed() {
+	if c.stopping.Load() {
+		return
+	}
+
+	c.closed.Store(true)
+
+	pipe := (*c.pipeline.Load()).Name()
+	c.log.Error("connection closed permanently, pipeline is not ready", zap.String("pipeline", pipe))
+	c.event(EventConnectionClosed, "connection closed permanently, pipeline: "+pipe)
+}
+
+// lameDuck is called when the server enters the lame duck mode (is about to shut down),
+// the client reconnects to another server of the cluster when the server closes the connection
+func (c *Driver) lameDuck(conn *nats.Conn) {
+	pipe := (*c.pipeline.Load()).Name()
+	c.log.Warn("server entered the lame duck mode", zap.String("pipeline", pipe), zap.String("url", conn.ConnectedUrl()))
+	c.event(EventLameDuckMode, "server entered the lame duck mode, pipeline: "+pipe)
+}
+
+func reconnectHandler(log *zap.Logger) func(*nats.Conn) {
+	return func(conn *nats.Conn) {
+		log.Warn("connection lost, reconnecting", zap.String("url", conn.ConnectedUrl()))
+	}
+}
+
+func disconnectHandler(log *zap.Logger) func(*nats.Conn, error) {
+	return func(_ *nats.Conn, err error) {
+		if err != nil {
+			log.Error("nast disconnected", zap.Error(err))
+			return
+		}
+
+		log.Warn("nast disconnected")
+	}
+}
@@ -0,0 +1,79 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// checkConsumerDrift compares the existing durable consumer with the pipeline options. On mismatch, the consumer
+// is updated in place if consumer_update is enabled, otherwise an error naming the mismatched options is returned.
+func (c *Driver) checkConsumerDrift(stream, subject, durable string) error {
+	const op = errors.Op("nats_consumer_drift")
+
+	if durable == "" {
+		return nil
+	}
+
+	ci, err := c.js.ConsumerInfo(stream, durable)
+	if err != nil {
+		// will be created on subscribe
+		if stderr.Is(err, nats.ErrConsumerNotFound) {
+			return nil
+		}
+
+		return errors.E(op, err)
+	}
+
+	cfg := ci.Config
+
+	// the consumer mode and the deliver group can't be updated in place
+	err = c.checkConsumerMode(durable, &cfg)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	// the generated name is taken by the consumer of the other pipeline (e.g. created manually), don't touch it
+	if c.autoDurable && cfg.FilterSubject != subject {
+		return errors.E(op, errors.Errorf("generated durable name %s collides with the consumer of the subject %s (pipeline subject: %s), set the durable name explicitly", durable, cfg.FilterSubject, subject))
+	}
+
+	ro := c.opts.Load()
+	drift := make([]string, 0, 3)
+
+	if ro.ackWait > 0 && cfg.AckWait != ro.ackWait {
+		drift = append(drift, fmt.Sprintf("ack_wait (consumer: %s, pipeline: %s)", cfg.AckWait, ro.ackWait))
+		cfg.AckWait = ro.ackWait
+	}
+
+	if ro.maxDeliver != 0 && cfg.MaxDeliver != ro.maxDeliver {
+		drift = append(drift, fmt.Sprintf("max_deliver (consumer: %d, pipeline: %d)", cfg.MaxDeliver, ro.maxDeliver))
+		cfg.MaxDeliver = ro.maxDeliver
+	}
+
+	if cfg.FilterSubject != subject {
+		drift = append(drift, fmt.Sprintf("subject (consumer filter: %s, pipeline: %s)", cfg.FilterSubject, subject))
+		cfg.FilterSubject = subject
+	}
+
+	if len(drift) == 0 {
+		return nil
+	}
+
+	if !c.consumerUpdate {
+		return errors.E(op, errors.Errorf("durable consumer %s configuration differs from the pipeline: %s, enable consumer_update to update it in place", durable, strings.Join(drift, ", ")))
+	}
+
+	_, err = c.js.UpdateConsumer(stream, &cfg)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	c.log.Warn("durable consumer configuration updated", zap.String("consumer", durable), zap.Strings("drift", drift))
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package natsjobs
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	// consumer pause API subject (nats-server 2.11+)
+	apiConsumerPause string = "$JS.API.CONSUMER.PAUSE."
+	// the consumers are paused until resumed if the pause_deadline is not set
+	maxPauseDeadline time.Duration = time.Hour * 24 * 365
+)
+
+// consumerRef identifies the consumer of the pipeline
+type consumerRef struct {
+	stream string
+	name   string
+}
+
+type pauseRequest struct {
+	PauseUntil *time.Time `json:"pause_until,omitempty"`
+}
+
+type pauseResponse struct {
+	Paused bool           `json:"paused"`
+	Error  *nats.APIError `json:"error,omitempty"`
+}
+
+// consumerRefs returns the consumers of the active subscriptions
+func (c *Driver) consumerRefs() ([]consumerRef, error) {
+	subs := c.subscriptions()
+	refs := make([]consumerRef, 0, len(subs))
+	for i := 0; i < len(subs); i++ {
+		ci, err := subs[i].ConsumerInfo()
+		if err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, consumerRef{stream: ci.Stream, name: ci.Name})
+	}
+
+	return refs, nil
+}
+
+// pauseConsumers pauses the consumers server-side until the deadline, zero deadline resumes them.
+// The subscriptions and the delivery state (pending acks, redelivery timers) are kept.
+func (c *Driver) pauseConsumers(refs []consumerRef, until time.Time) error {
+	req := &pauseRequest{}
+	if !until.IsZero() {
+		req.PauseUntil = &until
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(refs); i++ {
+		msg, err := c.conn.Request(apiConsumerPause+refs[i].stream+"."+refs[i].name, data, time.Second*5)
+		if err != nil {
+			return err
+		}
+
+		var resp pauseResponse
+		err = json.Unmarshal(msg.Data, &resp)
+		if err != nil {
+			return err
+		}
+
+		if resp.Error != nil {
+			return resp.Error
+		}
+	}
+
+	return nil
+}
+
+// serverPause pauses the pipeline consumers server-side, returns false if the pipeline should be paused by the drain
+func (c *Driver) serverPause() bool {
+	if !c.consumerPause {
+		return false
+	}
+
+	refs, err := c.consumerRefs()
+	if err == nil {
+		deadline := c.pauseDeadline
+		if deadline == 0 {
+			deadline = maxPauseDeadline
+		}
+
+		err = c.pauseConsumers(refs, time.Now().Add(deadline))
+	}
+
+	if err != nil {
+		c.log.Warn("failed to pause the consumers server-side (nats-server 2.11+ is required), draining the subscriptions", zap.Error(err))
+		// don't leave the partially paused consumers
+		if refs != nil {
+			_ = c.pauseConsumers(refs, time.Time{})
+		}
+
+		return false
+	}
+
+	c.serverPaused.Store(true)
+	return true
+}
+
+// serverResume resumes the consumers paused server-side, returns false if the pipeline was paused by the drain
+func (c *Driver) serverResume() (bool, error) {
+	if !c.serverPaused.Load() {
+		return false, nil
+	}
+
+	refs, err := c.consumerRefs()
+	if err != nil {
+		return true, err
+	}
+
+	err = c.pauseConsumers(refs, time.Time{})
+	if err != nil {
+		return true, err
+	}
+
+	c.serverPaused.Store(false)
+	return true, nil
+}
@@ -0,0 +1,164 @@
+package natsjobs
+
+import (
+	"encoding/base64"
+	"mime"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/sdk/v4/utils"
+)
+
+// decoders selected by the message Content-Type header
+const (
+	decoderRRJSON      string = "rr-json"
+	decoderProto       string = "proto"
+	decoderRaw         string = "raw"
+	decoderCloudEvents string = "cloudevents"
+
+	contentTypeRRJSON      string = "application/x-rr-job+json"
+	contentTypeCloudEvents string = "application/cloudevents+json"
+	contentTypeOctetStream string = "application/octet-stream"
+
+	// binary mode CloudEvents (NATS protocol binding) attributes are passed in the headers
+	headerCESpecVersion string = "ce-specversion"
+	headerCEType        string = "ce-type"
+	headerCEID          string = "ce-id"
+	headerCESource      string = "ce-source"
+
+	// CloudEventSourceHeader contains the source attribute of the CloudEvent
+	CloudEventSourceHeader string = "rr_ce_source"
+)
+
+// cloudEvent is the structured mode CloudEvent
+type cloudEvent struct {
+	SpecVersion string          `json:"specversion"`
+	Type        string          `json:"type"`
+	ID          string          `json:"id"`
+	Source      string          `json:"source"`
+	Data        json.RawMessage `json:"data"`
+	DataBase64  string          `json:"data_base64"`
+}
+
+// newContentTypes returns the Content-Type -> decoder map, the configured types extend (or override) the defaults
+func newContentTypes(types map[string]string) (map[string]string, error) {
+	ct := map[string]string{
+		contentTypeRRJSON:      decoderRRJSON,
+		contentTypeProto:       decoderProto,
+		contentTypeCloudEvents: decoderCloudEvents,
+		contentTypeOctetStream: decoderRaw,
+	}
+
+	for t, d := range types {
+		switch d {
+		case decoderRRJSON, decoderProto, decoderRaw, decoderCloudEvents:
+		default:
+			return nil, errors.Errorf("unknown decoder %s for the content type %s, available: rr-json, proto, raw, cloudevents", d, t)
+		}
+
+		mt, _, err := mime.ParseMediaType(t)
+		if err != nil {
+			return nil, errors.Errorf("invalid content type %s: %v", t, err)
+		}
+
+		ct[mt] = d
+	}
+
+	return ct, nil
+}
+
+// decoder returns the decoder for the message, empty if the message doesn't have the known Content-Type
+func (c *Driver) decoder(hdr nats.Header) string {
+	if hdr == nil {
+		return ""
+	}
+
+	if hdr.Get(headerCESpecVersion) != "" {
+		return decoderCloudEvents
+	}
+
+	v := hdr.Get(headerContentType)
+	if v == "" {
+		return ""
+	}
+
+	mt, _, err := mime.ParseMediaType(v)
+	if err != nil {
+		return ""
+	}
+
+	return c.contentTypes[mt]
+}
+
+// decode picks the decoder by the message Content-Type, so the producers of the different formats might share the
+// subject. Messages without the Content-Type are decoded according to the compat and consume_all options.
+func (c *Driver) decode(m *nats.Msg, item *Item) error {
+	switch c.decoder(m.Header) {
+	case decoderProto:
+		return decodeEnvelope(m.Data, item)
+	case decoderRRJSON:
+		return json.Unmarshal(m.Data, item)
+	case decoderRaw:
+		return c.rawItem(m.Data, m.Subject, item)
+	case decoderCloudEvents:
+		return c.cloudEventItem(m, item)
+	}
+
+	if c.compat != "" && c.compatItem(m.Data, item) {
+		return nil
+	}
+
+	return c.unmarshal(m.Data, m.Subject, item)
+}
+
+// cloudEventItem maps the CloudEvent type to the job name and passes the event data as the payload
+func (c *Driver) cloudEventItem(m *nats.Msg, item *Item) error {
+	ce := &cloudEvent{}
+
+	// binary mode, the data is the message body
+	if m.Header.Get(headerCESpecVersion) != "" {
+		ce.Type = m.Header.Get(headerCEType)
+		ce.ID = m.Header.Get(headerCEID)
+		ce.Source = m.Header.Get(headerCESource)
+		ce.Data = m.Data
+	} else {
+		err := json.Unmarshal(m.Data, ce)
+		if err != nil {
+			return err
+		}
+
+		if ce.DataBase64 != "" {
+			data, err := base64.StdEncoding.DecodeString(ce.DataBase64)
+			if err != nil {
+				return err
+			}
+
+			ce.Data = data
+		}
+	}
+
+	if ce.Type == "" {
+		return errors.Str("cloudevent type is not set")
+	}
+
+	id := ce.ID
+	if id == "" {
+		id = c.genID()
+	}
+
+	*item = Item{
+		Job:     ce.Type,
+		Ident:   id,
+		Payload: utils.AsString(ce.Data),
+		Headers: map[string][]string{
+			CloudEventSourceHeader: {ce.Source},
+		},
+		Options: &Options{
+			Priority: c.defaultPriority,
+			Pipeline: auto,
+		},
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package natsjobs
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// rotation watches the credentials and certificate files and drops the connection when they change. The client
+// reconnects and re-reads the files on every connect, so the short-lived credentials are picked up without a restart.
+type rotation struct {
+	files    []string
+	interval time.Duration
+	dialer   *rotatingDialer
+}
+
+// rotatingDialer keeps the current connection to be able to close it on the credentials change
+type rotatingDialer struct {
+	mu     sync.Mutex
+	dialer net.Dialer
+	conn   net.Conn
+}
+
+func (d *rotatingDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := d.dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.conn = conn
+	d.mu.Unlock()
+
+	return conn, nil
+}
+
+// drop closes the current connection, the client treats it as a network error and reconnects
+func (d *rotatingDialer) drop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn != nil {
+		_ = d.conn.Close()
+	}
+}
+
+// newRotation returns the credentials rotation, nil if there are no files to watch or the watch is disabled
+func newRotation(conf *config) *rotation {
+	var files []string
+	if conf.Creds != "" {
+		files = append(files, conf.Creds)
+	}
+
+	if conf.TLS != nil && conf.TLS.Cert != "" {
+		files = append(files, conf.TLS.Cert, conf.TLS.Key)
+	}
+
+	if len(files) == 0 || conf.CredsWatchInterval <= 0 {
+		return nil
+	}
+
+	return &rotation{
+		files:    files,
+		interval: conf.CredsWatchInterval,
+		dialer:   &rotatingDialer{dialer: net.Dialer{Timeout: conf.ConnectTimeout}},
+	}
+}
+
+func (r *rotation) options() []nats.Option {
+	if r == nil {
+		return nil
+	}
+
+	return []nats.Option{nats.SetCustomDialer(r.dialer)}
+}
+
+// modTimes returns the modification times of the watched files, the missing files (e.g. being replaced) are skipped
+func (r *rotation) modTimes() map[string]time.Time {
+	mt := make(map[string]time.Time, len(r.files))
+	for _, f := range r.files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+
+		mt[f] = fi.ModTime()
+	}
+
+	return mt
+}
+
+// watchCredentials reconnects with the fresh credentials when the watched files are changed
+func (c *Driver) watchCredentials() {
+	r := c.rotation
+	if r == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		last := r.modTimes()
+		for {
+			select {
+			case <-ticker.C:
+				mt := r.modTimes()
+				changed := ""
+				for f, t := range mt {
+					if prev, ok := last[f]; ok && !t.Equal(prev) {
+						changed = f
+						break
+					}
+				}
+
+				last = mt
+				if changed == "" {
+					continue
+				}
+
+				c.log.Info("credentials were changed, reconnecting", zap.String("pipeline", (*c.pipeline.Load()).Name()), zap.String("file", changed))
+				r.dialer.drop()
+			case <-c.closeCh:
+				return
+			}
+		}
+	}()
+}
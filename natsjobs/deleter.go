@@ -0,0 +1,164 @@
+package natsjobs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// msgRef is the stream message to delete
+type msgRef struct {
+	stream string
+	seq    uint64
+}
+
+// deleter deletes the acknowledged messages (delete_after_ack) in batches, asynchronously to the acks.
+// The deletes of a batch are sent concurrently. nil deleter - the messages are deleted on ack.
+type deleter struct {
+	batch    int
+	interval time.Duration
+	refs     chan msgRef
+	done     chan struct{}
+	// the deleter exited, the messages are deleted on ack
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newDeleter(deleteAfterAck, erase bool, batch int, interval time.Duration) (*deleter, error) {
+	if erase && !deleteAfterAck {
+		return nil, errors.Str("erase_after_ack requires the delete_after_ack")
+	}
+
+	if batch == 0 {
+		return nil, nil
+	}
+
+	if batch < 0 || interval <= 0 {
+		return nil, errors.Errorf("delete_batch (%d) and delete_interval (%s) should be positive", batch, interval)
+	}
+
+	if !deleteAfterAck {
+		return nil, nil
+	}
+
+	return &deleter{
+		batch:    batch,
+		interval: interval,
+		refs:     make(chan msgRef, batch*4),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// deleteMsg deletes the acknowledged message, the message is queued for the batch delete if the delete_batch is set
+func (c *Driver) deleteMsg(stream string, seq uint64) error {
+	if c.deleter != nil && c.deleter.enqueue(msgRef{stream: stream, seq: seq}) {
+		return nil
+	}
+
+	return c.deleteNow(stream, seq)
+}
+
+// enqueue queues the delete, false if the deleter exited or can't keep up with the acks (the delete is not lost)
+func (d *deleter) enqueue(ref msgRef) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return false
+	}
+
+	select {
+	case d.refs <- ref:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the queueing, the jobs acked after the stop are deleted on ack
+func (d *deleter) close() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+}
+
+// deleteNow deletes the message, the payload is overwritten with the erase_after_ack
+func (c *Driver) deleteNow(stream string, seq uint64) error {
+	if c.eraseAfterAck {
+		return c.js.SecureDeleteMsg(stream, seq)
+	}
+
+	return c.js.DeleteMsg(stream, seq)
+}
+
+// startDeleter deletes the queued messages when the batch is full or the interval elapsed, the rest is deleted on stop
+func (c *Driver) startDeleter() {
+	d := c.deleter
+	if d == nil {
+		return
+	}
+
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		batch := make([]msgRef, 0, d.batch)
+		for {
+			select {
+			case ref := <-d.refs:
+				batch = append(batch, ref)
+				if len(batch) >= d.batch {
+					batch = c.flushDeletes(batch)
+				}
+			case <-ticker.C:
+				batch = c.flushDeletes(batch)
+			case <-c.closeCh:
+				d.close()
+				for {
+					select {
+					case ref := <-d.refs:
+						batch = append(batch, ref)
+					default:
+						c.flushDeletes(batch)
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// flushDeletes deletes the batch concurrently and returns the emptied batch
+func (c *Driver) flushDeletes(batch []msgRef) []msgRef {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(batch))
+	for i := 0; i < len(batch); i++ {
+		go func(ref msgRef) {
+			defer wg.Done()
+
+			err := c.deleteNow(ref.stream, ref.seq)
+			if err != nil {
+				c.log.Warn("failed to delete the acknowledged message", zap.String("stream", ref.stream), zap.Uint64("sequence", ref.seq), zap.Error(err))
+			}
+		}(batch[i])
+	}
+
+	wg.Wait()
+	return batch[:0]
+}
+
+func (d *deleter) wait() {
+	if d == nil {
+		return
+	}
+
+	<-d.done
+}
@@ -0,0 +1,40 @@
+package natsjobs
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+)
+
+// validateDeliverGroup checks the options conflicting with the push consumer deliver group
+func validateDeliverGroup(group string, ephemeral bool, idleHeartbeat time.Duration, flowControl bool) error {
+	if group == "" {
+		return nil
+	}
+
+	if ephemeral {
+		return errors.Errorf("deliver_group (%s) and ephemeral are mutually exclusive, the group members share the durable consumer", group)
+	}
+
+	if idleHeartbeat > 0 || flowControl {
+		return errors.Errorf("deliver_group (%s) doesn't support idle_heartbeat and flow_control", group)
+	}
+
+	return nil
+}
+
+// checkConsumerMode checks that the existing durable consumer matches the pipeline pull and deliver_group options
+func (c *Driver) checkConsumerMode(durable string, cfg *nats.ConsumerConfig) error {
+	pull := cfg.DeliverSubject == ""
+	switch {
+	case pull && !c.pull:
+		return errors.Errorf("durable consumer %s is a pull consumer, set pull: true or use another durable name", durable)
+	case !pull && c.pull:
+		return errors.Errorf("durable consumer %s is a push consumer, set pull: false or use another durable name", durable)
+	case !pull && cfg.DeliverGroup != c.deliverGroup:
+		return errors.Errorf("durable consumer %s deliver group (%q) differs from the pipeline deliver_group (%q), use another durable name", durable, cfg.DeliverGroup, c.deliverGroup)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package natsjobs
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const drainPoll = time.Millisecond * 50
+
+// closeConn drains the connection and waits for it to be closed. The connection is force-closed if the drain
+// doesn't finish in the drain_timeout, the messages left in the buffer are abandoned and redelivered by the server.
+func (c *Driver) closeConn() error {
+	err := c.conn.Drain()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(drainPoll)
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(c.drainTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if c.conn.IsClosed() {
+				return nil
+			}
+		case <-timeout.C:
+			abandoned := len(c.msgCh) + c.pendingMsgs()
+			c.conn.Close()
+			c.log.Warn("connection drain timed out, connection was force-closed",
+				zap.String("pipeline", (*c.pipeline.Load()).Name()),
+				zap.Duration("drain_timeout", c.drainTimeout),
+				zap.Int("abandoned", abandoned),
+			)
+			return nil
+		}
+	}
+}
+
+// pendingMsgs returns the number of the messages delivered to the subscriptions but not yet dispatched to the listener
+func (c *Driver) pendingMsgs() int {
+	n := 0
+	subs := c.subscriptions()
+	for i := 0; i < len(subs); i++ {
+		msgs, _, err := subs[i].Pending()
+		if err != nil {
+			continue
+		}
+
+		n += msgs
+	}
+
+	return n
+}
@@ -2,22 +2,22 @@ package natsjobs
 
 import (
 	"context"
-	stderr "errors"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/goccy/go-json"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
 	pq "github.com/roadrunner-server/api/v4/plugins/v1/priority_queue"
 	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/sdk/v4/events"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"go.uber.org/zap"
 )
 
 const (
-	pluginName      string = "nats"
-	reconnectBuffer int    = 20 * 1024 * 1024
+	pluginName string = "nats"
 )
 
 var _ jobs.Driver = (*Driver)(nil)
@@ -31,32 +31,181 @@ type Configurer interface {
 
 type Driver struct {
 	// system
-	sync.RWMutex
 	log        *zap.Logger
 	queue      pq.Queue
-	listeners  uint32
+	state      atomic.Uint32
+	stateMu    sync.Mutex
 	pipeline   atomic.Pointer[jobs.Pipeline]
 	consumeAll bool
+	// closed to stop the listener, re-created on every start
 	stopCh     chan struct{}
+	limiter    *Limiter
+	stopOrder  *StopOrder
+	accounts   *Accounts
+	stopMember *stopMember
+	inflight   atomic.Int64
+	metrics    *Metrics
+	events     *events.Bus
+	// closed on Stop to stop the background goroutines
+	closeCh  chan struct{}
+	stopping atomic.Bool
+	closed   atomic.Bool
+	// last automatic prefetch reduction, unix nano
+	lastReduce atomic.Int64
+	migration  migration
+	// current consumer names
+	consumerNames atomic.Value
+	// map[string]uint64, the start sequences of the ephemeral consumers re-created on resume
+	resumePoints atomic.Value
+	pools        pools
+	// consumption is paused by the backpressure
+	paused atomic.Bool
+	// configuration key and configurer to reload the pipeline, empty for the pipelines declared at runtime
+	configKey string
+	cfg       Configurer
+	// deliver_all replay progress
+	replaySeq     atomic.Uint64
+	replayPending atomic.Uint64
+	replayDone    atomic.Bool
+	// closed to stop the pull consumers fetch loops
+	fetchStop chan struct{}
+	// consumers counters reported by State()
+	stateCache stateCache
+	// last successful publish and consume, unix nano
+	lastPublish atomic.Int64
+	lastConsume atomic.Int64
 
 	// nats
-	conn  *nats.Conn
-	sub   *nats.Subscription
+	conn natsConn
+	// a subscription per consumer, more than one with the priority lanes.
+	// Replaced only under the stateMu, read without the lock via subscriptions.
+	subs  atomic.Pointer[[]subscription]
 	msgCh chan *nats.Msg
-	js    nats.JetStreamContext
+	js    jetStream
 
 	// config
-	priority           int64
-	subject            string
-	stream             string
-	prefetch           int
-	rateLimit          uint64
-	deleteAfterAck     bool
-	deliverNew         bool
-	deleteStreamOnStop bool
+	priority int64
+	// the options changed by the Reload
+	opts                  atomic.Pointer[reloadOpts]
+	stream                string
+	rateLimit             uint64
+	deleteAfterAck        bool
+	deliverNew            bool
+	deliverLastPerSubject bool
+	deliverAll            bool
+	ackPolicy             string
+	pull                  bool
+	maxWaiting            int
+	fetchBatch            int
+	fetchTimeout          time.Duration
+	jobsLimiter           *tokenBucket
+	archiver              *archiver
+	redeliveryThreshold   uint64
+	extraStreams          []lane
+	broadcastSubjects     []string
+	routes                []route
+	deleteStreamOnStop    bool
+	termOnNack            bool
+	dlqSubject            string
+	progressInterval      time.Duration
+	canarySubject         string
+	canaryWeight          uint32
+	requeueRepublish      bool
+	genID                 func() string
+	jobName               string
+	defaultPriority       int64
+	priorityHeader        string
+	jobRules              []jobRule
+	rawPayload            string
+	ttl                   time.Duration
+	serverTTL             bool
+	idleHeartbeat         time.Duration
+	flowControl           bool
+	slowConsumerReduce    bool
+	consumerReplicas      int
+	inactiveThreshold     time.Duration
+	description           string
+	durable               string
+	ephemeral             bool
+	autoDurable           bool
+	headersOnly           bool
+	sampleFreq            string
+	service               micro.Service
+	resultSubject         string
+	consumerPause         bool
+	pauseDeadline         time.Duration
+	drainTimeout          time.Duration
+	rotation              *rotation
+	format                string
+	compat                string
+	contentTypes          map[string]string
+	headerFilter          *headerFilter
+	realtimeSubjects      [][]string
+	insertTimeout         time.Duration
+	middlewares           []Middleware
+	ackStrategy           AckStrategy
+	adaptive              *adaptivePrefetch
+	group                 *priorityGroup
+	deliverGroup          string
+	onDecodeError         string
+	deleter               *deleter
+	eraseAfterAck         bool
+	queueCapacity         uint64
+	// the consumers are paused server-side, the listener is running
+	serverPaused atomic.Bool
+	statusKV     nats.KeyValue
+	// micro service stats
+	processed           atomic.Uint64
+	failed              atomic.Uint64
+	consumerUpdate      bool
+	lanes               []lane
+	workers             int
+	highWatermark       uint64
+	outbox              *outbox
+	publishRetries      int
+	publishRetryBackoff time.Duration
+	breaker             *breaker
+	kv                  nats.KeyValue
+	obs                 nats.ObjectStore
+	schema              *jsonschema.Schema
+	stateCacheTTL       time.Duration
+	headers             nats.Header
+	maxInlinePayload    int
+	lowWatermark        uint64
+	recreateStream      bool
+	streamOpts          *streamOptions
 }
 
-func FromConfig(configKey string, log *zap.Logger, cfg Configurer, pipe jobs.Pipeline, pq pq.Queue, _ chan<- jobs.Commander) (*Driver, error) {
+// readConfig reads the pipeline and the global sections, the nats context and the profile are applied
+// before the defaults, so the started and the reloaded pipelines see the same options
+func readConfig(cfg Configurer, configKey string) (*config, error) {
+	var conf *config
+	err := cfg.UnmarshalKey(configKey, &conf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.UnmarshalKey(pluginName, &conf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyContext(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conf.applyProfile(func(key string) bool { return cfg.Has(configKey + "." + key) })
+	if err != nil {
+		return nil, err
+	}
+
+	conf.InitDefaults()
+
+	return conf, nil
+}
+
+func FromConfig(configKey string, log *zap.Logger, cfg Configurer, pipe jobs.Pipeline, pq pq.Queue, shared *Shared, _ chan<- jobs.Commander) (*Driver, error) {
 	const op = errors.Op("new_nats_consumer")
 
 	if !cfg.Has(configKey) {
@@ -68,83 +217,433 @@ func FromConfig(configKey string, log *zap.Logger, cfg Configurer, pipe jobs.Pip
 		return nil, errors.E(op, errors.Str("no global nats configuration, global configuration should contain NATS URL"))
 	}
 
-	var conf *config
-	err := cfg.UnmarshalKey(configKey, &conf)
+	conf, err := readConfig(cfg, configKey)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
 
-	err = cfg.UnmarshalKey(pluginName, &conf)
+	if shared.EmbeddedURL != "" {
+		log.Info("pipeline connects to the embedded NATS server", zap.String("pipeline", pipe.Name()), zap.String("addr", conf.Addr), zap.String("embedded", shared.EmbeddedURL))
+		conf.Addr = shared.EmbeddedURL
+	}
+
+	// every pipeline has its own connection, it might belong to a different account
+	if conf.AccountCreds != "" {
+		conf.Creds = conf.AccountCreds
+	}
+
+	// typed errors, not wrapped
+	err = conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	conf.QueueHighWatermark, conf.QueueLowWatermark = watermarks(conf.QueueHighWatermark, conf.QueueLowWatermark)
+	if conf.QueueHighWatermark > 0 && conf.QueueLowWatermark >= conf.QueueHighWatermark {
+		return nil, errors.E(op, errors.Errorf("queue_low_watermark (%d) should be less than queue_high_watermark (%d)", conf.QueueLowWatermark, conf.QueueHighWatermark))
+	}
+
+	ob, err := newOutbox(conf.OutboxSize, conf.OutboxOverflow)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
 
-	conf.InitDefaults()
+	err = validateBroadcast(conf.BroadcastSubjects, ob)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
 
-	conn, err := nats.Connect(conf.Addr,
-		nats.NoEcho(),
-		nats.Timeout(time.Minute),
-		nats.MaxReconnects(-1),
-		nats.PingInterval(time.Second*10),
-		nats.ReconnectWait(time.Second),
-		nats.ReconnectBufSize(reconnectBuffer),
-		nats.ReconnectHandler(reconnectHandler(log)),
-		nats.DisconnectErrHandler(disconnectHandler(log)),
-	)
+	routes, err := newRoutes(conf.Routing)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	schema, err := loadSchema(conf.SchemaFile)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
 
+	err = validateConsumerMode(conf.Durable, conf.Ephemeral, log)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	durable, generated := autoDurable(conf.Durable, conf.Stream, pipe.Name(), conf.Subject)
+
+	group, err := newPriorityGroup(conf.PriorityGroup, conf.Pull, durable, conf.PriorityMinPending, conf.PriorityMinAckPending)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateDeliverPolicy(conf.DeliverAll, conf.DeliverNew, conf.DeliverLastPerSubject)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateAckPolicy(conf.AckPolicy)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validatePull(conf.Pull, func(key string) bool { return cfg.Has(configKey + "." + key) })
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateDeliverGroup(conf.DeliverGroup, conf.Ephemeral, conf.IdleHeartbeat, conf.FlowControl)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateHeadersOnly(conf.HeadersOnly, conf.RequeueRepublish, conf.DLQSubject)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateOnDecodeError(conf.OnDecodeError, conf.DLQSubject)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateSampleFreq(conf.SampleFreq)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	jobsLimiter, err := newTokenBucket(conf.JobsPerSecond, conf.JobsBurst)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = parseSchedules(conf.Schedules, conf.ScheduleBucket)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	arch, err := newArchiver(conf.ArchiveSubject, conf.ArchiveBatch, conf.ArchiveInterval)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	lanes, err := newLanes(conf.Subject, conf.PriorityMap)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if conf.CanaryWeight < 0 || conf.CanaryWeight > 100 {
+		return nil, errors.E(op, errors.Errorf("canary_weight should be in the [0..100] range, got: %d", conf.CanaryWeight))
+	}
+
+	genID, err := idGenerator(conf.IDGenerator, shared.IDGenerators)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	mws, err := middlewares(conf.Middleware, shared.Middlewares)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	strategy, err := ackStrategy(conf.AckStrategy, conf.AckMaxRetries, conf.AckBackoff, conf.AckMaxBackoff, shared.AckStrategies)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	adaptive, err := newAdaptivePrefetch(conf.AdaptivePrefetch, conf.PrefetchMin, conf.PrefetchMax, conf.Prefetch, conf.AdaptiveInterval)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateRawPayload(conf.RawPayload)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateFormat(conf.Format)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateCompat(conf.Compat)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	contentTypes, err := newContentTypes(conf.ContentTypes)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if conf.FlowControl && conf.IdleHeartbeat == 0 {
+		return nil, errors.E(op, errors.Str("flow_control requires idle_heartbeat to be set"))
+	}
+
+	// the driver is created after the connection, handlers get it via the holder
+	drv := &atomic.Pointer[Driver]{}
+	connOpts, err := connOptions(conf, pipe.Name(), log)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	rot := newRotation(conf)
+	connOpts = append(connOpts, rot.options()...)
+
+	var conn *nats.Conn
+	err = startupRetry(conf, log, "connect", func() error {
+		var errC error
+		conn, errC = nats.Connect(conf.Addr, append(connOpts, driverHandlers(drv, log)...)...)
+		return errC
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	// the connection is closed on any of the following errors, the driver isn't returned to the jobs plugin
+	started := false
+	defer func() {
+		if !started {
+			conn.Close()
+		}
+	}()
+
 	js, err := conn.JetStream()
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
 
+	so := &streamOptions{
+		name:           conf.Stream,
+		subject:        conf.Subject,
+		update:         conf.UpdateStream,
+		mirror:         conf.Mirror,
+		sources:        conf.Sources,
+		external:       newExternal(conf.SourceDomain, conf.SourceAPIPrefix, conf.SourceDeliverPrefix),
+		republish:      newRePublish(conf.RePublishSource, conf.RePublishDestination, conf.RePublishHeadersOnly),
+		compression:    conf.Compression,
+		placement:      newPlacement(conf.Placement.Cluster, conf.Placement.Tags),
+		transform:      newSubjectTransform(conf.SubjectTransform.Source, conf.SubjectTransform.Destination),
+		allowRollup:    conf.AllowRollupHdrs,
+		denyDelete:     conf.DenyDelete,
+		denyPurge:      conf.DenyPurge,
+		deleteAfterAck: conf.DeleteAfterAck,
+	}
+
+	err = so.validate()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
 	var si *nats.StreamInfo
-	si, err = js.StreamInfo(conf.Stream)
-	if err != nil {
-		if stderr.Is(err, nats.ErrStreamNotFound) {
-			si, err = js.AddStream(&nats.StreamConfig{
-				Name:     conf.Stream,
-				Subjects: []string{conf.Subject},
-			})
-			if err != nil {
-				return nil, errors.E(op, err)
-			}
-		} else {
+	err = startupRetry(conf, log, "stream", func() error {
+		var errS error
+		si, errS = ensureStream(js, conn, log, so)
+		return errS
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = checkDLQ(js, conf.DLQSubject)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	deleteAfterAck, err := retentionDeleteAfterAck(si, so.deleteAfterAck, conf.EraseAfterAck, log)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	del, err := newDeleter(deleteAfterAck, conf.EraseAfterAck, conf.DeleteBatch, conf.DeleteInterval)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	var obs nats.ObjectStore
+	err = validateObjectBucket(conf.MaxInlinePayload, conf.ObjectBucket)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	// consumers resolve the references even if the pipeline doesn't store the payloads itself
+	if conf.ObjectBucket != "" {
+		obs, err = initObjectStore(js, conf.ObjectBucket)
+		if err != nil {
 			return nil, errors.E(op, err)
 		}
 	}
 
-	if si == nil {
-		return nil, errors.E(op, errors.Str("failed to create a stream"))
+	var kv nats.KeyValue
+	if conf.UniqueJobs {
+		err = validateUniqueBucket(conf.KVBucket)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		kv, err = initUniqueJobs(js, conf.KVBucket, conf.UniqueTTL)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	extraStreams, err := newExtraStreams(js, conf.Stream, conf.ExtraStreams)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	var statusKV nats.KeyValue
+	if conf.StatusBucket != "" {
+		statusKV, err = initStatusBucket(js, conf.StatusBucket, conf.StatusTTL)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	var locks nats.KeyValue
+	if len(conf.Schedules) > 0 {
+		locks, err = initScheduleLocks(js, conf.ScheduleBucket)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
 	}
 
 	cs := &Driver{
-		log:    log,
-		stopCh: make(chan struct{}),
-		queue:  pq,
-
-		conn:               conn,
-		js:                 js,
-		priority:           conf.Priority,
-		subject:            conf.Subject,
-		stream:             conf.Stream,
-		consumeAll:         conf.ConsumeAll,
-		deleteAfterAck:     conf.DeleteAfterAck,
-		deleteStreamOnStop: conf.DeleteStreamOnStop,
-		prefetch:           conf.Prefetch,
-		deliverNew:         conf.DeliverNew,
-		rateLimit:          conf.RateLimit,
-		msgCh:              make(chan *nats.Msg, conf.Prefetch),
+		log:       log,
+		queue:     pq,
+		limiter:   shared.Limiter,
+		stopOrder: shared.StopOrder,
+		accounts:  shared.Accounts,
+		metrics:   shared.Metrics,
+		events:    shared.Events,
+		closeCh:   make(chan struct{}),
+
+		conn:                  conn,
+		js:                    js,
+		priority:              conf.Priority,
+		stream:                conf.Stream,
+		consumeAll:            conf.ConsumeAll,
+		deleteAfterAck:        deleteAfterAck,
+		deleteStreamOnStop:    conf.DeleteStreamOnStop,
+		deliverNew:            conf.DeliverNew,
+		deliverLastPerSubject: conf.DeliverLastPerSubject,
+		deliverAll:            conf.DeliverAll,
+		ackPolicy:             conf.AckPolicy,
+		pull:                  conf.Pull,
+		maxWaiting:            conf.MaxWaiting,
+		fetchBatch:            conf.FetchBatch,
+		fetchTimeout:          conf.FetchTimeout,
+		jobsLimiter:           jobsLimiter,
+		archiver:              arch,
+		redeliveryThreshold:   conf.RedeliveryThreshold,
+		extraStreams:          extraStreams,
+		broadcastSubjects:     conf.BroadcastSubjects,
+		routes:                routes,
+		rateLimit:             conf.RateLimit,
+		termOnNack:            conf.TermOnNack,
+		dlqSubject:            conf.DLQSubject,
+		progressInterval:      conf.ProgressInterval,
+		canarySubject:         conf.CanarySubject,
+		canaryWeight:          uint32(conf.CanaryWeight),
+		requeueRepublish:      conf.RequeueRepublish,
+		headersOnly:           conf.HeadersOnly,
+		sampleFreq:            conf.SampleFreq,
+		resultSubject:         conf.ResultSubject,
+		consumerPause:         conf.ConsumerPause,
+		pauseDeadline:         conf.PauseDeadline,
+		drainTimeout:          conf.DrainTimeout,
+		statusKV:              statusKV,
+		genID:                 genID,
+		jobName:               conf.JobName,
+		defaultPriority:       conf.DefaultPriority,
+		priorityHeader:        conf.PriorityHeader,
+		jobRules:              newJobRules(conf.JobSubjects),
+		rawPayload:            conf.RawPayload,
+		ttl:                   conf.TTL,
+		serverTTL:             conf.ServerTTL && serverMinVersion(conn, 2, 11, 0),
+		idleHeartbeat:         conf.IdleHeartbeat,
+		flowControl:           conf.FlowControl,
+		slowConsumerReduce:    conf.SlowConsumerReduce,
+		consumerReplicas:      conf.ConsumerReplicas,
+		inactiveThreshold:     conf.InactiveThreshold,
+		description:           conf.Description,
+		durable:               durable,
+		autoDurable:           generated,
+		ephemeral:             conf.Ephemeral || durable == "",
+		consumerUpdate:        conf.ConsumerUpdate,
+		lanes:                 lanes,
+		workers:               conf.Workers,
+		highWatermark:         conf.QueueHighWatermark,
+		outbox:                ob,
+		publishRetries:        conf.PublishRetries,
+		publishRetryBackoff:   conf.PublishRetryBackoff,
+		breaker:               newBreaker(conf.BreakerThreshold, conf.BreakerProbeInterval),
+		kv:                    kv,
+		obs:                   obs,
+		maxInlinePayload:      conf.MaxInlinePayload,
+		schema:                schema,
+		stateCacheTTL:         conf.StateCacheTTL,
+		headers:               staticHeaders(conf.Headers, pipe.Name()),
+		lowWatermark:          conf.QueueLowWatermark,
+		recreateStream:        conf.RecreateStream,
+		streamOpts:            so,
+		msgCh:                 make(chan *nats.Msg, conf.Prefetch),
+		rotation:              rot,
+		format:                conf.Format,
+		compat:                conf.Compat,
+		contentTypes:          contentTypes,
+		headerFilter:          newHeaderFilter(conf.ForwardHeaders, conf.DropHeaders),
+		realtimeSubjects:      newRealtime(conf.RealtimeSubjects),
+		insertTimeout:         conf.InsertTimeout,
+		middlewares:           mws,
+		ackStrategy:           strategy,
+		adaptive:              adaptive,
+		group:                 group,
+		deliverGroup:          conf.DeliverGroup,
+		onDecodeError:         conf.OnDecodeError,
+		deleter:               del,
+		eraseAfterAck:         conf.EraseAfterAck,
+		queueCapacity:         conf.QueueCapacity,
 	}
 
 	cs.pipeline.Store(&pipe)
+	cs.migration.dial = newStanDialer(conn)
+	cs.opts.Store(&reloadOpts{
+		subject:    conf.Subject,
+		prefetch:   conf.Prefetch,
+		ackWait:    conf.AckWait,
+		maxDeliver: conf.MaxDeliver,
+	})
+	cs.configKey = configKey
+	cs.cfg = cfg
+	drv.Store(cs)
+	if conn.IsConnected() {
+		cs.lifecycle(EventConnected, "connection established")
+	}
+	// the service is the last thing which might fail, nothing is registered or started before it
+	err = cs.addService(conn, conf.MicroService, conf.MicroVersion)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	started = true
+	cs.stopMember = cs.stopOrder.register(cs.priority)
+	cs.watchDeletion()
+	cs.startArchive()
+	cs.startDeleter()
+	cs.watchAckSamples()
+	cs.watchCredentials()
+	cs.startSchedules(conf.Schedules, locks, conf.Priority)
+
+	if conf.AccountInfoInterval > 0 {
+		cs.accountWatcher(conf.Addr, conf.Creds, conf.AccountInfoInterval, conf.AccountUsageThreshold)
+	}
+
+	cs.tunePrefetch()
+	cs.lagWatcher(conf.MaxLag, conf.LagCheckInterval)
 
 	return cs, nil
 }
 
-func FromPipeline(pipe jobs.Pipeline, log *zap.Logger, cfg Configurer, pq pq.Queue, _ chan<- jobs.Commander) (*Driver, error) {
+func FromPipeline(pipe jobs.Pipeline, log *zap.Logger, cfg Configurer, pq pq.Queue, shared *Shared, _ chan<- jobs.Commander) (*Driver, error) {
 	const op = errors.Op("new_nats_pipeline_consumer")
 
 	// if no global section -- error
@@ -158,87 +657,591 @@ func FromPipeline(pipe jobs.Pipeline, log *zap.Logger, cfg Configurer, pq pq.Que
 		return nil, errors.E(op, err)
 	}
 
+	err = applyContext(conf)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = applyPipelineProfile(pipe)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
 	conf.InitDefaults()
 
-	conn, err := nats.Connect(conf.Addr,
-		nats.NoEcho(),
-		nats.Timeout(time.Minute),
-		nats.MaxReconnects(-1),
-		nats.PingInterval(time.Second*10),
-		nats.ReconnectWait(time.Second),
-		nats.ReconnectBufSize(reconnectBuffer),
-		nats.ReconnectHandler(reconnectHandler(log)),
-		nats.DisconnectErrHandler(disconnectHandler(log)),
+	if shared.EmbeddedURL != "" {
+		log.Info("pipeline connects to the embedded NATS server", zap.String("pipeline", pipe.Name()), zap.String("addr", conf.Addr), zap.String("embedded", shared.EmbeddedURL))
+		conf.Addr = shared.EmbeddedURL
+	}
+
+	// every pipeline has its own connection, it might belong to a different account
+	if creds := pipe.String(pipeAccountCreds, ""); creds != "" {
+		conf.Creds = creds
+	}
+
+	// typed errors, not wrapped
+	conf.withPipeline(pipe)
+	err = conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	canaryWeight := pipe.Int(pipeCanaryWeight, 0)
+	if canaryWeight < 0 || canaryWeight > 100 {
+		return nil, errors.E(op, errors.Errorf("canary_weight should be in the [0..100] range, got: %d", canaryWeight))
+	}
+
+	genID, err := idGenerator(pipe.String(pipeIDGenerator, conf.IDGenerator), shared.IDGenerators)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	mws, err := middlewares(pipeList(pipe, pipeMiddleware), shared.Middlewares)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	strategy, err := ackStrategy(
+		pipe.String(pipeAckStrategy, ""),
+		uint64(pipe.Int(pipeAckMaxRetries, 0)),
+		pipeDuration(pipe, pipeAckBackoff, time.Second),
+		pipeDuration(pipe, pipeAckMaxBackoff, time.Minute),
+		shared.AckStrategies,
+	)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	prefetch := pipe.Int(pipePrefetch, 100)
+	adaptive, err := newAdaptivePrefetch(
+		pipe.Bool(pipeAdaptivePrefetch, false),
+		pipe.Int(pipePrefetchMin, 1),
+		pipe.Int(pipePrefetchMax, prefetch),
+		prefetch,
+		pipeDuration(pipe, pipeAdaptiveInterval, time.Second*10),
+	)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateRawPayload(pipe.String(pipeRawPayload, ""))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateFormat(pipe.String(pipeFormat, formatJSON))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateCompat(pipe.String(pipeCompat, ""))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	contentTypesMap := make(map[string]string)
+	err = pipe.Map(pipeContentTypes, contentTypesMap)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	contentTypes, err := newContentTypes(contentTypesMap)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	if pipe.Bool(pipeFlowControl, false) && pipeDuration(pipe, pipeIdleHeartbeat, 0) == 0 {
+		return nil, errors.E(op, errors.Str("flow_control requires idle_heartbeat to be set"))
+	}
+
+	jobSubjects := make(map[string]string)
+	err = pipe.Map(pipeJobSubjects, jobSubjects)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	highWatermark, lowWatermark := watermarks(uint64(pipe.Int(pipeQueueHighWatermark, 0)), uint64(pipe.Int(pipeQueueLowWatermark, 0)))
+	if highWatermark > 0 && lowWatermark >= highWatermark {
+		return nil, errors.E(op, errors.Errorf("queue_low_watermark (%d) should be less than queue_high_watermark (%d)", lowWatermark, highWatermark))
+	}
+
+	ob, err := newOutbox(pipe.Int(pipeOutboxSize, 0), pipe.String(pipeOutboxOverflow, ""))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateBroadcast(pipeList(pipe, pipeBroadcastSubjects), ob)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	routing := make(map[string]string)
+	err = pipe.Map(pipeRouting, routing)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	routes, err := newRoutes(routing)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	schema, err := loadSchema(pipe.String(pipeSchemaFile, ""))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	headers := make(map[string]string)
+	err = pipe.Map(pipeHeaders, headers)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateConsumerMode(pipe.String(pipeDurable, ""), pipe.Bool(pipeEphemeral, false), log)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	durable, generated := autoDurable(pipe.String(pipeDurable, ""), pipe.String(pipeStream, "default-stream"), pipe.Name(), pipe.String(pipeSubject, "default"))
+
+	group, err := newPriorityGroup(
+		pipe.String(pipePriorityGroup, ""),
+		pipe.Bool(pipePull, false),
+		durable,
+		int64(pipe.Int(pipePriorityMinPending, 0)),
+		int64(pipe.Int(pipePriorityMinAckPending, 0)),
 	)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
 
+	err = validateDeliverPolicy(pipe.Bool(pipeDeliverAll, false), pipe.Bool(pipeDeliverNew, false), pipe.Bool(pipeDeliverLastPerSubject, false))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateAckPolicy(pipe.String(pipeAckPolicy, ackPolicyExplicit))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validatePull(pipe.Bool(pipePull, false), pipe.Has)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateDeliverGroup(pipe.String(pipeDeliverGroup, ""), pipe.Bool(pipeEphemeral, false), pipeDuration(pipe, pipeIdleHeartbeat, 0), pipe.Bool(pipeFlowControl, false))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateHeadersOnly(pipe.Bool(pipeHeadersOnly, false), pipe.Bool(pipeRequeueRepublish, false), pipe.String(pipeDLQSubject, ""))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	onDecodeError := pipe.String(pipeOnDecodeError, onDecodeErrorDefault(pipe.String(pipeDLQSubject, "")))
+	err = validateOnDecodeError(onDecodeError, pipe.String(pipeDLQSubject, ""))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = validateSampleFreq(pipe.String(pipeSampleFreq, ""))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	jobsLimiter, err := newTokenBucket(pipe.Int(pipeJobsPerSecond, 0), pipe.Int(pipeJobsBurst, 0))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	arch, err := newArchiver(pipe.String(pipeArchiveSubject, ""), pipe.Int(pipeArchiveBatch, 100), pipeDuration(pipe, pipeArchiveInterval, time.Second))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	priorityMap := make(map[string]string)
+	err = pipe.Map(pipePriorityMap, priorityMap)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	priorities, err := parsePriorityMap(priorityMap)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	lanes, err := newLanes(pipe.String(pipeSubject, "default"), priorities)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	// the driver is created after the connection, handlers get it via the holder
+	drv := &atomic.Pointer[Driver]{}
+	connOpts, err := connOptions(conf, pipe.Name(), log)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	rot := newRotation(conf)
+	connOpts = append(connOpts, rot.options()...)
+
+	var conn *nats.Conn
+	err = startupRetry(conf, log, "connect", func() error {
+		var errC error
+		conn, errC = nats.Connect(conf.Addr, append(connOpts, driverHandlers(drv, log)...)...)
+		return errC
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	// the connection is closed on any of the following errors, the driver isn't returned to the jobs plugin
+	started := false
+	defer func() {
+		if !started {
+			conn.Close()
+		}
+	}()
+
 	js, err := conn.JetStream()
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
 
+	so := &streamOptions{
+		name:           pipe.String(pipeStream, "default-stream"),
+		subject:        pipe.String(pipeSubject, "default"),
+		update:         pipe.Bool(pipeUpdateStream, false),
+		mirror:         pipe.String(pipeMirror, ""),
+		sources:        pipeList(pipe, pipeSources),
+		external:       newExternal(pipe.String(pipeSourceDomain, ""), pipe.String(pipeSourceAPIPrefix, ""), pipe.String(pipeSourceDeliverPrefix, "")),
+		republish:      newRePublish(pipe.String(pipeRePublishSource, ""), pipe.String(pipeRePublishDestination, ""), pipe.Bool(pipeRePublishHeadersOnly, false)),
+		compression:    pipe.String(pipeCompression, ""),
+		placement:      newPlacement(pipe.String(pipePlacementCluster, ""), pipeList(pipe, pipePlacementTags)),
+		transform:      newSubjectTransform(pipe.String(pipeSubjectTransformSource, ""), pipe.String(pipeSubjectTransformDestination, "")),
+		allowRollup:    pipe.Bool(pipeAllowRollupHdrs, false),
+		denyDelete:     pipe.Bool(pipeDenyDelete, false),
+		denyPurge:      pipe.Bool(pipeDenyPurge, false),
+		deleteAfterAck: pipe.Bool(pipeDeleteAfterAck, false),
+	}
+
+	err = so.validate()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
 	var si *nats.StreamInfo
-	si, err = js.StreamInfo(pipe.String(pipeStream, "default-stream"))
-	if err != nil {
-		if stderr.Is(err, nats.ErrStreamNotFound) {
-			si, err = js.AddStream(&nats.StreamConfig{
-				Name:     pipe.String(pipeStream, "default-stream"),
-				Subjects: []string{pipe.String(pipeSubject, "default")},
-			})
-			if err != nil {
-				return nil, errors.E(op, err)
-			}
-		} else {
+	err = startupRetry(conf, log, "stream", func() error {
+		var errS error
+		si, errS = ensureStream(js, conn, log, so)
+		return errS
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = checkDLQ(js, pipe.String(pipeDLQSubject, ""))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	deleteAfterAck, err := retentionDeleteAfterAck(si, so.deleteAfterAck, pipe.Bool(pipeEraseAfterAck, false), log)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	del, err := newDeleter(
+		deleteAfterAck,
+		pipe.Bool(pipeEraseAfterAck, false),
+		pipe.Int(pipeDeleteBatch, 0),
+		pipeDuration(pipe, pipeDeleteInterval, time.Millisecond*100),
+	)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	var obs nats.ObjectStore
+	objectBucket := pipe.String(pipeObjectBucket, "")
+	err = validateObjectBucket(pipe.Int(pipeMaxInlinePayload, 0), objectBucket)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	// consumers resolve the references even if the pipeline doesn't store the payloads itself
+	if objectBucket != "" {
+		obs, err = initObjectStore(js, objectBucket)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	var kv nats.KeyValue
+	if pipe.Bool(pipeUniqueJobs, false) {
+		bucket := pipe.String(pipeKVBucket, "")
+		err = validateUniqueBucket(bucket)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		kv, err = initUniqueJobs(js, bucket, pipeDuration(pipe, pipeUniqueTTL, time.Hour))
+		if err != nil {
 			return nil, errors.E(op, err)
 		}
 	}
 
-	if si == nil {
-		return nil, errors.E(op, errors.Str("failed to create a stream"))
+	var statusKV nats.KeyValue
+	if bucket := pipe.String(pipeStatusBucket, ""); bucket != "" {
+		statusKV, err = initStatusBucket(js, bucket, pipeDuration(pipe, pipeStatusTTL, time.Hour*24))
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
 	}
 
 	cs := &Driver{
-		log:    log,
-		queue:  pq,
-		stopCh: make(chan struct{}),
-
-		conn:               conn,
-		js:                 js,
-		priority:           pipe.Priority(),
-		consumeAll:         pipe.Bool(pipeConsumeAll, false),
-		subject:            pipe.String(pipeSubject, "default"),
-		stream:             pipe.String(pipeStream, "default-stream"),
-		prefetch:           pipe.Int(pipePrefetch, 100),
-		deleteAfterAck:     pipe.Bool(pipeDeleteAfterAck, false),
-		deliverNew:         pipe.Bool(pipeDeliverNew, false),
-		deleteStreamOnStop: pipe.Bool(pipeDeleteStreamOnStop, false),
-		rateLimit:          uint64(pipe.Int(pipeRateLimit, 1000)),
-		msgCh:              make(chan *nats.Msg, pipe.Int(pipePrefetch, 100)),
+		log:       log,
+		queue:     pq,
+		limiter:   shared.Limiter,
+		stopOrder: shared.StopOrder,
+		accounts:  shared.Accounts,
+		metrics:   shared.Metrics,
+		events:    shared.Events,
+		closeCh:   make(chan struct{}),
+
+		conn:                  conn,
+		js:                    js,
+		priority:              pipe.Priority(),
+		consumeAll:            pipe.Bool(pipeConsumeAll, false),
+		stream:                pipe.String(pipeStream, "default-stream"),
+		deleteAfterAck:        deleteAfterAck,
+		deliverNew:            pipe.Bool(pipeDeliverNew, false),
+		deliverLastPerSubject: pipe.Bool(pipeDeliverLastPerSubject, false),
+		deliverAll:            pipe.Bool(pipeDeliverAll, false),
+		ackPolicy:             pipe.String(pipeAckPolicy, ackPolicyExplicit),
+		pull:                  pipe.Bool(pipePull, false),
+		maxWaiting:            pipe.Int(pipeMaxWaiting, 0),
+		fetchBatch:            pipe.Int(pipeFetchBatch, pipe.Int(pipePrefetch, 100)),
+		fetchTimeout:          pipeDuration(pipe, pipeFetchTimeout, time.Second*5),
+		jobsLimiter:           jobsLimiter,
+		archiver:              arch,
+		redeliveryThreshold:   uint64(pipe.Int(pipeRedeliveryThreshold, 0)),
+		broadcastSubjects:     pipeList(pipe, pipeBroadcastSubjects),
+		routes:                routes,
+		deleteStreamOnStop:    pipe.Bool(pipeDeleteStreamOnStop, false),
+		rateLimit:             uint64(pipe.Int(pipeRateLimit, 1000)),
+		termOnNack:            pipe.Bool(pipeTermOnNack, false),
+		dlqSubject:            pipe.String(pipeDLQSubject, ""),
+		progressInterval:      pipeDuration(pipe, pipeProgressInterval, 0),
+		canarySubject:         pipe.String(pipeCanarySubject, ""),
+		canaryWeight:          uint32(canaryWeight),
+		requeueRepublish:      pipe.Bool(pipeRequeueRepublish, false),
+		headersOnly:           pipe.Bool(pipeHeadersOnly, false),
+		sampleFreq:            pipe.String(pipeSampleFreq, ""),
+		resultSubject:         pipe.String(pipeResultSubject, ""),
+		consumerPause:         pipe.Bool(pipeConsumerPause, false),
+		pauseDeadline:         pipeDuration(pipe, pipePauseDeadline, 0),
+		drainTimeout:          pipeDuration(pipe, pipeDrainTimeout, time.Second*30),
+		statusKV:              statusKV,
+		genID:                 genID,
+		jobName:               pipe.String(pipeJobName, auto),
+		defaultPriority:       int64(pipe.Int(pipeDefaultPriority, 10)),
+		priorityHeader:        pipe.String(pipePriorityHeader, ""),
+		jobRules:              newJobRules(jobSubjects),
+		rawPayload:            pipe.String(pipeRawPayload, ""),
+		ttl:                   pipeDuration(pipe, pipeTTL, 0),
+		serverTTL:             pipe.Bool(pipeServerTTL, false) && serverMinVersion(conn, 2, 11, 0),
+		idleHeartbeat:         pipeDuration(pipe, pipeIdleHeartbeat, 0),
+		flowControl:           pipe.Bool(pipeFlowControl, false),
+		slowConsumerReduce:    pipe.Bool(pipeSlowConsumerReduce, false),
+		consumerReplicas:      pipe.Int(pipeConsumerReplicas, 0),
+		inactiveThreshold:     pipeDuration(pipe, pipeInactiveThreshold, 0),
+		description:           pipe.String(pipeDescription, ""),
+		durable:               durable,
+		autoDurable:           generated,
+		ephemeral:             pipe.Bool(pipeEphemeral, false) || durable == "",
+		consumerUpdate:        pipe.Bool(pipeConsumerUpdate, false),
+		lanes:                 lanes,
+		workers:               pipe.Int(pipeWorkers, 1),
+		highWatermark:         highWatermark,
+		outbox:                ob,
+		publishRetries:        pipe.Int(pipePublishRetries, 0),
+		publishRetryBackoff:   pipeDuration(pipe, pipePublishRetryBackoff, time.Millisecond*100),
+		breaker:               newBreaker(pipe.Int(pipeBreakerThreshold, 0), pipeDuration(pipe, pipeBreakerProbeInterval, time.Second*5)),
+		kv:                    kv,
+		obs:                   obs,
+		maxInlinePayload:      pipe.Int(pipeMaxInlinePayload, 0),
+		schema:                schema,
+		stateCacheTTL:         pipeDuration(pipe, pipeStateCacheTTL, time.Second),
+		headers:               staticHeaders(headers, pipe.Name()),
+		lowWatermark:          lowWatermark,
+		recreateStream:        pipe.Bool(pipeRecreateStream, false),
+		streamOpts:            so,
+		msgCh:                 make(chan *nats.Msg, pipe.Int(pipePrefetch, 100)),
+		rotation:              rot,
+		format:                pipe.String(pipeFormat, formatJSON),
+		compat:                pipe.String(pipeCompat, ""),
+		contentTypes:          contentTypes,
+		headerFilter:          newHeaderFilter(pipeList(pipe, pipeForwardHeaders), pipeList(pipe, pipeDropHeaders)),
+		realtimeSubjects:      newRealtime(pipeList(pipe, pipeRealtimeSubjects)),
+		insertTimeout:         pipeDuration(pipe, pipeInsertTimeout, 0),
+		middlewares:           mws,
+		ackStrategy:           strategy,
+		adaptive:              adaptive,
+		group:                 group,
+		deliverGroup:          pipe.String(pipeDeliverGroup, ""),
+		onDecodeError:         onDecodeError,
+		deleter:               del,
+		eraseAfterAck:         pipe.Bool(pipeEraseAfterAck, false),
+		queueCapacity:         uint64(pipe.Int(pipeQueueCapacity, defaultQueueCapacity)),
 	}
 
 	cs.pipeline.Store(&pipe)
+	cs.migration.dial = newStanDialer(conn)
+	cs.opts.Store(&reloadOpts{
+		subject:    pipe.String(pipeSubject, "default"),
+		prefetch:   pipe.Int(pipePrefetch, 100),
+		ackWait:    pipeDuration(pipe, pipeAckWait, 0),
+		maxDeliver: pipe.Int(pipeMaxDeliver, 0),
+	})
+	drv.Store(cs)
+	if conn.IsConnected() {
+		cs.lifecycle(EventConnected, "connection established")
+	}
+	// the service is the last thing which might fail, nothing is registered or started before it
+	err = cs.addService(conn, pipe.String(pipeMicroService, ""), pipe.String(pipeMicroVersion, "1.0.0"))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	started = true
+	cs.stopMember = cs.stopOrder.register(cs.priority)
+	cs.watchDeletion()
+	cs.startArchive()
+	cs.startDeleter()
+	cs.watchAckSamples()
+	cs.watchCredentials()
+
+	if conf.AccountInfoInterval > 0 {
+		cs.accountWatcher(conf.Addr, conf.Creds, conf.AccountInfoInterval, conf.AccountUsageThreshold)
+	}
+
+	cs.tunePrefetch()
+	cs.lagWatcher(uint64(pipe.Int(pipeMaxLag, 0)), pipeDuration(pipe, pipeLagCheckInterval, time.Second*10))
 
 	return cs, nil
 }
 
-func (c *Driver) Push(_ context.Context, job jobs.Job) error {
+func (c *Driver) Push(ctx context.Context, job jobs.Job) error {
 	const op = errors.Op("nats_consumer_push")
 	if job.Delay() > 0 {
-		return errors.E(op, errors.Str("nats doesn't support delayed messages, see: https://github.com/nats-io/nats-streaming-server/issues/324"))
+		return errors.E(op, errors.Str("nats doesn't support delayed messages"))
+	}
+
+	err := c.validatePayload(job)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	subject := c.pushSubject(job.Name(), job.ID())
+	hdr, err := expectHeaders(job, c.expirationHeaders(job))
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	var v any = job
+	if c.headerFilter != nil || len(c.middlewares) > 0 {
+		item := jobItem(job)
+		item.Headers = c.headerFilter.apply(item.Headers)
+
+		ok, errM := c.beforePublish(item)
+		if errM != nil {
+			return errors.E(op, errM)
+		}
+
+		if !ok {
+			c.log.Debug("job was filtered out by the middleware", zap.String("id", job.ID()))
+			return nil
+		}
+
+		v = item
+	}
+
+	// the payload is stored in the object store, only the reference is published
+	if c.obs != nil {
+		if item := jobItem(v); c.oversized(item) {
+			claimItem, claimHdr, errC := c.claimCheck(item, hdr)
+			if errC != nil {
+				return errors.E(op, errC)
+			}
+
+			v, hdr = claimItem, claimHdr
+		}
 	}
 
-	data, err := json.Marshal(job)
+	buf, hdr, err := c.encode(v, hdr)
 	if err != nil {
 		return errors.E(op, err)
 	}
 
-	_, err = c.js.Publish(c.subject, data)
+	err = c.checkPayloadSize(buf.Len())
 	if err != nil {
+		c.pools.putBuffer(buf)
+		return errors.E(op, err)
+	}
+
+	// connection is down or the outbox isn't flushed yet, buffer the message to preserve the order
+	if c.outbox != nil && (!c.connected() || c.outbox.len() > 0) {
+		err = c.pushOutbox(subject, buf.Bytes(), withMsgID(hdr, job.ID()))
+		c.pools.putBuffer(buf)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		return nil
+	}
+
+	// buffered pushes are deduplicated only by the Nats-Msg-Id within the stream duplicates window
+	if c.kv != nil {
+		ok, errR := c.reserve(job.ID())
+		if errR != nil {
+			c.pools.putBuffer(buf)
+			return errors.E(op, errR)
+		}
+
+		if !ok {
+			c.pools.putBuffer(buf)
+			c.log.Debug("duplicate job skipped", zap.String("id", job.ID()))
+			return nil
+		}
+	}
+
+	var acks []*nats.PubAck
+	if len(c.broadcastSubjects) > 0 {
+		acks, err = c.publishBroadcast(subject, buf.Bytes(), hdr, job.ID())
+	} else {
+		// the retried publish might be stored already (ack timeout), the server deduplicates it by the job ID
+		if c.publishRetries > 0 {
+			hdr = withMsgID(hdr, job.ID())
+		}
+
+		// the data is copied into the connection buffer on publish
+		var ack *nats.PubAck
+		ack, err = c.publish(ctx, subject, buf.Bytes(), hdr)
+		acks = []*nats.PubAck{ack}
+	}
+	c.pools.putBuffer(buf)
+	if err != nil {
+		if c.kv != nil {
+			c.release(job.ID())
+		}
+
 		return errors.E(op, err)
 	}
 
+	c.published(job.ID(), acks)
+	c.trackStatus(&JobStatus{ID: job.ID(), Job: job.Name(), Status: jobQueued})
+
 	job = nil
 	return nil
 }
@@ -257,21 +1260,34 @@ func (c *Driver) Run(_ context.Context, p jobs.Pipeline) error {
 		return errors.E(op, errors.Errorf("no such pipeline registered: %s", pipe.Name()))
 	}
 
-	l := atomic.LoadUint32(&c.listeners)
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	st := c.loadState()
 	// listener already active
-	if l == 1 {
+	if st == stateRunning {
 		c.log.Warn("nats listener is already in the active state")
 		return nil
 	}
 
-	atomic.AddUint32(&c.listeners, 1)
+	if st == stateStopped {
+		return ErrDriverStopped
+	}
+
+	next, ok := st.next(transitionRun)
+	if !ok {
+		return errors.E(op, errors.Errorf("can't run the pipeline in the %s state", st))
+	}
+
 	err := c.listenerInit()
 	if err != nil {
 		return errors.E(op, err)
 	}
 
 	c.listenerStart()
+	c.storeState(next)
 
+	c.lifecycle(EventPipelineStarted, "pipeline started")
 	c.log.Debug("pipeline was started", zap.String("driver", pipe.Driver()), zap.String("pipeline", pipe.Name()), zap.Time("start", start), zap.Duration("elapsed", time.Since(start)))
 	return nil
 }
@@ -284,25 +1300,38 @@ func (c *Driver) Pause(_ context.Context, p string) error {
 		return errors.Errorf("no such pipeline: %s", pipe.Name())
 	}
 
-	l := atomic.LoadUint32(&c.listeners)
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	st := c.loadState()
+	if st == stateStopped {
+		return ErrDriverStopped
+	}
+
+	next, ok := st.next(transitionPause)
 	// no active listeners
-	if l == 0 {
+	if !ok {
 		return errors.Str("no active listeners, nothing to pause")
 	}
 
-	// remove listener
-	atomic.AddUint32(&c.listeners, ^uint32(0))
+	c.storeState(next)
 
-	if c.sub != nil {
-		err := c.sub.Drain()
-		if err != nil {
-			c.log.Error("drain error", zap.Error(err))
-		}
+	// the listener keeps running, the server doesn't deliver the messages to the paused consumers
+	if c.serverPause() {
+		c.lifecycle(EventPipelinePaused, "pipeline paused server-side")
+		c.log.Debug("pipeline consumers were paused", zap.String("driver", pipe.Driver()), zap.String("pipeline", pipe.Name()), zap.Time("start", start), zap.Duration("elapsed", time.Since(start)))
+		return nil
 	}
 
-	c.stopCh <- struct{}{}
-	c.sub = nil
+	// ephemeral consumers are removed on drain
+	if c.ephemeral {
+		c.saveResumePoints()
+	}
+
+	c.drain()
+	c.stopListener()
 
+	c.lifecycle(EventPipelinePaused, "pipeline paused")
 	c.log.Debug("pipeline was paused", zap.String("driver", pipe.Driver()), zap.String("pipeline", pipe.Name()), zap.Time("start", start), zap.Duration("elapsed", time.Since(start)))
 
 	return nil
@@ -315,67 +1344,124 @@ func (c *Driver) Resume(_ context.Context, p string) error {
 		return errors.Errorf("no such pipeline: %s", pipe.Name())
 	}
 
-	l := atomic.LoadUint32(&c.listeners)
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	st := c.loadState()
 	// listener already active
-	if l == 1 {
+	if st == stateRunning {
 		return errors.Str("nats listener is already in the active state")
 	}
 
-	err := c.listenerInit()
+	// the subscriptions can't be re-created, the connection is closed
+	if st == stateStopped {
+		return ErrDriverStopped
+	}
+
+	next, ok := st.next(transitionResume)
+	if !ok {
+		return errors.Errorf("can't resume the pipeline in the %s state", st)
+	}
+
+	resumed, err := c.serverResume()
 	if err != nil {
 		return err
 	}
 
-	c.listenerStart()
+	if resumed {
+		c.storeState(next)
+		c.lifecycle(EventPipelineResumed, "pipeline resumed server-side")
+		c.log.Debug("pipeline consumers were resumed", zap.String("driver", pipe.Driver()), zap.String("pipeline", pipe.Name()), zap.Time("start", start), zap.Duration("elapsed", time.Since(start)))
+		return nil
+	}
 
-	atomic.AddUint32(&c.listeners, 1)
+	err = c.listenerInit()
+	if err != nil {
+		return err
+	}
 
+	// used only once, the consumers re-created later (e.g. after the stream recreation) use the deliver policy
+	c.resumePoints.Store(map[string]uint64{})
+
+	c.listenerStart()
+	c.storeState(next)
+
+	c.lifecycle(EventPipelineResumed, "pipeline resumed")
 	c.log.Debug("pipeline was resumed", zap.String("driver", pipe.Driver()), zap.String("pipeline", pipe.Name()), zap.Time("start", start), zap.Duration("elapsed", time.Since(start)))
 
 	return nil
 }
 
-func (c *Driver) State(_ context.Context) (*jobs.State, error) {
+// State reports the pipeline subject as the queue. The consumer names (including the ones generated
+// for the durable: auto) are not part of the jobs state, they are reported by the Health RPC.
+func (c *Driver) State(ctx context.Context) (*jobs.State, error) {
 	pipe := *c.pipeline.Load()
 
 	st := &jobs.State{
 		Pipeline: pipe.Name(),
 		Priority: uint64(pipe.Priority()),
 		Driver:   pipe.Driver(),
-		Queue:    c.subject,
-		Ready:    ready(atomic.LoadUint32(&c.listeners)),
+		Queue:    c.opts.Load().subject,
+		Ready:    c.listening() && !c.closed.Load() && !c.breaker.isOpen(),
 	}
 
-	if c.sub != nil {
-		ci, err := c.sub.ConsumerInfo()
-		if err != nil {
-			return nil, err
-		}
+	// connection is permanently closed, report the pipeline as not ready
+	if c.closed.Load() {
+		return st, nil
+	}
 
-		if ci != nil {
-			st.Active = int64(ci.NumAckPending)
-			st.Reserved = int64(ci.NumWaiting)
-			st.Delayed = 0
-		}
+	stats, err := c.consumerStats(ctx)
+	if err != nil {
+		return nil, err
 	}
 
+	st.Active = stats.active
+	st.Reserved = stats.reserved
+
 	return st, nil
 }
 
-func (c *Driver) Stop(_ context.Context) error {
+func (c *Driver) Stop(ctx context.Context) error {
 	start := time.Now()
 
-	if atomic.LoadUint32(&c.listeners) > 0 {
-		if c.sub != nil {
-			err := c.sub.Drain()
-			if err != nil {
-				c.log.Error("drain error", zap.Error(err))
-			}
-		}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	prev := c.loadState()
+	next, ok := prev.next(transitionStop)
+	// already stopped, the connection is closed
+	if !ok {
+		return nil
+	}
 
-		c.stopCh <- struct{}{}
+	c.storeState(next)
+
+	// less important pipelines are stopped first
+	deadline := c.stopOrder.wait(ctx, c.stopMember)
+	defer c.stopMember.done()
+
+	switch {
+	case prev == stateRunning:
+		c.drain()
+		c.stopListener()
+	case prev == statePaused && c.serverPaused.Load():
+		// the listener of the server-side paused pipeline is still running, the durable consumers
+		// should not stay paused after the restart
+		refs, _ := c.consumerRefs()
+		c.drain()
+		c.stopListener()
+		err := c.pauseConsumers(refs, time.Time{})
+		if err != nil {
+			c.log.Warn("failed to resume the paused consumers", zap.Error(err))
+		}
 	}
 
+	c.waitInflight(deadline)
+	c.stopService()
+	close(c.closeCh)
+	c.archiver.wait()
+	c.deleter.wait()
+
 	if c.deleteStreamOnStop {
 		err := c.js.DeleteStream(c.stream)
 		if err != nil {
@@ -383,14 +1469,23 @@ func (c *Driver) Stop(_ context.Context) error {
 		}
 	}
 
+	if c.outbox != nil && c.connected() {
+		c.flushOutbox()
+	}
+
+	if n := c.outbox.len(); n > 0 {
+		c.log.Warn("outbox wasn't flushed, buffered messages are lost", zap.Int("messages", n))
+	}
+
 	pipe := *c.pipeline.Load()
-	err := c.conn.Drain()
+	c.stopping.Store(true)
+	err := c.closeConn()
 	if err != nil {
 		return err
 	}
 
-	c.conn.Close()
-	c.msgCh = nil
+	// msgCh is owned by the driver for its whole lifetime, the listener goroutines exit on the stopCh
+	c.lifecycle(EventPipelineStopped, "pipeline stopped")
 	c.log.Debug("pipeline was stopped", zap.String("driver", pipe.Driver()), zap.String("pipeline", pipe.Name()), zap.Time("start", start), zap.Duration("elapsed", time.Since(start)))
 
 	return nil
@@ -401,43 +1496,57 @@ func (c *Driver) Stop(_ context.Context) error {
 func (c *Driver) requeue(item *Item) error {
 	const op = errors.Op("nats_requeue")
 	if item.Options.Delay > 0 {
-		return errors.E(op, errors.Str("nats doesn't support delayed messages, see: https://github.com/nats-io/nats-streaming-server/issues/324"))
+		return errors.E(op, errors.Str("nats doesn't support delayed messages"))
 	}
 
-	data, err := json.Marshal(item)
+	// the stored payload is reused by the new message
+	var v any = item
+	var hdr nats.Header
+	if item.Options.claim != "" {
+		v = &Item{
+			Job:     item.Job,
+			Ident:   item.Ident,
+			Headers: item.Headers,
+			Options: item.Options,
+		}
+		hdr = nats.Header{headerClaimCheck: []string{item.Options.claim}}
+	}
+
+	buf, hdr, err := c.encode(v, hdr)
 	if err != nil {
 		return errors.E(op, err)
 	}
 
-	_, err = c.js.Publish(c.subject, data)
+	_, err = c.publishMsg(c.opts.Load().subject, buf.Bytes(), hdr)
+	c.pools.putBuffer(buf)
 	if err != nil {
 		return errors.E(op, err)
 	}
 
 	// delete the old message
-	_ = c.js.DeleteMsg(c.stream, item.Options.seq)
+	_ = c.js.DeleteMsg(item.Options.stream, item.Options.seq)
 
 	item = nil
 	return nil
 }
 
-func reconnectHandler(log *zap.Logger) func(*nats.Conn) {
-	return func(conn *nats.Conn) {
-		log.Warn("connection lost, reconnecting", zap.String("url", conn.ConnectedUrl()))
-	}
-}
+// dlq copies the terminated message to the dead letter subject
+func (c *Driver) dlq(item *Item) error {
+	const op = errors.Op("nats_dlq")
 
-func disconnectHandler(log *zap.Logger) func(*nats.Conn, error) {
-	return func(_ *nats.Conn, err error) {
-		if err != nil {
-			log.Error("nast disconnected", zap.Error(err))
-			return
-		}
+	buf, err := c.pools.marshal(item)
+	if err != nil {
+		return errors.E(op, err)
+	}
 
-		log.Warn("nast disconnected")
+	_, err = c.publishMsg(c.dlqSubject, buf.Bytes(), nil)
+	c.pools.putBuffer(buf)
+	if err != nil {
+		return errors.E(op, err)
 	}
-}
 
-func ready(r uint32) bool {
-	return r > 0
+	c.log.Debug("message was moved to the dead letter subject", zap.String("id", item.ID()), zap.String("subject", c.dlqSubject))
+	c.lifecycle(EventDLQ, "message "+item.ID()+" moved to the dead letter subject "+c.dlqSubject)
+
+	return nil
 }
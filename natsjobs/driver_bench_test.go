@@ -0,0 +1,259 @@
+package natsjobs
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const benchPayload = `{"user":42,"action":"send","template":"welcome","locale":"en"}`
+
+// benchConfig is the global nats section with the defaults, the address is overridden by the embedded server
+type benchConfig struct{}
+
+func (benchConfig) UnmarshalKey(_ string, out any) error {
+	*(out.(**config)) = &config{}
+	return nil
+}
+
+func (benchConfig) Has(string) bool { return true }
+
+// benchJob is the job pushed by the jobs plugin, encoded as the item
+type benchJob struct {
+	Item
+}
+
+func newBenchJob(id string) *benchJob {
+	return &benchJob{Item{
+		Job:     "bench",
+		Ident:   id,
+		Payload: benchPayload,
+		Headers: map[string][]string{"X-Bench": {"1"}},
+		Options: &Options{Priority: 10, Pipeline: "bench"},
+	}}
+}
+
+func (j *benchJob) Name() string                 { return j.Job }
+func (j *benchJob) Payload() string              { return j.Item.Payload }
+func (j *benchJob) Headers() map[string][]string { return j.Item.Headers }
+func (j *benchJob) Pipeline() string             { return j.Options.Pipeline }
+func (j *benchJob) Delay() int64                 { return j.Options.Delay }
+func (j *benchJob) AutoAck() bool                { return j.Options.AutoAck }
+func (j *benchJob) Offset() int64                { return 0 }
+func (j *benchJob) Partition() int32             { return 0 }
+func (j *benchJob) Topic() string                { return "" }
+func (j *benchJob) Metadata() string             { return "" }
+func (j *benchJob) UpdatePriority(p int64)       { j.Options.Priority = p }
+
+// newEmbeddedDriver starts the embedded server and the pipeline connected to it
+func newEmbeddedDriver(tb testing.TB, pipe testPipeline) (*Driver, *testQueue) {
+	tb.Helper()
+
+	srv, err := StartEmbedded(tb.TempDir(), 0, zap.NewNop())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(srv.Shutdown)
+
+	pipe["driver"] = pluginName
+	if _, ok := pipe["name"]; !ok {
+		pipe["name"] = "bench"
+	}
+	if _, ok := pipe[pipeStream]; !ok {
+		pipe[pipeStream] = "bench"
+	}
+	if _, ok := pipe[pipeSubject]; !ok {
+		pipe[pipeSubject] = "bench.default"
+	}
+
+	q := &testQueue{}
+	c, err := FromPipeline(pipe, zap.NewNop(), benchConfig{}, q, &Shared{EmbeddedURL: srv.ClientURL()}, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = c.Stop(context.Background())
+	})
+
+	return c, q
+}
+
+// newBenchUnpacker returns the driver with the default decoders, without the connection
+func newBenchUnpacker(tb testing.TB) *Driver {
+	ct, err := newContentTypes(nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &Driver{
+		log:             zap.NewNop(),
+		contentTypes:    ct,
+		defaultPriority: 10,
+		priority:        10,
+		genID:           func() string { return "generated" },
+	}
+}
+
+// benchMessages returns the same item encoded as JSON and as the protobuf envelope
+func benchMessages(tb testing.TB, c *Driver) (*nats.Msg, *nats.Msg) {
+	job := newBenchJob("1")
+
+	buf, err := c.pools.marshal(&job.Item)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	jsonMsg := jsMsg("bench.default", append([]byte(nil), buf.Bytes()...), 1)
+	c.pools.putBuffer(buf)
+
+	protoMsg := jsMsg("bench.default", appendEnvelope(nil, &job.Item), 1)
+	protoMsg.Header.Set(headerContentType, contentTypeProto)
+
+	return jsonMsg, protoMsg
+}
+
+func benchPush(b *testing.B, c *Driver) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := c.Push(context.Background(), newBenchJob(strconv.Itoa(i)))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPush(b *testing.B) {
+	c, _ := newEmbeddedDriver(b, testPipeline{})
+	benchPush(b, c)
+}
+
+// the broadcast publishes asynchronously and waits for the acks of all subjects
+func BenchmarkPushBroadcast(b *testing.B) {
+	c, _ := newEmbeddedDriver(b, testPipeline{pipeBroadcastSubjects: []string{"bench.copy"}})
+	_, err := c.js.AddStream(&nats.StreamConfig{Name: "bench-copy", Subjects: []string{"bench.copy"}})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchPush(b, c)
+}
+
+func BenchmarkPushParallel(b *testing.B) {
+	c, _ := newEmbeddedDriver(b, testPipeline{})
+
+	var id atomic.Uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			err := c.Push(context.Background(), newBenchJob(strconv.FormatUint(id.Add(1), 10)))
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkConsume pushes the job and waits until it's delivered to the priority queue and acknowledged
+func BenchmarkConsume(b *testing.B) {
+	for _, tt := range []struct {
+		name string
+		pipe testPipeline
+	}{
+		{"json", testPipeline{}},
+		{"proto", testPipeline{pipeFormat: formatProto}},
+		{"workers", testPipeline{pipeWorkers: 4}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			c, q := newEmbeddedDriver(b, tt.pipe)
+			err := c.Run(context.Background(), testPipeline{"name": "bench"})
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				err = c.Push(context.Background(), newBenchJob(strconv.Itoa(i)))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			deadline := time.Now().Add(time.Minute)
+			for consumed := 0; consumed < b.N; {
+				item := q.ExtractMin()
+				if item == nil {
+					if time.Now().After(deadline) {
+						b.Fatalf("consumed %d of %d jobs", consumed, b.N)
+					}
+
+					time.Sleep(time.Microsecond * 50)
+					continue
+				}
+
+				err = item.(*Item).Ack()
+				if err != nil {
+					b.Fatal(err)
+				}
+				consumed++
+			}
+		})
+	}
+}
+
+func BenchmarkUnpack(b *testing.B) {
+	c := newBenchUnpacker(b)
+	jsonMsg, protoMsg := benchMessages(b, c)
+
+	for _, tt := range []struct {
+		name string
+		m    *nats.Msg
+	}{
+		{"json", jsonMsg},
+		{"proto", protoMsg},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			meta, err := tt.m.Metadata()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(tt.m.Data)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				err = c.unpack(tt.m, meta, &Item{})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	var p pools
+	job := newBenchJob("1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf, err := p.marshal(job)
+		if err != nil {
+			b.Fatal(err)
+		}
+		p.putBuffer(buf)
+	}
+}
@@ -0,0 +1,116 @@
+package natsjobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequeueRepublishesAndDeletes(t *testing.T) {
+	js := &fakeJS{}
+	c, _ := newTestDriver(t, js)
+
+	item := &Item{
+		Job:     "job",
+		Ident:   "1",
+		Payload: "payload",
+		Headers: map[string][]string{"X-Test": {"v"}},
+		Options: &Options{Priority: 3, Pipeline: "test", stream: "jobs", seq: 7},
+	}
+
+	require.NoError(t, c.requeue(item))
+
+	require.Len(t, js.published, 1)
+	assert.Equal(t, "jobs.default", js.published[0].Subject)
+
+	var got Item
+	require.NoError(t, json.Unmarshal(js.published[0].Data, &got))
+	assert.Equal(t, "1", got.Ident)
+	assert.Equal(t, "payload", got.Payload)
+	assert.EqualValues(t, 3, got.Options.Priority)
+
+	// the old message is removed from the stream
+	assert.Equal(t, []uint64{7}, js.deleted)
+}
+
+func TestRequeueUsesReloadedSubject(t *testing.T) {
+	js := &fakeJS{}
+	c, _ := newTestDriver(t, js)
+	c.opts.Store(&reloadOpts{subject: "jobs.reloaded"})
+
+	require.NoError(t, c.requeue(&Item{Ident: "1", Options: &Options{stream: "jobs", seq: 1}}))
+	require.Len(t, js.published, 1)
+	assert.Equal(t, "jobs.reloaded", js.published[0].Subject)
+}
+
+func TestRequeueClaimReusesPayload(t *testing.T) {
+	js := &fakeJS{}
+	c, _ := newTestDriver(t, js)
+
+	item := &Item{
+		Ident:   "1",
+		Payload: "large payload stored in the object store",
+		Options: &Options{stream: "jobs", seq: 1, claim: "claim-1"},
+	}
+
+	require.NoError(t, c.requeue(item))
+	require.Len(t, js.published, 1)
+	assert.Equal(t, "claim-1", js.published[0].Header.Get(headerClaimCheck))
+
+	var got Item
+	require.NoError(t, json.Unmarshal(js.published[0].Data, &got))
+	assert.Empty(t, got.Payload)
+}
+
+func TestRequeueErrors(t *testing.T) {
+	js := &fakeJS{}
+	c, _ := newTestDriver(t, js)
+
+	require.Error(t, c.requeue(&Item{Ident: "1", Options: &Options{Delay: 10}}))
+	assert.Empty(t, js.published)
+
+	js.publishErr = nats.ErrNoStreamResponse
+	c.streamOpts = &streamOptions{name: "jobs"}
+	require.Error(t, c.requeue(&Item{Ident: "1", Options: &Options{stream: "jobs", seq: 1}}))
+	// the message isn't deleted if it wasn't republished
+	assert.Empty(t, js.deleted)
+}
+
+func TestPushRetryStopsOnContext(t *testing.T) {
+	js := &fakeJS{publishErr: nats.ErrTimeout}
+	c, _ := newTestDriver(t, js)
+	c.conn = fakeConn{}
+	c.publishRetries = 5
+	c.publishRetryBackoff = time.Second * 10
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	start := time.Now()
+	err := c.Push(ctx, newBenchJob("1"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), nats.ErrTimeout.Error())
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestPublishRetryStopsOnStop(t *testing.T) {
+	js := &fakeJS{publishErr: nats.ErrNoResponders}
+	c, _ := newTestDriver(t, js)
+	c.publishRetries = 5
+	c.publishRetryBackoff = time.Second * 10
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		close(c.closeCh)
+	}()
+
+	start := time.Now()
+	_, err := c.publish(context.Background(), "jobs.default", []byte("{}"), nil)
+	require.ErrorIs(t, err, nats.ErrNoResponders)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,36 @@
+package natsjobs
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// durableAuto derives the durable name from the stream, pipeline and subject
+const durableAuto string = "auto"
+
+// autoDurable returns the deterministic durable name for the durable: auto, so the same pipeline gets the same
+// consumer in every environment. The hash of the subject prevents the pipelines with the same name but the different
+// subjects from sharing the consumer.
+func autoDurable(durable, stream, pipeline, subject string) (string, bool) {
+	if durable != durableAuto {
+		return durable, false
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(stream + "\x00" + pipeline + "\x00" + subject))
+
+	return "rr_" + durableToken(stream) + "_" + durableToken(pipeline) + "_" + strconv.FormatUint(uint64(h.Sum32()), 36), true
+}
+
+// durableToken replaces the characters not allowed in the consumer names
+func durableToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,115 @@
+package natsjobs
+
+import (
+	"os"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+const embeddedStartTimeout = time.Second * 10
+
+// EmbeddedServer is the in-process NATS server with JetStream enabled, for the development and tests only
+type EmbeddedServer struct {
+	srv *server.Server
+	dir string
+	// the store dir was created by the server and is removed on shutdown
+	tmp bool
+}
+
+// StartEmbedded starts the in-process NATS server on the localhost, the JetStream data is stored in the dir
+// or in the temp dir removed on shutdown if the dir is empty. 0 port - random.
+func StartEmbedded(dir string, port int, log *zap.Logger) (*EmbeddedServer, error) {
+	const op = errors.Op("nats_embedded_start")
+
+	e := &EmbeddedServer{dir: dir}
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "rr-nats-")
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+
+		e.dir = tmp
+		e.tmp = true
+	}
+
+	if port == 0 {
+		port = server.RANDOM_PORT
+	}
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      port,
+		JetStream: true,
+		StoreDir:  e.dir,
+		NoSigs:    true,
+	})
+	if err != nil {
+		e.cleanup()
+		return nil, errors.E(op, err)
+	}
+
+	srv.SetLoggerV2(&serverLogger{log: log.Sugar()}, false, false, false)
+	go srv.Start()
+
+	if !srv.ReadyForConnections(embeddedStartTimeout) {
+		srv.Shutdown()
+		e.cleanup()
+		return nil, errors.E(op, errors.Errorf("embedded NATS server is not ready in %s", embeddedStartTimeout))
+	}
+
+	e.srv = srv
+	log.Warn("embedded NATS server started, use it for the development and tests only", zap.String("url", srv.ClientURL()), zap.String("store_dir", e.dir))
+
+	return e, nil
+}
+
+// ClientURL returns the URL to connect to the server
+func (e *EmbeddedServer) ClientURL() string {
+	return e.srv.ClientURL()
+}
+
+// Shutdown stops the server and removes the temp store dir
+func (e *EmbeddedServer) Shutdown() {
+	e.srv.Shutdown()
+	e.srv.WaitForShutdown()
+	e.cleanup()
+}
+
+func (e *EmbeddedServer) cleanup() {
+	if e.tmp {
+		_ = os.RemoveAll(e.dir)
+	}
+}
+
+// serverLogger writes the embedded server logs to the plugin logger
+type serverLogger struct {
+	log *zap.SugaredLogger
+}
+
+func (l *serverLogger) Noticef(format string, v ...any) {
+	l.log.Infof(format, v...)
+}
+
+func (l *serverLogger) Warnf(format string, v ...any) {
+	l.log.Warnf(format, v...)
+}
+
+// Fatalf doesn't exit, the server is shut down by the plugin
+func (l *serverLogger) Fatalf(format string, v ...any) {
+	l.log.Errorf(format, v...)
+}
+
+func (l *serverLogger) Errorf(format string, v ...any) {
+	l.log.Errorf(format, v...)
+}
+
+func (l *serverLogger) Debugf(format string, v ...any) {
+	l.log.Debugf(format, v...)
+}
+
+func (l *serverLogger) Tracef(format string, v ...any) {
+	l.log.Debugf(format, v...)
+}
@@ -0,0 +1,270 @@
+package natsjobs
+
+import (
+	"bytes"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
+	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/sdk/v4/utils"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	formatJSON  string = "json"
+	formatProto string = "proto"
+
+	headerContentType string = "Content-Type"
+	contentTypeProto  string = "application/x-rr-job+proto"
+
+	envelopeVersion uint64 = 1
+)
+
+// envelope field numbers, see envelope.proto
+const (
+	fieldVersion protowire.Number = 1
+	fieldJob     protowire.Number = 2
+	fieldID      protowire.Number = 3
+	fieldPayload protowire.Number = 4
+	fieldHeaders protowire.Number = 5
+	fieldOptions protowire.Number = 6
+
+	fieldEntryKey   protowire.Number = 1
+	fieldEntryValue protowire.Number = 2
+	fieldValues     protowire.Number = 1
+
+	fieldPriority protowire.Number = 1
+	fieldPipeline protowire.Number = 2
+	fieldDelay    protowire.Number = 3
+	fieldAutoAck  protowire.Number = 4
+)
+
+func validateFormat(format string) error {
+	switch format {
+	case "", formatJSON, formatProto:
+		return nil
+	default:
+		return errors.Errorf("unknown format: %s, available: json, proto", format)
+	}
+}
+
+// encode encodes the job in the pipeline format, the proto messages are marked with the Content-Type header
+func (c *Driver) encode(v any, hdr nats.Header) (*bytes.Buffer, nats.Header, error) {
+	if c.format != formatProto {
+		buf, err := c.pools.marshal(v)
+		return buf, hdr, err
+	}
+
+	item := jobItem(v)
+	if item == nil {
+		return nil, nil, errors.Errorf("can't encode %T into the protobuf envelope", v)
+	}
+
+	buf := c.pools.getBuffer()
+	buf.Write(appendEnvelope(make([]byte, 0, len(item.Payload)+128), item))
+
+	if hdr == nil {
+		hdr = nats.Header{}
+	}
+	hdr.Set(headerContentType, contentTypeProto)
+
+	return buf, hdr, nil
+}
+
+// jobItem converts the pushed job into the item to be encoded into the envelope
+func jobItem(v any) *Item {
+	switch j := v.(type) {
+	case *Item:
+		return j
+	case jobs.Job:
+		return &Item{
+			Job:     j.Name(),
+			Ident:   j.ID(),
+			Payload: j.Payload(),
+			Headers: j.Headers(),
+			Options: &Options{
+				Priority: j.Priority(),
+				Pipeline: j.Pipeline(),
+				Delay:    j.Delay(),
+				AutoAck:  j.AutoAck(),
+			},
+		}
+	default:
+		return nil
+	}
+}
+
+// appendEnvelope appends the protobuf encoded item to b
+func appendEnvelope(b []byte, item *Item) []byte {
+	b = protowire.AppendTag(b, fieldVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, envelopeVersion)
+	b = appendString(b, fieldJob, item.Job)
+	b = appendString(b, fieldID, item.Ident)
+	b = appendString(b, fieldPayload, item.Payload)
+
+	for k, vals := range item.Headers {
+		var hv []byte
+		for i := 0; i < len(vals); i++ {
+			hv = appendString(hv, fieldValues, vals[i])
+		}
+
+		var entry []byte
+		entry = appendString(entry, fieldEntryKey, k)
+		entry = protowire.AppendTag(entry, fieldEntryValue, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, hv)
+
+		b = protowire.AppendTag(b, fieldHeaders, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+
+	if item.Options != nil {
+		var opts []byte
+		opts = appendVarint(opts, fieldPriority, uint64(item.Options.Priority))
+		opts = appendString(opts, fieldPipeline, item.Options.Pipeline)
+		opts = appendVarint(opts, fieldDelay, uint64(item.Options.Delay))
+		if item.Options.AutoAck {
+			opts = appendVarint(opts, fieldAutoAck, 1)
+		}
+
+		b = protowire.AppendTag(b, fieldOptions, protowire.BytesType)
+		b = protowire.AppendBytes(b, opts)
+	}
+
+	return b
+}
+
+// decodeEnvelope decodes the protobuf envelope into the item, unknown fields are skipped
+func decodeEnvelope(data []byte, item *Item) error {
+	const op = errors.Op("nats_decode_envelope")
+	item.Options = &Options{}
+
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == fieldVersion && typ == protowire.VarintType:
+			if n > envelopeVersion {
+				return errors.Errorf("unsupported envelope version: %d", n)
+			}
+		case num == fieldJob && typ == protowire.BytesType:
+			item.Job = string(v)
+		case num == fieldID && typ == protowire.BytesType:
+			item.Ident = string(v)
+		case num == fieldPayload && typ == protowire.BytesType:
+			item.Payload = string(v)
+		case num == fieldHeaders && typ == protowire.BytesType:
+			return decodeHeader(v, item)
+		case num == fieldOptions && typ == protowire.BytesType:
+			return decodeOptions(v, item.Options)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	return nil
+}
+
+func decodeHeader(data []byte, item *Item) error {
+	var key string
+	var values []string
+
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+
+		switch num {
+		case fieldEntryKey:
+			key = string(v)
+		case fieldEntryValue:
+			return walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) error {
+				if num == fieldValues && typ == protowire.BytesType {
+					values = append(values, string(v))
+				}
+
+				return nil
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if item.Headers == nil {
+		item.Headers = make(map[string][]string)
+	}
+
+	item.Headers[key] = values
+	return nil
+}
+
+func decodeOptions(data []byte, opts *Options) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == fieldPriority && typ == protowire.VarintType:
+			opts.Priority = int64(n)
+		case num == fieldPipeline && typ == protowire.BytesType:
+			opts.Pipeline = string(v)
+		case num == fieldDelay && typ == protowire.VarintType:
+			opts.Delay = int64(n)
+		case num == fieldAutoAck && typ == protowire.VarintType:
+			opts.AutoAck = n != 0
+		}
+
+		return nil
+	})
+}
+
+// walkFields calls fn for every field of the message, v is set for the bytes fields, n for the varint fields
+func walkFields(data []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(data) > 0 {
+		num, typ, l := protowire.ConsumeTag(data)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		data = data[l:]
+
+		var v []byte
+		var n uint64
+		switch typ { //nolint:exhaustive
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(data)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		data = data[l:]
+
+		err := fn(num, typ, v, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, utils.AsBytes(s))
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
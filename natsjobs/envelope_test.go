@@ -0,0 +1,252 @@
+package natsjobs
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func field(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(num),
+		Type:     typ.Enum(),
+		Label:    label.Enum(),
+	}
+
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+
+	return f
+}
+
+// envelopeDescriptor is the descriptor of the envelope.proto, the field numbers are checked against the file
+func envelopeDescriptor(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	)
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("envelope.proto"),
+		Package: proto.String("roadrunner.nats.jobs.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Envelope"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("version", int32(fieldVersion), descriptorpb.FieldDescriptorProto_TYPE_UINT32, optional, ""),
+					field("job", int32(fieldJob), descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("id", int32(fieldID), descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("payload", int32(fieldPayload), descriptorpb.FieldDescriptorProto_TYPE_BYTES, optional, ""),
+					field("headers", int32(fieldHeaders), descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".roadrunner.nats.jobs.v1.Envelope.HeadersEntry"),
+					field("options", int32(fieldOptions), descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".roadrunner.nats.jobs.v1.Options"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("HeadersEntry"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							field("key", int32(fieldEntryKey), descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+							field("value", int32(fieldEntryValue), descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".roadrunner.nats.jobs.v1.HeaderValues"),
+						},
+						Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					},
+				},
+			},
+			{
+				Name: proto.String("HeaderValues"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("values", int32(fieldValues), descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+				},
+			},
+			{
+				Name: proto.String("Options"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("priority", int32(fieldPriority), descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+					field("pipeline", int32(fieldPipeline), descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("delay", int32(fieldDelay), descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+					field("auto_ack", int32(fieldAutoAck), descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+				},
+			},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	return fd
+}
+
+// TestEnvelopeDescriptorMatchesProto keeps the codec field numbers in sync with the envelope.proto
+func TestEnvelopeDescriptorMatchesProto(t *testing.T) {
+	data, err := os.ReadFile("envelope.proto")
+	require.NoError(t, err)
+
+	messages := regexp.MustCompile(`(?s)message (\w+) \{(.*?)\n\}`).FindAllStringSubmatch(string(data), -1)
+	fieldRe := regexp.MustCompile(`(?m)^\s+(?:repeated )?(?:map<[^>]+>|\w+) (\w+) = (\d+);`)
+
+	fd := envelopeDescriptor(t)
+	require.Len(t, messages, fd.Messages().Len())
+
+	for _, m := range messages {
+		md := fd.Messages().ByName(protoreflect.Name(m[1]))
+		require.NotNilf(t, md, "message %s", m[1])
+
+		fields := fieldRe.FindAllStringSubmatch(m[2], -1)
+		assert.Equalf(t, md.Fields().Len(), len(fields), "message %s fields", m[1])
+
+		for _, f := range fields {
+			num, err := strconv.Atoi(f[2])
+			require.NoError(t, err)
+
+			fld := md.Fields().ByName(protoreflect.Name(f[1]))
+			require.NotNilf(t, fld, "%s.%s", m[1], f[1])
+			assert.EqualValuesf(t, num, fld.Number(), "%s.%s number", m[1], f[1])
+		}
+	}
+}
+
+func testItem() *Item {
+	return &Item{
+		Job:     "App\\Jobs\\SendEmail",
+		Ident:   "01H5Z7K3J9Q2",
+		Payload: "{\"to\":\"user@example.com\"}\x00\xff",
+		Headers: map[string][]string{
+			"X-Trace":   {"abc"},
+			"X-Multi":   {"one", "two"},
+			"X-Unicode": {"привет"},
+		},
+		Options: &Options{
+			Priority: 7,
+			Pipeline: "emails",
+			Delay:    30,
+			AutoAck:  true,
+		},
+	}
+}
+
+func TestEnvelopeEncodeDecodedByDescriptor(t *testing.T) {
+	fd := envelopeDescriptor(t)
+	item := testItem()
+
+	msg := dynamicpb.NewMessage(fd.Messages().ByName("Envelope"))
+	require.NoError(t, proto.Unmarshal(appendEnvelope(nil, item), msg))
+
+	fields := msg.Descriptor().Fields()
+	assert.EqualValues(t, envelopeVersion, msg.Get(fields.ByName("version")).Uint())
+	assert.Equal(t, item.Job, msg.Get(fields.ByName("job")).String())
+	assert.Equal(t, item.Ident, msg.Get(fields.ByName("id")).String())
+	assert.Equal(t, []byte(item.Payload), msg.Get(fields.ByName("payload")).Bytes())
+
+	headers := msg.Get(fields.ByName("headers")).Map()
+	assert.Equal(t, len(item.Headers), headers.Len())
+	for k, vals := range item.Headers {
+		hv := headers.Get(protoreflect.ValueOfString(k).MapKey()).Message()
+		list := hv.Get(hv.Descriptor().Fields().ByName("values")).List()
+
+		got := make([]string, 0, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			got = append(got, list.Get(i).String())
+		}
+		assert.Equal(t, vals, got)
+	}
+
+	opts := msg.Get(fields.ByName("options")).Message()
+	of := opts.Descriptor().Fields()
+	assert.Equal(t, item.Options.Priority, opts.Get(of.ByName("priority")).Int())
+	assert.Equal(t, item.Options.Pipeline, opts.Get(of.ByName("pipeline")).String())
+	assert.Equal(t, item.Options.Delay, opts.Get(of.ByName("delay")).Int())
+	assert.Equal(t, item.Options.AutoAck, opts.Get(of.ByName("auto_ack")).Bool())
+}
+
+func TestEnvelopeDecodeEncodedByDescriptor(t *testing.T) {
+	fd := envelopeDescriptor(t)
+	want := testItem()
+
+	msg := dynamicpb.NewMessage(fd.Messages().ByName("Envelope"))
+	fields := msg.Descriptor().Fields()
+	msg.Set(fields.ByName("version"), protoreflect.ValueOfUint32(uint32(envelopeVersion)))
+	msg.Set(fields.ByName("job"), protoreflect.ValueOfString(want.Job))
+	msg.Set(fields.ByName("id"), protoreflect.ValueOfString(want.Ident))
+	msg.Set(fields.ByName("payload"), protoreflect.ValueOfBytes([]byte(want.Payload)))
+
+	headers := msg.Mutable(fields.ByName("headers")).Map()
+	for k, vals := range want.Headers {
+		hv := headers.NewValue()
+		list := hv.Message().Mutable(hv.Message().Descriptor().Fields().ByName("values")).List()
+		for i := 0; i < len(vals); i++ {
+			list.Append(protoreflect.ValueOfString(vals[i]))
+		}
+		headers.Set(protoreflect.ValueOfString(k).MapKey(), hv)
+	}
+
+	opts := msg.Mutable(fields.ByName("options")).Message()
+	of := opts.Descriptor().Fields()
+	opts.Set(of.ByName("priority"), protoreflect.ValueOfInt64(want.Options.Priority))
+	opts.Set(of.ByName("pipeline"), protoreflect.ValueOfString(want.Options.Pipeline))
+	opts.Set(of.ByName("delay"), protoreflect.ValueOfInt64(want.Options.Delay))
+	opts.Set(of.ByName("auto_ack"), protoreflect.ValueOfBool(want.Options.AutoAck))
+
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+
+	got := &Item{}
+	require.NoError(t, decodeEnvelope(data, got))
+	assert.Equal(t, want, got)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := map[string]*Item{
+		"full":  testItem(),
+		"empty": {Options: &Options{}},
+		"negative priority": {
+			Job:     "job",
+			Options: &Options{Priority: -1, Delay: -5},
+		},
+	}
+
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := &Item{}
+			require.NoError(t, decodeEnvelope(appendEnvelope(nil, want), got))
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestEnvelopeUnknownFieldsSkipped(t *testing.T) {
+	fd := envelopeDescriptor(t)
+	msg := dynamicpb.NewMessage(fd.Messages().ByName("Envelope"))
+	require.NoError(t, proto.Unmarshal(appendEnvelope(nil, testItem()), msg))
+
+	// a field added by the newer producer
+	msg.SetUnknown(protoreflect.RawFields{0xf8, 0x01, 0x2a})
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+
+	got := &Item{}
+	require.NoError(t, decodeEnvelope(data, got))
+	assert.Equal(t, testItem(), got)
+}
+
+func TestEnvelopeNewerVersionRejected(t *testing.T) {
+	fd := envelopeDescriptor(t)
+	msg := dynamicpb.NewMessage(fd.Messages().ByName("Envelope"))
+	msg.Set(msg.Descriptor().Fields().ByName("version"), protoreflect.ValueOfUint32(uint32(envelopeVersion)+1))
+
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+
+	require.Error(t, decodeEnvelope(data, &Item{}))
+}
@@ -0,0 +1,58 @@
+package natsjobs
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// validateConsumerMode checks the explicit durable/ephemeral consumer choice
+func validateConsumerMode(durable string, ephemeral bool, log *zap.Logger) error {
+	if durable != "" && ephemeral {
+		return errors.Errorf("durable (%s) and ephemeral are mutually exclusive", durable)
+	}
+
+	if durable == "" && !ephemeral {
+		log.Warn("durable consumer name is not set, the consumer is ephemeral, set ephemeral: true to make it explicit")
+	}
+
+	return nil
+}
+
+// saveResumePoints records the ack floors of the ephemeral consumers before they are removed on pause,
+// so the consumers created on resume continue from the first not acknowledged message
+func (c *Driver) saveResumePoints() {
+	subs := c.subscriptions()
+	points := make(map[string]uint64, len(subs))
+	for i := 0; i < len(subs); i++ {
+		ci, err := subs[i].ConsumerInfo()
+		if err != nil {
+			c.log.Warn("failed to get the ephemeral consumer info, the consumer will start according to the deliver policy", zap.Error(err))
+			continue
+		}
+
+		// nothing acknowledged yet, the deliver policy is used
+		if ci.AckFloor.Stream == 0 {
+			continue
+		}
+
+		points[ci.Stream+" "+ci.Config.FilterSubject] = ci.AckFloor.Stream + 1
+	}
+
+	c.resumePoints.Store(points)
+}
+
+// resumeOpt returns the start sequence option for the ephemeral consumer re-created on resume
+func (c *Driver) resumeOpt(stream, subject string) (nats.SubOpt, bool) {
+	if !c.ephemeral {
+		return nil, false
+	}
+
+	points, _ := c.resumePoints.Load().(map[string]uint64)
+	seq, ok := points[stream+" "+subject]
+	if !ok {
+		return nil, false
+	}
+
+	return nats.StartSequence(seq), true
+}
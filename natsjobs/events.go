@@ -0,0 +1,104 @@
+package natsjobs
+
+import (
+	"github.com/roadrunner-server/sdk/v4/events"
+)
+
+// EventType is the type of the events sent by the driver to the RR events bus
+type EventType uint32
+
+const (
+	// EventAccountQuota is sent when the JetStream account resource usage crosses the configured threshold
+	EventAccountQuota EventType = iota
+	// EventConnectionClosed is sent when the connection is closed permanently
+	EventConnectionClosed
+	// EventLameDuckMode is sent when the server enters the lame duck mode
+	EventLameDuckMode
+	// EventStreamDeleted is sent when the pipeline stream or consumer was deleted at runtime
+	EventStreamDeleted
+	// EventCircuitOpen is sent when the publishes start to fail fast after the consecutive failures
+	EventCircuitOpen
+	// EventCircuitClosed is sent when the JetStream is available again
+	EventCircuitClosed
+	// EventConnected is sent when the driver connection is established
+	EventConnected
+	// EventDisconnected is sent when the connection is lost
+	EventDisconnected
+	// EventReconnected is sent when the connection is restored
+	EventReconnected
+	// EventPipelineStarted is sent when the pipeline listener is started
+	EventPipelineStarted
+	// EventPipelinePaused is sent when the pipeline listener is paused
+	EventPipelinePaused
+	// EventPipelineResumed is sent when the pipeline listener is resumed
+	EventPipelineResumed
+	// EventPipelineStopped is sent when the pipeline is stopped
+	EventPipelineStopped
+	// EventDLQ is sent when the terminated message is moved to the dead letter subject
+	EventDLQ
+	// EventSlowConsumer is sent when the client drops the messages of the slow consumer
+	EventSlowConsumer
+	// EventReplayCompleted is sent when the deliver_all consumer caught up with the stream
+	EventReplayCompleted
+	// EventRedeliveryThreshold is sent when the message was delivered redelivery_threshold times (possible poison message)
+	EventRedeliveryThreshold
+	// EventLagExceeded is sent when the number of the pending messages exceeds the max_lag
+	EventLagExceeded
+)
+
+func (et EventType) String() string {
+	switch et {
+	case EventAccountQuota:
+		return "EventAccountQuota"
+	case EventConnectionClosed:
+		return "EventConnectionClosed"
+	case EventLameDuckMode:
+		return "EventLameDuckMode"
+	case EventStreamDeleted:
+		return "EventStreamDeleted"
+	case EventCircuitOpen:
+		return "EventCircuitOpen"
+	case EventCircuitClosed:
+		return "EventCircuitClosed"
+	case EventConnected:
+		return "EventConnected"
+	case EventDisconnected:
+		return "EventDisconnected"
+	case EventReconnected:
+		return "EventReconnected"
+	case EventPipelineStarted:
+		return "EventPipelineStarted"
+	case EventPipelinePaused:
+		return "EventPipelinePaused"
+	case EventPipelineResumed:
+		return "EventPipelineResumed"
+	case EventPipelineStopped:
+		return "EventPipelineStopped"
+	case EventDLQ:
+		return "EventDLQ"
+	case EventSlowConsumer:
+		return "EventSlowConsumer"
+	case EventReplayCompleted:
+		return "EventReplayCompleted"
+	case EventRedeliveryThreshold:
+		return "EventRedeliveryThreshold"
+	case EventLagExceeded:
+		return "EventLagExceeded"
+	default:
+		return "UnknownEventType"
+	}
+}
+
+// lifecycle sends the pipeline lifecycle event, the message contains the pipeline name
+func (c *Driver) lifecycle(t EventType, msg string) {
+	c.event(t, msg+", pipeline: "+(*c.pipeline.Load()).Name())
+}
+
+// event sends the driver event to the events bus (if any)
+func (c *Driver) event(t EventType, msg string) {
+	if c.events == nil {
+		return
+	}
+
+	c.events.Send(events.NewEvent(t, pluginName, msg))
+}
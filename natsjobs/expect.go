@@ -0,0 +1,49 @@
+package natsjobs
+
+import (
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
+	"github.com/roadrunner-server/errors"
+)
+
+const (
+	// ExpectLastSeqHeader makes the push fail unless the last sequence of the stream equals the header value
+	ExpectLastSeqHeader string = "rr_expect_last_seq"
+	// ExpectLastSubjectSeqHeader makes the push fail unless the last sequence of the push subject equals the header value,
+	// 0 - there should be no messages on the subject
+	ExpectLastSubjectSeqHeader string = "rr_expect_last_subject_seq"
+)
+
+// expectHeaders converts the job optimistic concurrency headers to the JetStream publish expectations
+func expectHeaders(job jobs.Job, hdr nats.Header) (nats.Header, error) {
+	headers := job.Headers()
+	if len(headers) == 0 {
+		return hdr, nil
+	}
+
+	expect := [2][2]string{
+		{ExpectLastSeqHeader, nats.ExpectedLastSeqHdr},
+		{ExpectLastSubjectSeqHeader, nats.ExpectedLastSubjSeqHdr},
+	}
+
+	for i := 0; i < len(expect); i++ {
+		v, ok := headers[expect[i][0]]
+		if !ok || len(v) == 0 {
+			continue
+		}
+
+		if _, err := strconv.ParseUint(v[0], 10, 64); err != nil {
+			return nil, errors.Errorf("%s header should be a stream sequence, got: %s", expect[i][0], v[0])
+		}
+
+		if hdr == nil {
+			hdr = nats.Header{}
+		}
+
+		hdr.Set(expect[i][1], v[0])
+	}
+
+	return hdr, nil
+}
@@ -0,0 +1,65 @@
+package natsjobs
+
+import (
+	"strings"
+)
+
+// headerFilter controls which headers are passed to the workers and published to NATS. The patterns are matched
+// case-insensitively, the trailing * matches any suffix (e.g. x-trace-*).
+type headerFilter struct {
+	// if set, only the matching headers are kept
+	forward []string
+	drop    []string
+}
+
+// newHeaderFilter returns the filter, nil if there are no rules
+func newHeaderFilter(forward, drop []string) *headerFilter {
+	if len(forward) == 0 && len(drop) == 0 {
+		return nil
+	}
+
+	return &headerFilter{
+		forward: forward,
+		drop:    drop,
+	}
+}
+
+// apply returns the filtered headers, the original map isn't modified
+func (f *headerFilter) apply(headers map[string][]string) map[string][]string {
+	if f == nil || len(headers) == 0 {
+		return headers
+	}
+
+	out := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		if len(f.forward) > 0 && !matchHeader(f.forward, k) {
+			continue
+		}
+
+		if matchHeader(f.drop, k) {
+			continue
+		}
+
+		out[k] = v
+	}
+
+	return out
+}
+
+func matchHeader(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if len(key) >= len(prefix) && strings.EqualFold(key[:len(prefix)], prefix) {
+				return true
+			}
+
+			continue
+		}
+
+		if strings.EqualFold(p, key) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package natsjobs
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	templatePipeline string = "{pipeline}"
+	templateHostname string = "{hostname}"
+	templatePID      string = "{pid}"
+)
+
+// staticHeaders resolves the configured publish headers, {pipeline} and {hostname} placeholders are replaced
+func staticHeaders(headers map[string]string, pipeline string) nats.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	r := strings.NewReplacer(templatePipeline, pipeline, templateHostname, hostname)
+
+	hdr := make(nats.Header, len(headers))
+	for k, v := range headers {
+		hdr.Set(k, r.Replace(v))
+	}
+
+	return hdr
+}
+
+// connName resolves the connection name, {pipeline}, {hostname} and {pid} placeholders are replaced
+func connName(name, pipeline string) string {
+	if name == "" {
+		return ""
+	}
+
+	hostname, _ := os.Hostname()
+	return strings.NewReplacer(
+		templatePipeline, pipeline,
+		templateHostname, hostname,
+		templatePID, strconv.Itoa(os.Getpid()),
+	).Replace(name)
+}
+
+// withStaticHeaders adds the static headers to the message headers, the message headers take precedence
+func (c *Driver) withStaticHeaders(hdr nats.Header) nats.Header {
+	if len(c.headers) == 0 {
+		return hdr
+	}
+
+	res := make(nats.Header, len(hdr)+len(c.headers))
+	for k, v := range c.headers {
+		res[k] = v
+	}
+
+	for k, v := range hdr {
+		res[k] = v
+	}
+
+	return res
+}
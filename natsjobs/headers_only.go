@@ -0,0 +1,45 @@
+package natsjobs
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+)
+
+// headerMsgSize is the size of the omitted payload set by the server for the headers only consumers
+const headerMsgSize string = "rr_nats_msg_size"
+
+// the payload is not delivered, the copies of the message would lose it
+func validateHeadersOnly(headersOnly, requeueRepublish bool, dlqSubject string) error {
+	if headersOnly && (requeueRepublish || dlqSubject != "") {
+		return errors.Str("headers_only consumer can't be used with the requeue_republish and dlq_subject, the copies would have no payload")
+	}
+
+	return nil
+}
+
+// headersItem creates the job from the headers of the message delivered without the payload
+func (c *Driver) headersItem(m *nats.Msg, item *Item) {
+	headers := make(map[string][]string, len(m.Header)+6)
+	for k, v := range m.Header {
+		headers[k] = v
+	}
+
+	if v := m.Header.Get(nats.MsgSize); v != "" {
+		headers[headerMsgSize] = []string{v}
+	}
+
+	id := m.Header.Get(nats.MsgIdHdr)
+	if id == "" {
+		id = c.genID()
+	}
+
+	*item = Item{
+		Job:     c.foreignJobName(m.Subject),
+		Ident:   id,
+		Headers: headers,
+		Options: &Options{
+			Priority: c.defaultPriority,
+			Pipeline: auto,
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package natsjobs
+
+import (
+	"sort"
+	"time"
+)
+
+// Health reports the driver connection and subscriptions state
+type Health struct {
+	Pipeline string `json:"pipeline"`
+	// Connected is false while the connection is down or closed permanently
+	Connected bool `json:"connected"`
+	// Closed is true if the connection is closed permanently (reconnects exhausted)
+	Closed bool `json:"closed"`
+	// Subscribed is false if the listener is active, but any of the subscriptions is not valid anymore
+	Subscribed  bool      `json:"subscribed"`
+	CircuitOpen bool      `json:"circuit_open"`
+	LastPublish time.Time `json:"last_publish"`
+	LastConsume time.Time `json:"last_consume"`
+	// Consumers are the names of the active consumers, including the generated durable names
+	Consumers []string `json:"consumers"`
+}
+
+// Healthy checks if the pipeline is able to publish and consume
+func (h *Health) Healthy() bool {
+	return h.Connected && h.Subscribed && !h.CircuitOpen
+}
+
+// Health returns the driver health
+func (c *Driver) Health() *Health {
+	h := &Health{
+		Pipeline:    (*c.pipeline.Load()).Name(),
+		Connected:   !c.closed.Load() && c.connected(),
+		Closed:      c.closed.Load(),
+		Subscribed:  true,
+		CircuitOpen: c.breaker.isOpen(),
+		LastPublish: unixNano(c.lastPublish.Load()),
+		LastConsume: unixNano(c.lastConsume.Load()),
+	}
+
+	names, _ := c.consumerNames.Load().(map[string]struct{})
+	for name := range names {
+		h.Consumers = append(h.Consumers, name)
+	}
+	sort.Strings(h.Consumers)
+
+	if c.listening() {
+		subs := c.subscriptions()
+		h.Subscribed = len(subs) > 0
+		for i := 0; i < len(subs); i++ {
+			if !subs[i].IsValid() {
+				h.Subscribed = false
+				break
+			}
+		}
+	}
+
+	return h
+}
+
+func unixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n)
+}
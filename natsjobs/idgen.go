@@ -0,0 +1,46 @@
+package natsjobs
+
+import (
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+	"github.com/roadrunner-server/errors"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	idGeneratorUUID  string = "uuid"
+	idGeneratorULID  string = "ulid"
+	idGeneratorKSUID string = "ksuid"
+)
+
+// IDGenerator generates the IDs for the jobs consumed without one (consume_all mode).
+// Other plugins may provide their own generators, selected by name via the id_generator option.
+type IDGenerator interface {
+	// Name returns the generator name used in the id_generator option
+	Name() string
+	// GenerateID returns a new unique job ID
+	GenerateID() string
+}
+
+// idGenerator resolves the generator function by its name, built-in generators take precedence
+func idGenerator(name string, custom map[string]IDGenerator) (func() string, error) {
+	switch name {
+	case "", idGeneratorUUID:
+		return uuid.NewString, nil
+	case idGeneratorULID:
+		// ulid.Make is monotonic within the same millisecond and safe for the concurrent use
+		return func() string {
+			return ulid.Make().String()
+		}, nil
+	case idGeneratorKSUID:
+		return func() string {
+			return ksuid.New().String()
+		}, nil
+	default:
+		if g, ok := custom[name]; ok {
+			return g.GenerateID, nil
+		}
+
+		return nil, errors.Errorf("unknown id_generator: %s, available: uuid, ulid, ksuid or the generator provided by a plugin", name)
+	}
+}
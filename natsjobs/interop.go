@@ -0,0 +1,81 @@
+package natsjobs
+
+import (
+	"sort"
+	"strings"
+)
+
+// jobRule maps the subject (might contain * and > wildcards) of the foreign message to the job name
+type jobRule struct {
+	tokens []string
+	job    string
+	// number of non-wildcard tokens, used to pick the most specific rule
+	literals int
+}
+
+// newJobRules creates the rules sorted from the most specific to the least specific one
+func newJobRules(subjects map[string]string) []jobRule {
+	rules := make([]jobRule, 0, len(subjects))
+	for subj, job := range subjects {
+		r := jobRule{
+			tokens: strings.Split(subj, "."),
+			job:    job,
+		}
+
+		for i := 0; i < len(r.tokens); i++ {
+			if r.tokens[i] != "*" && r.tokens[i] != ">" {
+				r.literals++
+			}
+		}
+
+		rules = append(rules, r)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].literals != rules[j].literals {
+			return rules[i].literals > rules[j].literals
+		}
+
+		if len(rules[i].tokens) != len(rules[j].tokens) {
+			return len(rules[i].tokens) > len(rules[j].tokens)
+		}
+
+		return strings.Join(rules[i].tokens, ".") < strings.Join(rules[j].tokens, ".")
+	})
+
+	return rules
+}
+
+// foreignJobName returns the job name for the message published by a non-RR producer
+func (c *Driver) foreignJobName(subject string) string {
+	if len(c.jobRules) > 0 {
+		tokens := strings.Split(subject, ".")
+		for i := 0; i < len(c.jobRules); i++ {
+			if subjectMatch(c.jobRules[i].tokens, tokens) {
+				return c.jobRules[i].job
+			}
+		}
+	}
+
+	return c.jobName
+}
+
+// subjectMatch matches the subject tokens against the pattern tokens using the NATS wildcards rules
+func subjectMatch(pattern, subject []string) bool {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == ">" {
+			// > should match at least one token
+			return len(subject) > i
+		}
+
+		if i >= len(subject) {
+			return false
+		}
+
+		if pattern[i] != "*" && pattern[i] != subject[i] {
+			return false
+		}
+	}
+
+	return len(pattern) == len(subject)
+}
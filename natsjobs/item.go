@@ -1,6 +1,7 @@
 package natsjobs
 
 import (
+	"bytes"
 	"fmt"
 	"time"
 
@@ -9,6 +10,21 @@ import (
 	"github.com/roadrunner-server/sdk/v4/utils"
 )
 
+const (
+	// NonRetryableHeader marks the job as non-retryable, such jobs are terminated on Nack instead of being redelivered
+	NonRetryableHeader string = "rr_non_retryable"
+	// AttemptsHeader contains the number of delivery attempts of the job, including the current one
+	AttemptsHeader string = "attempts"
+	// AckActionHeader set by the worker on Requeue picks the JetStream ack: nak (NakWithDelay with the requeue
+	// delay, the headers aren't updated) or term (Term, the message is copied to the DLQ if configured)
+	AckActionHeader string = "rr_nats_ack"
+	// InProgressQueue is the Respond queue name to extend the ack deadline (InProgress) of the long-running job
+	InProgressQueue string = "rr_nats_in_progress"
+
+	ackActionNak  string = "nak"
+	ackActionTerm string = "term"
+)
+
 type Item struct {
 	// Job contains name of job broker (usually PHP class).
 	Job string `json:"job"`
@@ -35,13 +51,28 @@ type Options struct {
 	AutoAck bool `json:"auto_ack"`
 
 	// private
-	deleteAfterAck bool
-	requeueFn      func(*Item) error
-	ack            func(...nats.AckOpt) error
-	nak            func(...nats.AckOpt) error
-	stream         string
-	seq            uint64
-	sub            nats.JetStreamContext
+	deleteAfterAck   bool
+	termOnNack       bool
+	requeueRepublish bool
+	requeueFn        func(*Item) error
+	dlqFn            func(*Item) error
+	ack              func(...nats.AckOpt) error
+	nak              func(...nats.AckOpt) error
+	nakWithDelay     func(time.Duration, ...nats.AckOpt) error
+	term             func(...nats.AckOpt) error
+	inProgress       func(...nats.AckOpt) error
+	done             func()
+	stream           string
+	seq              uint64
+	deleteMsg        func(string, uint64) error
+	claim            string
+	claimDelete      func(string)
+	archiveFn        func(*Item)
+	outcomeFn        func(*Item, string)
+	result           []byte
+	delivered        uint64
+	ackStrategy      AckStrategy
+	received         time.Time
 }
 
 // DelayDuration returns delay duration in a form of time.Duration.
@@ -88,8 +119,12 @@ func (i *Item) Context() ([]byte, error) {
 }
 
 func (i *Item) Ack() error {
+	i.finish()
+
 	// the message already acknowledged
 	if i.Options.AutoAck {
+		i.archive()
+		i.outcome(outcomeAcked)
 		return nil
 	}
 
@@ -98,8 +133,12 @@ func (i *Item) Ack() error {
 		return err
 	}
 
+	i.releaseClaim()
+	i.archive()
+	i.outcome(outcomeAcked)
+
 	if i.Options.deleteAfterAck {
-		err = i.Options.sub.DeleteMsg(i.Options.stream, i.Options.seq)
+		err = i.Options.deleteMsg(i.Options.stream, i.Options.seq)
 		if err != nil {
 			return err
 		}
@@ -109,15 +148,73 @@ func (i *Item) Ack() error {
 }
 
 func (i *Item) Nack() error {
+	i.finish()
+
 	if i.Options.AutoAck {
+		i.outcome(outcomeNacked)
 		return nil
 	}
-	return i.Options.nak()
+
+	if i.nonRetryable() {
+		return i.terminate()
+	}
+
+	decision, delay := DecisionNak, time.Duration(0)
+	if i.Options.ackStrategy != nil {
+		decision, delay = i.Options.ackStrategy.OnNack(i, i.attempts())
+	}
+
+	var err error
+	switch decision {
+	case DecisionTerm:
+		return i.terminate()
+	case DecisionAck:
+		err = i.Options.ack()
+	case DecisionNakWithDelay:
+		err = i.Options.nakWithDelay(delay)
+	default:
+		err = i.Options.nak()
+	}
+	if err != nil {
+		return err
+	}
+
+	i.outcome(outcomeNacked)
+	return nil
 }
 
-func (i *Item) Requeue(headers map[string][]string, _ int64) error {
+func (i *Item) Requeue(headers map[string][]string, delay int64) error {
 	// overwrite the delay
 	i.Headers = headers
+	i.finish()
+
+	// worker marked the job as non-retryable
+	if (markedNonRetryable(headers) || ackAction(headers) == ackActionTerm) && !i.Options.AutoAck {
+		return i.terminate()
+	}
+
+	// worker asked for the NAK explicitly, even if the requeue_republish is enabled
+	if ackAction(headers) == ackActionNak && !i.Options.AutoAck {
+		err := i.Options.nakWithDelay(time.Second * time.Duration(delay))
+		if err != nil {
+			return err
+		}
+
+		i.outcome(outcomeRequeued)
+		return nil
+	}
+
+	// auto-acked messages are already removed from the consumer, the only way to requeue them is to republish
+	if !i.Options.requeueRepublish && !i.Options.AutoAck {
+		// NAK preserves the delivery count and the stream retention semantics, but not the updated headers
+		err := i.Options.nakWithDelay(time.Second * time.Duration(delay))
+		if err != nil {
+			return err
+		}
+
+		i.outcome(outcomeRequeued)
+		return nil
+	}
 
 	err := i.Options.requeueFn(i)
 	if err != nil {
@@ -132,6 +229,8 @@ func (i *Item) Requeue(headers map[string][]string, _ int64) error {
 		return err
 	}
 
+	i.outcome(outcomeRequeued)
+
 	// ack message
 	if i.Options.AutoAck {
 		return nil
@@ -143,7 +242,7 @@ func (i *Item) Requeue(headers map[string][]string, _ int64) error {
 	}
 
 	if i.Options.deleteAfterAck {
-		err = i.Options.sub.DeleteMsg(i.Options.stream, i.Options.seq)
+		err = i.Options.deleteMsg(i.Options.stream, i.Options.seq)
 		if err != nil {
 			return err
 		}
@@ -152,6 +251,96 @@ func (i *Item) Requeue(headers map[string][]string, _ int64) error {
 	return nil
 }
 
-func (i *Item) Respond(_ []byte, _ string) error {
+// Respond saves the worker response, it is published to the result_subject (if configured) when the job is finished.
+// The response to the InProgressQueue extends the ack deadline of the job instead.
+func (i *Item) Respond(data []byte, queue string) error {
+	if queue == InProgressQueue {
+		if i.Options.inProgress == nil {
+			return nil
+		}
+
+		return i.Options.inProgress()
+	}
+
+	if i.Options.outcomeFn != nil {
+		i.Options.result = bytes.Clone(data)
+	}
+
 	return nil
 }
+
+// nonRetryable checks if the job was marked as non-retryable by the config or via headers
+func (i *Item) nonRetryable() bool {
+	return i.Options.termOnNack || markedNonRetryable(i.Headers)
+}
+
+// ackAction returns the ack requested by the worker via the AckActionHeader
+func ackAction(headers map[string][]string) string {
+	if v, ok := headers[AckActionHeader]; ok && len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+func markedNonRetryable(headers map[string][]string) bool {
+	if v, ok := headers[NonRetryableHeader]; ok && len(v) > 0 {
+		return v[0] == "true" || v[0] == "1"
+	}
+
+	return false
+}
+
+// terminate copies the message to the DLQ (if configured) and tells the server to stop redelivering it
+func (i *Item) terminate() error {
+	if i.Options.dlqFn != nil {
+		err := i.Options.dlqFn(i)
+		if err != nil {
+			// do not lose the message, let it be redelivered
+			errNak := i.Options.nak()
+			if errNak != nil {
+				return fmt.Errorf("dlq error: %w\n nak error: %v", err, errNak)
+			}
+
+			return err
+		}
+	}
+
+	err := i.Options.term()
+	if err != nil {
+		return err
+	}
+
+	i.releaseClaim()
+	i.outcome(outcomeTerminated)
+
+	return nil
+}
+
+// releaseClaim removes the payload stored in the object store (if any)
+func (i *Item) releaseClaim() {
+	if i.Options.claim != "" && i.Options.claimDelete != nil {
+		i.Options.claimDelete(i.Options.claim)
+	}
+}
+
+// archive sends the processed job to the archive subject (if configured)
+func (i *Item) archive() {
+	if i.Options.archiveFn != nil {
+		i.Options.archiveFn(i)
+	}
+}
+
+// outcome reports the result of the job processing (if enabled)
+func (i *Item) outcome(status string) {
+	if i.Options.outcomeFn != nil {
+		i.Options.outcomeFn(i, status)
+	}
+}
+
+// finish marks the item as processed, it is safe to call it several times
+func (i *Item) finish() {
+	if i.Options.done != nil {
+		i.Options.done()
+	}
+}
@@ -0,0 +1,69 @@
+package natsjobs
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lagWatcher periodically checks the number of the pending (not yet delivered) messages of the pipeline consumers,
+// reports it via metrics and notifies when it exceeds the max_lag, so the autoscalers can add the workers
+func (c *Driver) lagWatcher(maxLag uint64, interval time.Duration) {
+	if maxLag == 0 {
+		return
+	}
+
+	pipe := (*c.pipeline.Load()).Name()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		exceeded := false
+		for {
+			select {
+			case <-ticker.C:
+				if !c.listening() {
+					continue
+				}
+
+				lag, err := c.consumerLag()
+				if err != nil {
+					c.log.Warn("failed to get the consumer info", zap.String("pipeline", pipe), zap.Error(err))
+					continue
+				}
+
+				c.metrics.lag(pipe, lag, maxLag)
+
+				switch {
+				case lag > maxLag && !exceeded:
+					exceeded = true
+					c.log.Warn("consumer lag exceeded the max_lag", zap.String("pipeline", pipe), zap.Uint64("pending", lag), zap.Uint64("max_lag", maxLag))
+					c.lifecycle(EventLagExceeded, "consumer lag exceeded the max_lag")
+				case lag <= maxLag && exceeded:
+					exceeded = false
+					c.log.Info("consumer lag is back below the max_lag", zap.String("pipeline", pipe), zap.Uint64("pending", lag))
+				}
+			case <-c.closeCh:
+				return
+			}
+		}
+	}()
+}
+
+// consumerLag returns the total number of the pending messages of the pipeline consumers
+func (c *Driver) consumerLag() (uint64, error) {
+	subs := c.subscriptions()
+
+	var lag uint64
+	for i := 0; i < len(subs); i++ {
+		ci, err := subs[i].ConsumerInfo()
+		if err != nil {
+			return 0, err
+		}
+
+		lag += ci.NumPending
+	}
+
+	return lag, nil
+}
@@ -0,0 +1,155 @@
+package natsjobs
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// lane binds the filter subject to the RR priority, every lane is consumed by its own consumer
+type lane struct {
+	// stream of the extra streams lanes, empty for the priority_map lanes
+	stream   string
+	subject  string
+	tokens   []string
+	priority int64
+}
+
+// newLanes creates the priority lanes, every lane subject should be covered by the pipeline subject
+func newLanes(subject string, priorities map[string]int64) ([]lane, error) {
+	lanes := make([]lane, 0, len(priorities))
+	for subj, p := range priorities {
+		if p <= 0 {
+			return nil, errors.Errorf("priority_map: priority for the subject %s should be greater than 0, got: %d", subj, p)
+		}
+
+		if !subjectCovered(subject, subj) {
+			return nil, errors.Errorf("priority_map: subject %s is not covered by the pipeline subject %s", subj, subject)
+		}
+
+		lanes = append(lanes, lane{
+			subject:  subj,
+			tokens:   strings.Split(subj, "."),
+			priority: p,
+		})
+	}
+
+	// overlapping lanes would get the same message twice
+	for i := 0; i < len(lanes); i++ {
+		for j := 0; j < len(lanes); j++ {
+			if i != j && subjectCovered(lanes[i].subject, lanes[j].subject) {
+				return nil, errors.Errorf("priority_map: subjects %s and %s overlap", lanes[i].subject, lanes[j].subject)
+			}
+		}
+	}
+
+	// stable order for the durable names and logs
+	sort.Slice(lanes, func(i, j int) bool {
+		return lanes[i].subject < lanes[j].subject
+	})
+
+	return lanes, nil
+}
+
+// parsePriorityMap converts the pipeline priority_map values (strings) to the priorities
+func parsePriorityMap(m map[string]string) (map[string]int64, error) {
+	res := make(map[string]int64, len(m))
+	for subj, v := range m {
+		p, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("priority_map: invalid priority for the subject %s: %s", subj, v)
+		}
+
+		res[subj] = p
+	}
+
+	return res, nil
+}
+
+// lanePriority returns the priority of the lane the subject belongs to
+func (c *Driver) lanePriority(subject string) (int64, bool) {
+	tokens := strings.Split(subject, ".")
+	for i := 0; i < len(c.lanes); i++ {
+		if subjectMatch(c.lanes[i].tokens, tokens) {
+			return c.lanes[i].priority, true
+		}
+	}
+
+	return 0, false
+}
+
+// laneDurable returns the durable name for the lane consumer, NATS doesn't allow dots and wildcards in the names
+func laneDurable(durable, subject string) string {
+	if durable == "" {
+		return ""
+	}
+
+	return durable + "_" + strings.NewReplacer(".", "_", "*", "any", ">", "all").Replace(subject)
+}
+
+// extraStream is the stream consumed by the pipeline in addition to the pipeline stream
+type extraStream struct {
+	Stream  string `mapstructure:"stream"`
+	Subject string `mapstructure:"subject"`
+	// Priority of the messages of the stream, default - the pipeline priority
+	Priority int64 `mapstructure:"priority"`
+}
+
+// newExtraStreams creates the lanes for the extra streams, the streams should exist, they are not managed by the pipeline
+func newExtraStreams(js streamAdmin, stream string, streams []*extraStream) ([]lane, error) {
+	lanes := make([]lane, 0, len(streams))
+	for i := 0; i < len(streams); i++ {
+		es := streams[i]
+		if es.Stream == "" || es.Subject == "" {
+			return nil, errors.Errorf("extra_streams #%d: stream and subject should be set", i)
+		}
+
+		// the pipeline stream subjects are split by the priority_map
+		if es.Stream == stream {
+			return nil, errors.Errorf("extra_streams: %s is the pipeline stream, use the priority_map to consume its subjects with different priorities", es.Stream)
+		}
+
+		if es.Priority < 0 {
+			return nil, errors.Errorf("extra_streams: priority for the stream %s should not be negative, got: %d", es.Stream, es.Priority)
+		}
+
+		for j := 0; j < len(lanes); j++ {
+			if lanes[j].stream == es.Stream && (subjectCovered(lanes[j].subject, es.Subject) || subjectCovered(es.Subject, lanes[j].subject)) {
+				return nil, errors.Errorf("extra_streams: subjects %s and %s of the stream %s overlap", lanes[j].subject, es.Subject, es.Stream)
+			}
+		}
+
+		_, err := js.StreamInfo(es.Stream)
+		if err != nil {
+			return nil, errors.Errorf("extra_streams: stream %s: %v", es.Stream, err)
+		}
+
+		lanes = append(lanes, lane{
+			stream:   es.Stream,
+			subject:  es.Subject,
+			tokens:   strings.Split(es.Subject, "."),
+			priority: es.Priority,
+		})
+	}
+
+	return lanes, nil
+}
+
+// sourcePriority returns the priority of the lane or the extra stream the message belongs to
+func (c *Driver) sourcePriority(stream, subject string) (int64, bool) {
+	if stream == c.stream || len(c.extraStreams) == 0 {
+		return c.lanePriority(subject)
+	}
+
+	tokens := strings.Split(subject, ".")
+	for i := 0; i < len(c.extraStreams); i++ {
+		es := c.extraStreams[i]
+		if es.stream == stream && es.priority > 0 && subjectMatch(es.tokens, tokens) {
+			return es.priority, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,98 @@
+package natsjobs
+
+import (
+	stderr "errors"
+)
+
+// ErrDriverStopped is returned by Run, Pause and Resume called after Stop, the connection is already closed
+var ErrDriverStopped = stderr.New("nats driver is stopped")
+
+// pipelineState is the state of the pipeline consumption. Run, Pause, Resume, Stop, Reload and the stream recreation
+// are serialized by the Driver.stateMu, it's the only lock guarding the subscriptions changes. The state itself
+// is atomic to be read without the lock (State, Health).
+type pipelineState uint32
+
+const (
+	// registered, but not started yet
+	stateIdle pipelineState = iota
+	stateRunning
+	statePaused
+	// terminal, the connection is closed
+	stateStopped
+)
+
+type transition uint8
+
+const (
+	transitionRun transition = iota
+	transitionPause
+	transitionResume
+	transitionStop
+)
+
+// transitions lists the allowed transitions, everything else is rejected by the caller
+var transitions = map[pipelineState]map[transition]pipelineState{
+	stateIdle: {
+		transitionRun:    stateRunning,
+		transitionResume: stateRunning,
+		transitionStop:   stateStopped,
+	},
+	stateRunning: {
+		transitionPause: statePaused,
+		transitionStop:  stateStopped,
+	},
+	// the paused pipeline is started again only by Resume, the listener might still be running (consumer_pause)
+	statePaused: {
+		transitionResume: stateRunning,
+		transitionStop:   stateStopped,
+	},
+	stateStopped: {},
+}
+
+func (s pipelineState) String() string {
+	switch s {
+	case stateIdle:
+		return "idle"
+	case stateRunning:
+		return "running"
+	case statePaused:
+		return "paused"
+	case stateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// next returns the state after the transition, false if the transition isn't allowed in the current state
+func (s pipelineState) next(t transition) (pipelineState, bool) {
+	n, ok := transitions[s][t]
+	return n, ok
+}
+
+func (c *Driver) loadState() pipelineState {
+	return pipelineState(c.state.Load())
+}
+
+func (c *Driver) storeState(s pipelineState) {
+	c.state.Store(uint32(s))
+}
+
+// Stopped reports whether the pipeline was stopped (destroyed), a stopped driver can't be started again
+func (c *Driver) Stopped() bool {
+	return c.loadState() == stateStopped
+}
+
+// listening reports whether the pipeline is consuming the messages
+func (c *Driver) listening() bool {
+	return c.loadState() == stateRunning
+}
+
+// subscriptions returns the current subscriptions, the slice is never modified in place
+func (c *Driver) subscriptions() []subscription {
+	if subs := c.subs.Load(); subs != nil {
+		return *subs
+	}
+
+	return nil
+}
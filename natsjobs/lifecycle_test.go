@@ -0,0 +1,72 @@
+package natsjobs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipelineStateTransitions(t *testing.T) {
+	tests := []struct {
+		from  pipelineState
+		tr    transition
+		to    pipelineState
+		allow bool
+	}{
+		{stateIdle, transitionRun, stateRunning, true},
+		{stateIdle, transitionPause, stateIdle, false},
+		{stateIdle, transitionResume, stateRunning, true},
+		{stateIdle, transitionStop, stateStopped, true},
+
+		{stateRunning, transitionRun, stateRunning, false},
+		{stateRunning, transitionPause, statePaused, true},
+		{stateRunning, transitionResume, stateRunning, false},
+		{stateRunning, transitionStop, stateStopped, true},
+
+		{statePaused, transitionRun, statePaused, false},
+		{statePaused, transitionPause, statePaused, false},
+		{statePaused, transitionResume, stateRunning, true},
+		{statePaused, transitionStop, stateStopped, true},
+
+		{stateStopped, transitionRun, stateStopped, false},
+		{stateStopped, transitionPause, stateStopped, false},
+		{stateStopped, transitionResume, stateStopped, false},
+		{stateStopped, transitionStop, stateStopped, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from.String(), func(t *testing.T) {
+			to, ok := tt.from.next(tt.tr)
+			assert.Equalf(t, tt.allow, ok, "%s -> transition %d", tt.from, tt.tr)
+			if tt.allow {
+				assert.Equal(t, tt.to, to)
+			}
+		})
+	}
+}
+
+// every state has an entry, the stopped one is terminal
+func TestPipelineStateTransitionsComplete(t *testing.T) {
+	for _, s := range []pipelineState{stateIdle, stateRunning, statePaused, stateStopped} {
+		_, ok := transitions[s]
+		assert.Truef(t, ok, "no transitions for %s", s)
+	}
+
+	assert.Empty(t, transitions[stateStopped])
+	assert.Equal(t, "unknown", pipelineState(42).String())
+}
+
+func TestDriverStopped(t *testing.T) {
+	c := &Driver{}
+	assert.False(t, c.Stopped())
+	assert.False(t, c.listening())
+
+	c.storeState(stateRunning)
+	assert.True(t, c.listening())
+	assert.False(t, c.Stopped())
+
+	c.storeState(stateStopped)
+	assert.True(t, c.Stopped())
+	assert.False(t, c.listening())
+	assert.Nil(t, c.subscriptions())
+}
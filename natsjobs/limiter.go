@@ -0,0 +1,91 @@
+package natsjobs
+
+import (
+	"sync"
+)
+
+// Limiter limits the number of in-flight messages and their total size across all NATS pipelines.
+// A message is in-flight from the moment it was received from NATS until it was acked/nacked/requeued.
+// nil Limiter means no limits.
+type Limiter struct {
+	mu       sync.Mutex
+	maxMsgs  int64
+	maxBytes int64
+	msgs     int64
+	bytes    int64
+	// closed and re-created on every release to wake up the waiters
+	waitCh chan struct{}
+}
+
+// NewLimiter creates a shared limiter, 0 means no limit for the particular dimension.
+// It returns nil if both limits are disabled.
+func NewLimiter(maxMsgs, maxBytes int64) *Limiter {
+	if maxMsgs <= 0 && maxBytes <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		maxMsgs:  maxMsgs,
+		maxBytes: maxBytes,
+		waitCh:   make(chan struct{}),
+	}
+}
+
+// acquire blocks until the message of the provided size fits into the quota.
+// It returns false if the stopCh was triggered while waiting.
+func (l *Limiter) acquire(size int64, stopCh <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+
+	for {
+		l.mu.Lock()
+		if l.fits(size) {
+			l.msgs++
+			l.bytes += size
+			l.mu.Unlock()
+			return true
+		}
+
+		ch := l.waitCh
+		l.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-stopCh:
+			return false
+		}
+	}
+}
+
+// release returns the message quota back
+func (l *Limiter) release(size int64) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	l.msgs--
+	l.bytes -= size
+	close(l.waitCh)
+	l.waitCh = make(chan struct{})
+	l.mu.Unlock()
+}
+
+// fits should be called under the lock. An empty limiter always accepts a message, even an oversized one,
+// otherwise such message would block the pipeline forever.
+func (l *Limiter) fits(size int64) bool {
+	if l.msgs == 0 {
+		return true
+	}
+
+	if l.maxMsgs > 0 && l.msgs+1 > l.maxMsgs {
+		return false
+	}
+
+	if l.maxBytes > 0 && l.bytes+size > l.maxBytes {
+		return false
+	}
+
+	return true
+}
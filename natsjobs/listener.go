@@ -1,98 +1,522 @@
 package natsjobs
 
 import (
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/nats-io/nats.go"
 	"go.uber.org/zap"
 )
 
+const (
+	statusHeader  string = "Status"
+	controlStatus string = "100"
+)
+
 // blocking
 func (c *Driver) listenerInit() error {
-	var err error
+	names := make(map[string]struct{}, len(c.lanes)+len(c.extraStreams)+1)
+	c.fetchStop = make(chan struct{})
+
+	// no lanes, a single consumer for the pipeline subject
+	if len(c.lanes) == 0 {
+		err := c.subscribe(c.stream, c.opts.Load().subject, c.durable, names)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < len(c.lanes); i++ {
+		err := c.subscribe(c.stream, c.lanes[i].subject, laneDurable(c.durable, c.lanes[i].subject), names)
+		if err != nil {
+			// don't leave the partially subscribed lanes
+			c.unsubscribe()
+			return err
+		}
+	}
+
+	for i := 0; i < len(c.extraStreams); i++ {
+		es := c.extraStreams[i]
+		err := c.subscribe(es.stream, es.subject, laneDurable(c.durable, es.stream+"."+es.subject), names)
+		if err != nil {
+			c.unsubscribe()
+			return err
+		}
+	}
+
+	c.consumerNames.Store(names)
+	return nil
+}
+
+// subscribe creates the consumer of the stream for the filter subject and saves its name
+func (c *Driver) subscribe(stream, subject, durable string, names map[string]struct{}) error {
+	err := c.checkConsumerDrift(stream, subject, durable)
+	if err != nil {
+		return err
+	}
 
 	opts := make([]nats.SubOpt, 0)
-	if c.deliverNew {
-		opts = append(opts, nats.DeliverNew())
+	opts = append(opts, nats.BindStream(stream))
+	if durable != "" {
+		opts = append(opts, nats.Durable(durable))
+	}
+
+	ro := c.opts.Load()
+	if ro.ackWait > 0 {
+		opts = append(opts, nats.AckWait(ro.ackWait))
+	}
+
+	if ro.maxDeliver != 0 {
+		opts = append(opts, nats.MaxDeliver(ro.maxDeliver))
+	}
+
+	// the ephemeral consumer re-created on resume continues from the ack floor of the removed one
+	if opt, ok := c.resumeOpt(stream, subject); ok {
+		opts = append(opts, opt)
+	} else {
+		if c.deliverNew {
+			opts = append(opts, nats.DeliverNew())
+		}
+
+		if c.deliverAll {
+			opts = append(opts, nats.DeliverAll())
+		}
+
+		if c.deliverLastPerSubject {
+			opts = append(opts, nats.DeliverLastPerSubject())
+		}
+	}
+
+	if c.headersOnly {
+		opts = append(opts, nats.HeadersOnly())
+	}
+
+	if c.consumerReplicas > 0 {
+		opts = append(opts, nats.ConsumerReplicas(c.consumerReplicas))
+	}
+
+	if c.inactiveThreshold > 0 {
+		opts = append(opts, nats.InactiveThreshold(c.inactiveThreshold))
+	}
+
+	if c.description != "" {
+		opts = append(opts, nats.Description(c.description))
+	}
+
+	if c.idleHeartbeat > 0 {
+		opts = append(opts, nats.IdleHeartbeat(c.idleHeartbeat))
+	}
+
+	if c.flowControl {
+		opts = append(opts, nats.EnableFlowControl())
+	}
+
+	// the server should hold the messages while the consumption is paused
+	if c.highWatermark > 0 {
+		opts = append(opts, nats.MaxAckPending(c.maxAckPending()))
 	}
 
-	opts = append(opts, nats.RateLimit(c.rateLimit))
-	opts = append(opts, nats.AckExplicit())
-	c.sub, err = c.js.ChanSubscribe(c.subject, c.msgCh, opts...)
+	opts = append(opts, c.ackOpt())
+
+	var sub *nats.Subscription
+	if c.pull {
+		sub, err = c.pullSubscribe(subject, durable, opts)
+	} else {
+		opts = append(opts, nats.RateLimit(c.rateLimit))
+		if c.deliverGroup != "" {
+			sub, err = c.js.ChanQueueSubscribe(subject, c.deliverGroup, c.msgCh, opts...)
+		} else {
+			sub, err = c.js.ChanSubscribe(subject, c.msgCh, opts...)
+		}
+	}
 	if err != nil {
 		return err
 	}
 
+	// copied, the readers keep using the previous slice
+	cur := c.subscriptions()
+	subs := make([]subscription, 0, len(cur)+1)
+	subs = append(append(subs, cur...), sub)
+	c.subs.Store(&subs)
+
+	// consumer name is needed to match the consumer deleted advisories
+	ci, err := sub.ConsumerInfo()
+	if err != nil {
+		c.log.Warn("failed to get the consumer info", zap.Error(err))
+		return nil
+	}
+
+	names[ci.Name] = struct{}{}
+	c.applySampleFreq(ci)
+
 	return nil
 }
 
-func (c *Driver) listenerStart() { //nolint:gocognit
+// drain drains all the pipeline subscriptions
+func (c *Driver) drain() {
+	c.stopFetch()
+	subs := c.subscriptions()
+	for i := 0; i < len(subs); i++ {
+		err := subs[i].Drain()
+		if err != nil {
+			c.log.Error("drain error", zap.Error(err))
+		}
+	}
+
+	c.subs.Store(nil)
+}
+
+// unsubscribe removes the interest of all the pipeline subscriptions
+func (c *Driver) unsubscribe() {
+	c.stopFetch()
+	subs := c.subscriptions()
+	for i := 0; i < len(subs); i++ {
+		_ = subs[i].Unsubscribe()
+	}
+
+	c.subs.Store(nil)
+}
+
+// listenerStart starts the listener goroutines, they exit when the stopCh is closed by the stopListener
+func (c *Driver) listenerStart() {
+	stopCh := make(chan struct{})
+	c.stopCh = stopCh
+	msgCh := c.msgCh
+	rt := c.realtimeStart(stopCh)
+
+	// ordered mode, messages are unpacked and inserted one by one
+	if c.workers <= 1 {
+		go func() {
+			for {
+				select {
+				case m := <-msgCh:
+					// not blocked by the messages waiting for the queue
+					if c.realtime(m.Subject) {
+						select {
+						case rt <- m:
+						case <-stopCh:
+							_ = m.Nak()
+							return
+						}
+						continue
+					}
+
+					if !c.handle(m, stopCh) {
+						return
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+
+		return
+	}
+
+	work := make(chan *nats.Msg)
+	done := make(chan struct{})
+
+	for i := 0; i < c.workers; i++ {
+		go func() {
+			for {
+				select {
+				case m := <-work:
+					if !c.handle(m, done) {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
 	go func() {
 		for {
 			select {
-			case m := <-c.msgCh:
-				// only JS messages
-				meta, err := m.Metadata()
-				if err != nil {
-					c.log.Info("can't get message metadata", zap.Error(err))
+			case m := <-msgCh:
+				if c.realtime(m.Subject) {
+					select {
+					case rt <- m:
+					case <-stopCh:
+						_ = m.Nak()
+						close(done)
+						return
+					}
 					continue
 				}
 
-				err = m.InProgress()
-				if err != nil {
-					c.log.Error("failed to send InProgress state", zap.Error(err))
-					continue
+				select {
+				case work <- m:
+				case <-stopCh:
+					// let the message be redelivered
+					_ = m.Nak()
+					close(done)
+					return
 				}
+			case <-stopCh:
+				close(done)
+				return
+			}
+		}
+	}()
+}
 
-				item := &Item{}
-				err = c.unpack(m.Data, item)
-				if err != nil {
-					c.log.Error("unmarshal nats payload", zap.Error(err))
-					continue
-				}
+// stopListener stops the listener goroutines, closing (instead of sending) never blocks even if they already exited
+func (c *Driver) stopListener() {
+	if c.stopCh != nil {
+		close(c.stopCh)
+		c.stopCh = nil
+	}
+}
 
-				// save the ack, nak and requeue functions
-				item.Options.ack = m.Ack
-				item.Options.nak = m.Nak
-				item.Options.requeueFn = c.requeue
-				// sequence needed for the requeue
-				item.Options.seq = meta.Sequence.Stream
-
-				// needed only if delete after ack is true
-				if c.deleteAfterAck {
-					item.Options.stream = c.stream
-					item.Options.sub = c.js
-					item.Options.deleteAfterAck = c.deleteAfterAck
-				}
+// handle unpacks the message and inserts it into the priority queue. It returns false if the listener is stopping.
+func (c *Driver) handle(m *nats.Msg, stopCh <-chan struct{}) bool { //nolint:gocognit
+	// heartbeats and flow control are handled by the client, just in case
+	if isControl(m) {
+		return true
+	}
 
-				if item.Priority() == 0 {
-					item.Options.Priority = c.priority
-				}
+	// only JS messages
+	meta, err := m.Metadata()
+	if err != nil {
+		c.log.Info("can't get message metadata", zap.Error(err))
+		return true
+	}
 
-				if item.Options.AutoAck {
-					c.log.Debug("auto_ack option enabled")
-					err = m.Ack()
-					if err != nil {
-						item = nil
-						c.log.Error("message acknowledge", zap.Error(err))
-						continue
-					}
+	c.metrics.consumed((*c.pipeline.Load()).Name(), time.Since(meta.Timestamp), len(m.Data))
 
-					if item.Options.deleteAfterAck {
-						err = c.js.DeleteMsg(c.stream, meta.Sequence.Stream)
-						if err != nil {
-							c.log.Error("delete message", zap.Error(err))
-							item = nil
-							continue
-						}
-					}
+	if expired(m) {
+		c.log.Debug("expired message dropped", zap.Uint64("sequence", meta.Sequence.Stream))
+		c.metrics.expired((*c.pipeline.Load()).Name())
+		err = m.Ack()
+		if err != nil {
+			c.log.Error("message acknowledge", zap.Error(err))
+		}
+		return true
+	}
 
-					item.Options.ack = nil
-					item.Options.nak = nil
-				}
+	// no acks, no round-trips
+	if !c.noAck() {
+		err = m.InProgress()
+		if err != nil {
+			c.log.Error("failed to send InProgress state", zap.Error(err))
+			return true
+		}
+	}
+
+	item := &Item{}
+	err = c.safeUnpack(m, meta, item)
+	if err != nil {
+		if isMalformed(err) {
+			c.rejectMalformed(m, err)
+			return true
+		}
+
+		c.log.Error("unmarshal nats payload", zap.Uint64("sequence", meta.Sequence.Stream), zap.Error(err))
+		return true
+	}
+
+	c.setAttempts(item, meta.NumDelivered)
+
+	ok, err := c.beforeDispatch(item)
+	if err != nil || !ok {
+		if err != nil {
+			c.log.Error("middleware error, message will be redelivered", zap.Error(err))
+			if !c.noAck() {
+				_ = m.Nak()
+			}
+
+			return true
+		}
+
+		c.log.Debug("message was filtered out by the middleware", zap.Uint64("sequence", meta.Sequence.Stream))
+		if !c.noAck() {
+			_ = m.Ack()
+		}
+
+		return true
+	}
+
+	// the message is considered acknowledged on delivery
+	if c.noAck() {
+		item.Options.AutoAck = true
+	}
+
+	// save the ack, nak and requeue functions
+	item.Options.ack = m.Ack
+	item.Options.nak = m.Nak
+	item.Options.nakWithDelay = m.NakWithDelay
+	item.Options.term = m.Term
+	item.Options.inProgress = m.InProgress
+	item.Options.requeueFn = c.requeue
+	item.Options.termOnNack = c.termOnNack
+	item.Options.ackStrategy = c.ackStrategy
+	item.Options.requeueRepublish = c.requeueRepublish
+	if c.dlqSubject != "" {
+		item.Options.dlqFn = c.dlq
+	}
+	if c.service != nil || c.resultSubject != "" || c.statusKV != nil {
+		item.Options.outcomeFn = c.jobOutcome
+	}
+	if c.archiver != nil {
+		item.Options.archiveFn = c.archive
+	}
+	item.Options.received = time.Now()
+	item.Options.delivered = meta.NumDelivered
+	// stream and sequence needed for the requeue
+	item.Options.stream = meta.Stream
+	item.Options.seq = meta.Sequence.Stream
+	c.redelivery(item, meta.NumDelivered)
+
+	// needed only if delete after ack is true
+	if c.deleteAfterAck {
+		item.Options.deleteMsg = c.deleteMsg
+		item.Options.deleteAfterAck = c.deleteAfterAck
+	}
+
+	realtime := c.realtime(m.Subject)
+	switch p, ok := c.sourcePriority(meta.Stream, m.Subject); {
+	case realtime:
+		item.Options.Priority = realtimePriority
+	case ok:
+		item.Options.Priority = p
+	case item.Priority() == 0:
+		item.Options.Priority = c.priority
+	}
+
+	if !realtime && (!c.jobsLimiter.wait(stopCh) || !c.waitQueue(m, stopCh)) {
+		// the listener is stopping, let the message be redelivered
+		_ = m.Nak()
+		return false
+	}
 
-				c.queue.Insert(item)
-			case <-c.stopCh:
+	size := int64(len(m.Data))
+	if !c.limiter.acquire(size, stopCh) {
+		// the listener is stopping, let the message be redelivered
+		_ = m.Nak()
+		return false
+	}
+
+	// before the auto ack, the message is redelivered to another instance
+	if !c.waitInsert(stopCh) {
+		c.limiter.release(size)
+		_ = m.Nak()
+
+		select {
+		case <-stopCh:
+			return false
+		default:
+			return true
+		}
+	}
+
+	c.inflight.Add(1)
+
+	var stopProgress func()
+	if !item.Options.AutoAck && c.progressInterval > 0 {
+		stopProgress = c.progress(m, item.ID())
+	}
+
+	once := &sync.Once{}
+	item.Options.done = func() {
+		once.Do(func() {
+			if stopProgress != nil {
+				stopProgress()
+			}
+
+			c.limiter.release(size)
+			c.inflight.Add(-1)
+			c.adaptive.done(time.Since(item.Options.received))
+		})
+	}
+
+	if item.Options.AutoAck {
+		c.log.Debug("auto_ack option enabled")
+		if !c.noAck() {
+			err = m.Ack()
+			if err != nil {
+				item.finish()
+				c.log.Error("message acknowledge", zap.Error(err))
+				return true
+			}
+		}
+
+		if item.Options.deleteAfterAck {
+			err = c.deleteMsg(meta.Stream, meta.Sequence.Stream)
+			if err != nil {
+				c.log.Error("delete message", zap.Error(err))
+				item.finish()
+				return true
+			}
+		}
+
+		item.Options.ack = nil
+		item.Options.nak = nil
+		item.Options.nakWithDelay = nil
+		item.Options.term = nil
+		item.Options.inProgress = nil
+	}
+
+	// before the insert, the worker might finish the job before the status is written otherwise
+	c.trackStatus(&JobStatus{ID: item.Ident, Job: item.Job, Status: jobActive, Attempts: meta.NumDelivered})
+	c.queue.Insert(item)
+	c.lastConsume.Store(time.Now().UnixNano())
+	c.replayProgress(meta)
+
+	return true
+}
+
+// progress periodically sends the InProgress ack to prevent redelivery of the long-running jobs.
+// The returned function stops the heartbeats and should be called only once.
+func (c *Driver) progress(m *nats.Msg, id string) func() {
+	stopCh := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(c.progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				err := m.InProgress()
+				if err != nil {
+					c.log.Warn("failed to send InProgress state", zap.String("id", id), zap.Error(err))
+					return
+				}
+			case <-stopCh:
 				return
 			}
 		}
 	}()
+
+	return func() {
+		close(stopCh)
+	}
+}
+
+// setAttempts sets the attempts header. NAK-based requeue preserves the server delivery counter, but the republished
+// message starts from scratch, so the number of attempts saved in the headers on republish is added to the counter.
+func (c *Driver) setAttempts(item *Item, delivered uint64) {
+	if item.Headers == nil {
+		item.Headers = make(map[string][]string, 1)
+	}
+
+	var prev uint64
+	if v, ok := item.Headers[AttemptsHeader]; ok && len(v) > 0 {
+		n, err := strconv.ParseUint(v[0], 10, 64)
+		if err != nil {
+			c.log.Debug("failed to parse the attempts header", zap.String("value", v[0]), zap.Error(err))
+		}
+
+		prev = n
+	}
+
+	item.Headers[AttemptsHeader] = []string{strconv.FormatUint(prev+delivered, 10)}
+}
+
+// isControl checks if the message is a JetStream idle heartbeat or flow control message
+func isControl(m *nats.Msg) bool {
+	return len(m.Data) == 0 && m.Header != nil && m.Header.Get(statusHeader) == controlStatus
 }
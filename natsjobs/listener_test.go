@@ -0,0 +1,164 @@
+package natsjobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func jobData(t testing.TB, id string, priority int64) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(&Item{
+		Job:     "job",
+		Ident:   id,
+		Payload: `{"k":"v"}`,
+		Options: &Options{Priority: priority, Pipeline: "test"},
+	})
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestListenerInitSingleConsumer(t *testing.T) {
+	js := &fakeJS{}
+	c, _ := newTestDriver(t, js)
+
+	require.NoError(t, c.listenerInit())
+	assert.Equal(t, []string{"jobs.default"}, js.subscribed)
+	assert.Len(t, c.subscriptions(), 1)
+}
+
+func TestListenerInitLanes(t *testing.T) {
+	js := &fakeJS{}
+	c, _ := newTestDriver(t, js)
+	c.lanes = []lane{{subject: "jobs.high", priority: 1}, {subject: "jobs.low", priority: 100}}
+
+	require.NoError(t, c.listenerInit())
+	assert.Equal(t, []string{"jobs.high", "jobs.low"}, js.subscribed)
+	assert.Len(t, c.subscriptions(), 2)
+}
+
+func TestListenerInitLaneFailureUnsubscribes(t *testing.T) {
+	js := &fakeJS{subscribeErr: map[string]error{"jobs.low": nats.ErrConsumerNotActive}}
+	c, _ := newTestDriver(t, js)
+	c.lanes = []lane{{subject: "jobs.high", priority: 1}, {subject: "jobs.low", priority: 100}}
+
+	require.ErrorIs(t, c.listenerInit(), nats.ErrConsumerNotActive)
+	// the subscribed lane isn't left behind
+	assert.Empty(t, c.subscriptions())
+}
+
+func TestDrainAndUnsubscribe(t *testing.T) {
+	c, _ := newTestDriver(t, &fakeJS{})
+
+	a, b := &fakeSub{}, &fakeSub{}
+	subs := []subscription{a, b}
+	c.subs.Store(&subs)
+	c.drain()
+	assert.True(t, a.drained)
+	assert.True(t, b.drained)
+	assert.Nil(t, c.subscriptions())
+
+	subs = []subscription{a}
+	c.subs.Store(&subs)
+	c.unsubscribe()
+	assert.True(t, a.unsubscribed)
+	assert.Nil(t, c.subscriptions())
+}
+
+func TestHandleInsertsItem(t *testing.T) {
+	c, q := newTestDriver(t, &fakeJS{})
+	c.ackPolicy = ackPolicyNone
+
+	assert.True(t, c.handle(jsMsg("jobs.default", jobData(t, "1", 5), 42), make(chan struct{})))
+	require.EqualValues(t, 1, q.Len())
+
+	item := q.ExtractMin().(*Item)
+	assert.Equal(t, "1", item.ID())
+	assert.EqualValues(t, 5, item.Priority())
+	// the message is acknowledged on delivery with the ack_policy: none
+	assert.True(t, item.Options.AutoAck)
+	assert.Equal(t, "jobs", item.Options.stream)
+	assert.EqualValues(t, 42, item.Options.seq)
+	assert.Equal(t, []string{"42"}, item.Headers[headerStreamSeq])
+	assert.EqualValues(t, 1, c.inflight.Load())
+
+	item.finish()
+	assert.EqualValues(t, 0, c.inflight.Load())
+}
+
+func TestHandleDefaultPriority(t *testing.T) {
+	c, q := newTestDriver(t, &fakeJS{})
+	c.ackPolicy = ackPolicyNone
+
+	assert.True(t, c.handle(jsMsg("jobs.default", jobData(t, "1", 0), 1), make(chan struct{})))
+	require.EqualValues(t, 1, q.Len())
+	assert.EqualValues(t, 10, q.ExtractMin().Priority())
+}
+
+func TestHandleSkipsMalformedAndForeign(t *testing.T) {
+	c, q := newTestDriver(t, &fakeJS{})
+	c.ackPolicy = ackPolicyNone
+	c.onDecodeError = onDecodeErrorTerm
+
+	// not a JetStream message, no metadata
+	assert.True(t, c.handle(&nats.Msg{Subject: "jobs.default", Data: jobData(t, "1", 1)}, make(chan struct{})))
+	// malformed payload
+	assert.True(t, c.handle(jsMsg("jobs.default", []byte("{"), 1), make(chan struct{})))
+	// no job options
+	assert.True(t, c.handle(jsMsg("jobs.default", []byte("{}"), 2), make(chan struct{})))
+
+	assert.EqualValues(t, 0, q.Len())
+	assert.EqualValues(t, 0, c.inflight.Load())
+}
+
+func TestListenerStartOrdered(t *testing.T) {
+	c, q := newTestDriver(t, &fakeJS{})
+	c.ackPolicy = ackPolicyNone
+
+	c.listenerStart()
+	defer c.stopListener()
+
+	for i := 0; i < 5; i++ {
+		c.msgCh <- jsMsg("jobs.default", jobData(t, string(rune('a'+i)), 1), uint64(i+1))
+	}
+
+	require.Eventually(t, func() bool { return q.Len() == 5 }, time.Second, time.Millisecond*5)
+
+	// ordered mode keeps the delivery order
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, string(rune('a'+i)), q.ExtractMin().ID())
+	}
+}
+
+func TestListenerStartRealtime(t *testing.T) {
+	c, q := newTestDriver(t, &fakeJS{})
+	c.ackPolicy = ackPolicyNone
+	c.workers = 4
+	c.realtimeSubjects = newRealtime([]string{"jobs.urgent.>"})
+
+	c.listenerStart()
+	defer c.stopListener()
+
+	for i := 0; i < realtimeWorkers*4; i++ {
+		c.msgCh <- jsMsg("jobs.urgent.a", jobData(t, "rt", 50), uint64(i+1))
+	}
+	c.msgCh <- jsMsg("jobs.default", jobData(t, "regular", 50), 1000)
+
+	require.Eventually(t, func() bool { return q.Len() == uint64(realtimeWorkers*4+1) }, time.Second, time.Millisecond*5)
+
+	realtime := 0
+	for q.Len() > 0 {
+		item := q.ExtractMin()
+		if item.ID() == "rt" {
+			assert.Equal(t, realtimePriority, item.Priority())
+			realtime++
+		}
+	}
+	assert.Equal(t, realtimeWorkers*4, realtime)
+}
@@ -0,0 +1,135 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// UnpackErrorHeader is set on the malformed messages copied to the dead letter subject, the value is the unpack error
+const UnpackErrorHeader string = "rr_nats_unpack_error"
+
+const (
+	// the malformed message is terminated
+	onDecodeErrorTerm string = "term"
+	// the malformed message is copied to the dead letter subject and terminated
+	onDecodeErrorDLQ string = "dlq"
+	// the malformed message is NAKed and redelivered up to the max_deliver
+	onDecodeErrorRequeue string = "requeue"
+
+	// max logged payload sample of the malformed message
+	payloadSampleSize int = 256
+)
+
+func validateOnDecodeError(mode, dlqSubject string) error {
+	switch mode {
+	case onDecodeErrorTerm, onDecodeErrorRequeue:
+		return nil
+	case onDecodeErrorDLQ:
+		if dlqSubject == "" {
+			return errors.Str("on_decode_error: dlq requires the dlq_subject")
+		}
+
+		return nil
+	default:
+		return errors.Errorf("unknown on_decode_error: %s, available: term, dlq, requeue", mode)
+	}
+}
+
+// onDecodeErrorDefault copies the malformed messages to the dead letter subject if it's set
+func onDecodeErrorDefault(dlqSubject string) string {
+	if dlqSubject != "" {
+		return onDecodeErrorDLQ
+	}
+
+	return onDecodeErrorTerm
+}
+
+// payloadSample returns the beginning of the payload for the logs
+func payloadSample(data []byte) string {
+	if len(data) > payloadSampleSize {
+		return strconv.Quote(string(data[:payloadSampleSize])) + "..."
+	}
+
+	return strconv.Quote(string(data))
+}
+
+// malformedError is the error of the message payload which can't be decoded, the redelivery doesn't help
+type malformedError struct {
+	err error
+}
+
+func (e *malformedError) Error() string {
+	return "malformed message: " + e.err.Error()
+}
+
+func (e *malformedError) Unwrap() error {
+	return e.err
+}
+
+func isMalformed(err error) bool {
+	var me *malformedError
+	return stderr.As(err, &me)
+}
+
+// safeUnpack unpacks the message, the decoders panics on the corrupted payloads are returned as errors,
+// so they don't crash the listener
+func (c *Driver) safeUnpack(m *nats.Msg, meta *nats.MsgMetadata, item *Item) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &malformedError{err: errors.Errorf("unpack panic: %v", r)}
+		}
+	}()
+
+	return c.unpack(m, meta, item)
+}
+
+// rejectMalformed handles the message which can't be unpacked according to the on_decode_error,
+// so it's not redelivered forever
+func (c *Driver) rejectMalformed(m *nats.Msg, cause error) {
+	c.metrics.decodeError((*c.pipeline.Load()).Name())
+	c.log.Warn("malformed message",
+		zap.String("subject", m.Subject),
+		zap.String("on_decode_error", c.onDecodeError),
+		zap.Int("size", len(m.Data)),
+		zap.String("sample", payloadSample(m.Data)),
+		zap.Error(cause),
+	)
+
+	if c.noAck() {
+		return
+	}
+
+	switch c.onDecodeError {
+	case onDecodeErrorRequeue:
+		err := m.Nak()
+		if err != nil {
+			c.log.Error("failed to nak the malformed message", zap.Error(err))
+		}
+
+		return
+	case onDecodeErrorDLQ:
+		hdr := make(nats.Header, len(m.Header)+1)
+		for k, v := range m.Header {
+			hdr[k] = v
+		}
+		hdr.Set(UnpackErrorHeader, cause.Error())
+
+		_, err := c.publishMsg(c.dlqSubject, m.Data, hdr)
+		if err != nil {
+			c.log.Error("failed to move the malformed message to the dead letter subject, message will be redelivered", zap.Error(err))
+			_ = m.Nak()
+			return
+		}
+
+		c.lifecycle(EventDLQ, "malformed message moved to the dead letter subject "+c.dlqSubject)
+	}
+
+	err := m.Term()
+	if err != nil {
+		c.log.Error("failed to terminate the malformed message", zap.Error(err))
+	}
+}
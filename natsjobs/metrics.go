@@ -0,0 +1,225 @@
+package natsjobs
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace string = "rr"
+	metricsSubsystem string = "nats"
+
+	// labels
+	labelPipeline   string = "pipeline"
+	labelResource   string = "resource"
+	labelDeliveries string = "deliveries"
+)
+
+// Metrics contains the driver metrics shared by all NATS pipelines and exported via the RR metrics plugin.
+// nil Metrics is a no-op.
+type Metrics struct {
+	accountUsage      *prometheus.GaugeVec
+	accountLimit      *prometheus.GaugeVec
+	expiredTotal      *prometheus.CounterVec
+	slowConsumerTotal *prometheus.CounterVec
+	redeliveriesTotal *prometheus.CounterVec
+	insertTimeouts    *prometheus.CounterVec
+	decodeErrors      *prometheus.CounterVec
+	consumerLag       *prometheus.GaugeVec
+	lagExceeded       *prometheus.GaugeVec
+	ackLatency        *prometheus.HistogramVec
+	latency           *prometheus.HistogramVec
+	payloadSize       *prometheus.HistogramVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		accountUsage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "account_usage",
+			Help:      "JetStream account resources usage (memory, storage, streams, consumers).",
+		}, []string{labelPipeline, labelResource}),
+		accountLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "account_limit",
+			Help:      "JetStream account resources limits (memory, storage, streams, consumers), -1 - unlimited.",
+		}, []string{labelPipeline, labelResource}),
+		expiredTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "expired_total",
+			Help:      "Total number of the expired jobs dropped on consume.",
+		}, []string{labelPipeline}),
+		slowConsumerTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "slow_consumer_total",
+			Help:      "Total number of the slow consumer errors (dropped messages) of the push subscription.",
+		}, []string{labelPipeline}),
+		redeliveriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "redeliveries_total",
+			Help:      "Total number of the redelivered messages (delivered more than once).",
+		}, []string{labelPipeline}),
+		insertTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "insert_timeouts_total",
+			Help:      "Total number of the messages NAKed because the priority queue was full for the insert_timeout.",
+		}, []string{labelPipeline}),
+		decodeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "decode_errors_total",
+			Help:      "Total number of the consumed messages which can't be decoded (malformed payloads).",
+		}, []string{labelPipeline}),
+		consumerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "consumer_lag",
+			Help:      "Number of the messages pending delivery to the pipeline consumers (reported with max_lag).",
+		}, []string{labelPipeline}),
+		lagExceeded: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "consumer_lag_exceeded",
+			Help:      "1 if the consumer lag exceeds the max_lag, 0 otherwise.",
+		}, []string{labelPipeline}),
+		ackLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "ack_latency_seconds",
+			Help:      "Server-side time from the delivery to the ack of the sampled messages (sample_freq), deliveries: 1 or redelivered.",
+			// 5ms - ~1.5h
+			Buckets: prometheus.ExponentialBuckets(0.005, 4, 12),
+		}, []string{labelPipeline, labelDeliveries}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "consume_latency_seconds",
+			Help:      "Time from the publish (JetStream timestamp) to the consume of the job, including the redeliveries.",
+			// 5ms - ~1.5h
+			Buckets: prometheus.ExponentialBuckets(0.005, 4, 12),
+		}, []string{labelPipeline}),
+		payloadSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "payload_size_bytes",
+			Help:      "Size of the consumed messages in bytes.",
+			// 64B - 16MB
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		}, []string{labelPipeline}),
+	}
+}
+
+// Collectors returns the metrics collectors to be registered by the metrics plugin
+func (m *Metrics) Collectors() []prometheus.Collector {
+	if m == nil {
+		return nil
+	}
+
+	return []prometheus.Collector{
+		m.accountUsage,
+		m.accountLimit,
+		m.expiredTotal,
+		m.slowConsumerTotal,
+		m.redeliveriesTotal,
+		m.insertTimeouts,
+		m.decodeErrors,
+		m.consumerLag,
+		m.lagExceeded,
+		m.ackLatency,
+		m.latency,
+		m.payloadSize,
+	}
+}
+
+func (m *Metrics) account(pipeline, resource string, usage, limit float64) {
+	if m == nil {
+		return
+	}
+
+	m.accountUsage.WithLabelValues(pipeline, resource).Set(usage)
+	m.accountLimit.WithLabelValues(pipeline, resource).Set(limit)
+}
+
+func (m *Metrics) expired(pipeline string) {
+	if m == nil {
+		return
+	}
+
+	m.expiredTotal.WithLabelValues(pipeline).Inc()
+}
+
+func (m *Metrics) slowConsumer(pipeline string) {
+	if m == nil {
+		return
+	}
+
+	m.slowConsumerTotal.WithLabelValues(pipeline).Inc()
+}
+
+func (m *Metrics) consumed(pipeline string, latency time.Duration, size int) {
+	if m == nil {
+		return
+	}
+
+	m.latency.WithLabelValues(pipeline).Observe(latency.Seconds())
+	m.payloadSize.WithLabelValues(pipeline).Observe(float64(size))
+}
+
+func (m *Metrics) insertTimeout(pipeline string) {
+	if m == nil {
+		return
+	}
+
+	m.insertTimeouts.WithLabelValues(pipeline).Inc()
+}
+
+func (m *Metrics) decodeError(pipeline string) {
+	if m == nil {
+		return
+	}
+
+	m.decodeErrors.WithLabelValues(pipeline).Inc()
+}
+
+func (m *Metrics) lag(pipeline string, lag, maxLag uint64) {
+	if m == nil {
+		return
+	}
+
+	m.consumerLag.WithLabelValues(pipeline).Set(float64(lag))
+	if lag > maxLag {
+		m.lagExceeded.WithLabelValues(pipeline).Set(1)
+		return
+	}
+
+	m.lagExceeded.WithLabelValues(pipeline).Set(0)
+}
+
+func (m *Metrics) redelivered(pipeline string) {
+	if m == nil {
+		return
+	}
+
+	m.redeliveriesTotal.WithLabelValues(pipeline).Inc()
+}
+
+func (m *Metrics) ackSample(pipeline string, ackTime time.Duration, deliveries uint64) {
+	if m == nil {
+		return
+	}
+
+	// bounded label cardinality
+	d := "1"
+	if deliveries > 1 {
+		d = "redelivered"
+	}
+
+	m.ackLatency.WithLabelValues(pipeline, d).Observe(ackTime.Seconds())
+}
@@ -0,0 +1,71 @@
+package natsjobs
+
+import (
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"go.uber.org/zap"
+)
+
+// serviceStats is the pipeline part of the micro service STATS response
+type serviceStats struct {
+	Pipeline  string `json:"pipeline"`
+	Processed uint64 `json:"processed"`
+	Failed    uint64 `json:"failed"`
+	Inflight  int64  `json:"inflight"`
+}
+
+// addService registers the pipeline as the NATS micro service, so the consumers are discoverable with nats micro ls.
+// The health endpoint responds with the pipeline Health.
+func (c *Driver) addService(nc *nats.Conn, name, version string) error {
+	if name == "" {
+		return nil
+	}
+
+	pipe := (*c.pipeline.Load()).Name()
+	svc, err := micro.AddService(nc, micro.Config{
+		Name:        name,
+		Version:     version,
+		Description: "RoadRunner jobs pipeline " + pipe,
+		Endpoint: &micro.EndpointConfig{
+			Subject: "rr.jobs." + durableToken(pipe) + ".health",
+			Handler: micro.HandlerFunc(func(req micro.Request) {
+				data, errM := json.Marshal(c.Health())
+				if errM != nil {
+					_ = req.Error("500", errM.Error(), nil)
+					return
+				}
+
+				_ = req.Respond(data)
+			}),
+		},
+		StatsHandler: func(*micro.Endpoint) any {
+			return &serviceStats{
+				Pipeline:  pipe,
+				Processed: c.processed.Load(),
+				Failed:    c.failed.Load(),
+				Inflight:  c.inflight.Load(),
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	c.service = svc
+	c.log.Debug("pipeline registered as the micro service", zap.String("pipeline", pipe), zap.String("service", name), zap.String("id", svc.Info().ID))
+
+	return nil
+}
+
+// stopService deregisters the micro service
+func (c *Driver) stopService() {
+	if c.service == nil {
+		return
+	}
+
+	err := c.service.Stop()
+	if err != nil {
+		c.log.Warn("failed to stop the micro service", zap.Error(err))
+	}
+}
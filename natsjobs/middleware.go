@@ -0,0 +1,71 @@
+package natsjobs
+
+import (
+	"github.com/roadrunner-server/errors"
+)
+
+// Middleware is provided by other plugins to mutate, enrich or filter the jobs (e.g. tenant injection, PII scrubbing).
+// Pipelines select the middlewares by name via the middleware option, they are called in the listed order.
+type Middleware interface {
+	// Name returns the middleware name used in the middleware option
+	Name() string
+	// BeforePublish is called before the pushed job is encoded and published. The item might be modified,
+	// false skips the publish (the push succeeds), the error fails the push.
+	BeforePublish(pipeline string, item *Item) (bool, error)
+	// BeforeDispatch is called before the consumed job is inserted into the priority queue. The item might be
+	// modified, false acknowledges and drops the message, the error NAKs it to be redelivered.
+	BeforeDispatch(pipeline string, item *Item) (bool, error)
+}
+
+// middlewares resolves the pipeline middlewares by their names
+func middlewares(names []string, available map[string]Middleware) ([]Middleware, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	mws := make([]Middleware, 0, len(names))
+	for _, name := range names {
+		mw, ok := available[name]
+		if !ok {
+			return nil, errors.Errorf("unknown middleware: %s, middlewares are provided by the plugins", name)
+		}
+
+		mws = append(mws, mw)
+	}
+
+	return mws, nil
+}
+
+// beforePublish runs the pipeline middlewares for the pushed item, false means the item was filtered out
+func (c *Driver) beforePublish(item *Item) (bool, error) {
+	pipe := (*c.pipeline.Load()).Name()
+	for _, mw := range c.middlewares {
+		ok, err := mw.BeforePublish(pipe, item)
+		if err != nil {
+			return false, errors.Errorf("middleware %s: %v", mw.Name(), err)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// beforeDispatch runs the pipeline middlewares for the consumed item, false means the item was filtered out
+func (c *Driver) beforeDispatch(item *Item) (bool, error) {
+	pipe := (*c.pipeline.Load()).Name()
+	for _, mw := range c.middlewares {
+		ok, err := mw.BeforeDispatch(pipe, item)
+		if err != nil {
+			return false, errors.Errorf("middleware %s: %v", mw.Name(), err)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
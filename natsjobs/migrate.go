@@ -0,0 +1,221 @@
+package natsjobs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/stan.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMigrationIdle time.Duration = time.Second * 5
+	migrationMaxInflight int           = 256
+)
+
+// MigrationRequest describes the migration of the legacy NATS Streaming (STAN) channel into the pipeline stream
+type MigrationRequest struct {
+	// Pipeline to migrate the messages into
+	Pipeline string `json:"pipeline"`
+	// ClusterID is the NATS Streaming cluster ID
+	ClusterID string `json:"cluster_id"`
+	// Channel is the NATS Streaming channel to migrate
+	Channel string `json:"channel"`
+	// Addr is the NATS Streaming server URL, the pipeline connection is used if empty
+	Addr string `json:"addr"`
+	// StartSequence is the channel sequence to start from, 0 - from the first available message
+	StartSequence uint64 `json:"start_sequence"`
+	// IdleTimeout in seconds, the migration is finished when there were no messages within it, default 5s
+	IdleTimeout int64 `json:"idle_timeout"`
+}
+
+// MigrationStatus reports the migration progress
+type MigrationStatus struct {
+	Pipeline string `json:"pipeline"`
+	Channel  string `json:"channel"`
+	// Migrated is the number of republished messages
+	Migrated uint64 `json:"migrated"`
+	// LastSequence is the last migrated channel sequence
+	LastSequence uint64    `json:"last_sequence"`
+	Running      bool      `json:"running"`
+	Error        string    `json:"error"`
+	Started      time.Time `json:"started"`
+	Finished     time.Time `json:"finished"`
+}
+
+type migration struct {
+	mu     sync.Mutex
+	status *MigrationStatus
+	// connects the streaming client over the pipeline connection
+	dial stanDialer
+}
+
+// stanDialer connects the NATS Streaming client, the client needs the NATS connection itself
+type stanDialer func(clusterID, clientID string) (stan.Conn, error)
+
+func newStanDialer(nc *nats.Conn) stanDialer {
+	return func(clusterID, clientID string) (stan.Conn, error) {
+		return stan.Connect(clusterID, clientID, stan.NatsConn(nc))
+	}
+}
+
+// MigrateSTAN starts the migration of the NATS Streaming channel into the pipeline stream. Messages are republished
+// with the Nats-Msg-Id derived from the channel sequence, so the migration might be safely restarted.
+func (c *Driver) MigrateSTAN(req *MigrationRequest) error {
+	const op = errors.Op("nats_migrate_stan")
+
+	if req.ClusterID == "" || req.Channel == "" {
+		return errors.E(op, errors.Str("cluster_id and channel should be provided"))
+	}
+
+	c.migration.mu.Lock()
+	defer c.migration.mu.Unlock()
+
+	if c.migration.status != nil && c.migration.status.Running {
+		return errors.E(op, errors.Errorf("migration of the channel %s is already running", c.migration.status.Channel))
+	}
+
+	dial := c.migration.dial
+	var ownConn *nats.Conn
+	if req.Addr != "" {
+		var err error
+		ownConn, err = nats.Connect(req.Addr)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		dial = newStanDialer(ownConn)
+	}
+
+	if dial == nil {
+		return errors.E(op, errors.Str("migration requires the NATS connection, set the addr of the migration request"))
+	}
+
+	sc, err := dial(req.ClusterID, "rr-migrate-"+uuid.NewString())
+	if err != nil {
+		if ownConn != nil {
+			ownConn.Close()
+		}
+
+		return errors.E(op, err)
+	}
+
+	c.migration.status = &MigrationStatus{
+		Pipeline: req.Pipeline,
+		Channel:  req.Channel,
+		Running:  true,
+		Started:  time.Now(),
+	}
+
+	go c.migrate(sc, ownConn, req)
+
+	return nil
+}
+
+// MigrationStatus returns the copy of the last migration status, nil if there were no migrations
+func (c *Driver) MigrationStatus() *MigrationStatus {
+	c.migration.mu.Lock()
+	defer c.migration.mu.Unlock()
+
+	if c.migration.status == nil {
+		return nil
+	}
+
+	st := *c.migration.status
+	return &st
+}
+
+func (c *Driver) migrate(sc stan.Conn, ownConn *nats.Conn, req *MigrationRequest) {
+	idleTimeout := defaultMigrationIdle
+	if req.IdleTimeout > 0 {
+		idleTimeout = time.Second * time.Duration(req.IdleTimeout)
+	}
+
+	progressCh := make(chan struct{}, 1)
+	errCh := make(chan error, 1)
+
+	opts := []stan.SubscriptionOption{
+		stan.SetManualAckMode(),
+		stan.MaxInflight(migrationMaxInflight),
+	}
+
+	if req.StartSequence > 0 {
+		opts = append(opts, stan.StartAtSequence(req.StartSequence))
+	} else {
+		opts = append(opts, stan.DeliverAllAvailable())
+	}
+
+	// callbacks are called sequentially, so the order is preserved
+	sub, err := sc.Subscribe(req.Channel, func(m *stan.Msg) {
+		_, errP := c.js.Publish(c.opts.Load().subject, m.Data, nats.MsgId(fmt.Sprintf("stan-%s-%d", req.Channel, m.Sequence)))
+		if errP != nil {
+			select {
+			case errCh <- errP:
+			default:
+			}
+			return
+		}
+
+		_ = m.Ack()
+
+		c.migration.mu.Lock()
+		c.migration.status.Migrated++
+		c.migration.status.LastSequence = m.Sequence
+		c.migration.mu.Unlock()
+
+		select {
+		case progressCh <- struct{}{}:
+		default:
+		}
+	}, opts...)
+
+	if err == nil {
+		idle := time.NewTimer(idleTimeout)
+
+	loop:
+		for {
+			select {
+			case <-progressCh:
+				if !idle.Stop() {
+					<-idle.C
+				}
+				idle.Reset(idleTimeout)
+			case <-idle.C:
+				break loop
+			case err = <-errCh:
+				break loop
+			case <-c.closeCh:
+				err = errors.Str("pipeline was stopped")
+				break loop
+			}
+		}
+
+		idle.Stop()
+		_ = sub.Close()
+	}
+
+	_ = sc.Close()
+	if ownConn != nil {
+		ownConn.Close()
+	}
+
+	c.migration.mu.Lock()
+	st := c.migration.status
+	st.Running = false
+	st.Finished = time.Now()
+	if err != nil {
+		st.Error = err.Error()
+	}
+	c.migration.mu.Unlock()
+
+	if err != nil {
+		c.log.Error("NATS Streaming migration failed", zap.String("channel", st.Channel), zap.Uint64("migrated", st.Migrated), zap.Error(err))
+		return
+	}
+
+	c.log.Info("NATS Streaming migration finished", zap.String("channel", st.Channel), zap.Uint64("migrated", st.Migrated), zap.Uint64("last_sequence", st.LastSequence))
+}
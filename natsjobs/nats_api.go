@@ -0,0 +1,83 @@
+package natsjobs
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsConn is the subset of the NATS connection used by the driver, so the driver logic can be tested with a fake
+type natsConn interface {
+	Request(subj string, data []byte, timeout time.Duration) (*nats.Msg, error)
+	PublishRequest(subj, reply string, data []byte) error
+	Subscribe(subj string, cb nats.MsgHandler) (*nats.Subscription, error)
+	SubscribeSync(subj string) (*nats.Subscription, error)
+	MaxPayload() int64
+	ConnectedServerVersion() string
+	IsConnected() bool
+	IsClosed() bool
+	Drain() error
+	Close()
+}
+
+// publisher publishes the jobs, the archived and the outcome messages
+type publisher interface {
+	Publish(subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error)
+	PublishMsg(m *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error)
+	PublishAsync(subj string, data []byte, opts ...nats.PubOpt) (nats.PubAckFuture, error)
+	PublishMsgAsync(m *nats.Msg, opts ...nats.PubOpt) (nats.PubAckFuture, error)
+	PublishAsyncComplete() <-chan struct{}
+}
+
+// subscriber creates the push and pull consumers of the pipeline
+type subscriber interface {
+	ChanSubscribe(subj string, ch chan *nats.Msg, opts ...nats.SubOpt) (*nats.Subscription, error)
+	ChanQueueSubscribe(subj, queue string, ch chan *nats.Msg, opts ...nats.SubOpt) (*nats.Subscription, error)
+	PullSubscribe(subj, durable string, opts ...nats.SubOpt) (*nats.Subscription, error)
+}
+
+// streamAdmin manages the streams, their messages and the consumers
+type streamAdmin interface {
+	AccountInfo(opts ...nats.JSOpt) (*nats.AccountInfo, error)
+	AddStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	UpdateStream(cfg *nats.StreamConfig, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	StreamInfo(stream string, opts ...nats.JSOpt) (*nats.StreamInfo, error)
+	StreamNameBySubject(subj string, opts ...nats.JSOpt) (string, error)
+	DeleteStream(name string, opts ...nats.JSOpt) error
+	PurgeStream(name string, opts ...nats.JSOpt) error
+	DeleteMsg(name string, seq uint64, opts ...nats.JSOpt) error
+	SecureDeleteMsg(name string, seq uint64, opts ...nats.JSOpt) error
+	ConsumerInfo(stream, name string, opts ...nats.JSOpt) (*nats.ConsumerInfo, error)
+	UpdateConsumer(stream string, cfg *nats.ConsumerConfig, opts ...nats.JSOpt) (*nats.ConsumerInfo, error)
+}
+
+// bucketAdmin binds to (or creates) the KV and object store buckets
+type bucketAdmin interface {
+	KeyValue(bucket string) (nats.KeyValue, error)
+	CreateKeyValue(cfg *nats.KeyValueConfig) (nats.KeyValue, error)
+	ObjectStore(bucket string) (nats.ObjectStore, error)
+	CreateObjectStore(cfg *nats.ObjectStoreConfig) (nats.ObjectStore, error)
+}
+
+// jetStream is the JetStream API used by the driver, the helpers accept only the part they need
+type jetStream interface {
+	publisher
+	subscriber
+	streamAdmin
+	bucketAdmin
+}
+
+// subscription is the consumer subscription of the pipeline, the driver only inspects and closes it
+type subscription interface {
+	ConsumerInfo() (*nats.ConsumerInfo, error)
+	Pending() (int, int, error)
+	IsValid() bool
+	Drain() error
+	Unsubscribe() error
+}
+
+var (
+	_ natsConn     = (*nats.Conn)(nil)
+	_ jetStream    = (nats.JetStreamContext)(nil)
+	_ subscription = (*nats.Subscription)(nil)
+)
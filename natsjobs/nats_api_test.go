@@ -0,0 +1,254 @@
+package natsjobs
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
+	pq "github.com/roadrunner-server/api/v4/plugins/v1/priority_queue"
+	"go.uber.org/zap"
+)
+
+// fakeJS records the JetStream calls, the methods not used by the test panic (nil embedded interface)
+type fakeJS struct {
+	jetStream
+
+	mu        sync.Mutex
+	published []*nats.Msg
+	deleted   []uint64
+	// subscribed filter subjects
+	subscribed []string
+	// subscribe errors by the filter subject
+	subscribeErr map[string]error
+	publishErr   error
+	// updated consumers configs
+	updated   []*nats.ConsumerConfig
+	updateErr error
+}
+
+func (f *fakeJS) Publish(subj string, data []byte, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	return f.PublishMsg(&nats.Msg{Subject: subj, Data: data})
+}
+
+func (f *fakeJS) PublishMsg(m *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.publishErr != nil {
+		return nil, f.publishErr
+	}
+
+	f.published = append(f.published, m)
+	return &nats.PubAck{Stream: "jobs", Sequence: uint64(len(f.published))}, nil
+}
+
+func (f *fakeJS) DeleteMsg(_ string, seq uint64, _ ...nats.JSOpt) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.deleted = append(f.deleted, seq)
+	return nil
+}
+
+func (f *fakeJS) ConsumerInfo(_, _ string, _ ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	return nil, nats.ErrConsumerNotFound
+}
+
+func (f *fakeJS) UpdateConsumer(_ string, cfg *nats.ConsumerConfig, _ ...nats.JSOpt) (*nats.ConsumerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+
+	f.updated = append(f.updated, cfg)
+	return &nats.ConsumerInfo{Config: *cfg}, nil
+}
+
+func (f *fakeJS) ChanSubscribe(subj string, _ chan *nats.Msg, _ ...nats.SubOpt) (*nats.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.subscribeErr[subj]; err != nil {
+		return nil, err
+	}
+
+	f.subscribed = append(f.subscribed, subj)
+	return &nats.Subscription{Subject: subj}, nil
+}
+
+func (f *fakeJS) ChanQueueSubscribe(subj, _ string, ch chan *nats.Msg, opts ...nats.SubOpt) (*nats.Subscription, error) {
+	return f.ChanSubscribe(subj, ch, opts...)
+}
+
+// fakeConn is the connected connection without the max_payload limit
+type fakeConn struct {
+	natsConn
+}
+
+func (fakeConn) MaxPayload() int64 { return 0 }
+func (fakeConn) IsConnected() bool { return true }
+func (fakeConn) IsClosed() bool    { return false }
+
+// fakeSub is the subscription with the predefined consumer info
+type fakeSub struct {
+	mu           sync.Mutex
+	info         *nats.ConsumerInfo
+	err          error
+	pending      int
+	drained      bool
+	unsubscribed bool
+	calls        int
+}
+
+func (s *fakeSub) ConsumerInfo() (*nats.ConsumerInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	return s.info, s.err
+}
+
+func (s *fakeSub) Pending() (int, int, error) {
+	return s.pending, 0, nil
+}
+
+func (s *fakeSub) IsValid() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.drained && !s.unsubscribed
+}
+
+func (s *fakeSub) Drain() error {
+	s.mu.Lock()
+	s.drained = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *fakeSub) Unsubscribe() error {
+	s.mu.Lock()
+	s.unsubscribed = true
+	s.mu.Unlock()
+	return nil
+}
+
+// testPipeline is the pipeline declared in the configuration
+type testPipeline map[string]any
+
+func (p testPipeline) With(name string, value any) { p[name] = value }
+func (p testPipeline) Name() string                { return p.String("name", "") }
+func (p testPipeline) Driver() string              { return p.String("driver", "") }
+func (p testPipeline) Priority() int64             { return int64(p.Int("priority", 10)) }
+func (p testPipeline) Get(key string) any          { return p[key] }
+
+func (p testPipeline) Has(name string) bool {
+	_, ok := p[name]
+	return ok
+}
+
+func (p testPipeline) String(name string, d string) string {
+	if v, ok := p[name].(string); ok {
+		return v
+	}
+
+	return d
+}
+
+func (p testPipeline) Int(name string, d int) int {
+	switch v := p[name].(type) {
+	case int:
+		return v
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+
+	return d
+}
+
+func (p testPipeline) Bool(name string, d bool) bool {
+	if v, ok := p[name].(bool); ok {
+		return v
+	}
+
+	return d
+}
+
+func (p testPipeline) Map(string, map[string]string) error {
+	return nil
+}
+
+// testQueue is the priority queue recording the inserted items
+type testQueue struct {
+	mu    sync.Mutex
+	items []pq.Item
+}
+
+func (q *testQueue) PeekPriority() int64 { return 0 }
+
+func (q *testQueue) Insert(item pq.Item) {
+	q.mu.Lock()
+	q.items = append(q.items, item)
+	q.mu.Unlock()
+}
+
+func (q *testQueue) ExtractMin() pq.Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	item := q.items[0]
+	q.items = q.items[1:]
+	return item
+}
+
+func (q *testQueue) Len() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return uint64(len(q.items))
+}
+
+// newTestDriver returns the driver wired to the fakes, without the connection
+func newTestDriver(t testing.TB, js *fakeJS) (*Driver, *testQueue) {
+	t.Helper()
+
+	q := &testQueue{}
+	c := &Driver{
+		log:       zap.NewNop(),
+		queue:     q,
+		js:        js,
+		msgCh:     make(chan *nats.Msg, 16),
+		stream:    "jobs",
+		priority:  10,
+		ackPolicy: ackPolicyExplicit,
+		closeCh:   make(chan struct{}),
+	}
+
+	var pipe jobs.Pipeline = testPipeline{"name": "test", "driver": pluginName}
+	c.pipeline.Store(&pipe)
+	c.opts.Store(&reloadOpts{subject: "jobs.default"})
+
+	return c, q
+}
+
+// jsMsg returns the message as delivered by the push consumer, the metadata is parsed from the reply subject
+func jsMsg(subject string, data []byte, seq uint64) *nats.Msg {
+	return &nats.Msg{
+		Subject: subject,
+		Data:    data,
+		Reply:   "$JS.ACK.jobs.consumer.1." + strconv.FormatUint(seq, 10) + "." + strconv.FormatUint(seq, 10) + ".1700000000000000000.0",
+		Header:  nats.Header{},
+		// Metadata requires the bound message
+		Sub: &nats.Subscription{Subject: subject},
+	}
+}
@@ -0,0 +1,105 @@
+package natsjobs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/roadrunner-server/errors"
+)
+
+// natsContext is the connection profile saved by the nats CLI (nats context save)
+type natsContext struct {
+	URL         string `json:"url"`
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	Token       string `json:"token"`
+	Creds       string `json:"creds"`
+	Cert        string `json:"cert"`
+	Key         string `json:"key"`
+	CA          string `json:"ca"`
+	InboxPrefix string `json:"inbox_prefix"`
+}
+
+// contextPath returns the path of the context file, the name might be a path to the file as well
+func contextPath(name string) (string, error) {
+	if strings.ContainsRune(name, os.PathSeparator) || strings.HasSuffix(name, ".json") {
+		return name, nil
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "nats", "context", name+".json"), nil
+}
+
+// applyContext loads the nats CLI context, the options set explicitly in the configuration take precedence.
+// Should be called before the InitDefaults.
+func applyContext(conf *config) error {
+	const op = errors.Op("nats_context")
+	if conf.Context == "" {
+		return nil
+	}
+
+	path, err := contextPath(conf.Context)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.E(op, errors.Errorf("failed to read the nats context %s: %v", conf.Context, err))
+	}
+
+	nc := &natsContext{}
+	err = json.Unmarshal(data, nc)
+	if err != nil {
+		return errors.E(op, errors.Errorf("failed to parse the nats context %s: %v", path, err))
+	}
+
+	if conf.Addr == "" {
+		conf.Addr = nc.URL
+	}
+
+	if conf.Creds == "" {
+		conf.Creds = expandHome(nc.Creds)
+	}
+
+	if conf.InboxPrefix == "" {
+		conf.InboxPrefix = nc.InboxPrefix
+	}
+
+	if conf.TLS == nil && (nc.Cert != "" || nc.CA != "") {
+		conf.TLS = &tlsConfig{
+			Cert:   expandHome(nc.Cert),
+			Key:    expandHome(nc.Key),
+			RootCA: expandHome(nc.CA),
+		}
+	}
+
+	conf.user, conf.password, conf.token = nc.User, nc.Password, nc.Token
+
+	return nil
+}
+
+// expandHome expands the leading ~ the nats CLI allows in the context paths
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
+}
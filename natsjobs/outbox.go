@@ -0,0 +1,157 @@
+package natsjobs
+
+import (
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// outboxOverflowError rejects the new pushes when the outbox is full
+	outboxOverflowError string = "error"
+	// outboxOverflowDropOldest drops the oldest buffered message to make room for the new one
+	outboxOverflowDropOldest string = "drop_oldest"
+)
+
+// outbox buffers the pushes while the connection is down and flushes them in order once reconnected
+type outbox struct {
+	mu       sync.Mutex
+	size     int
+	overflow string
+	msgs     []*nats.Msg
+	// only one flush at a time to preserve the order
+	flushing bool
+}
+
+func newOutbox(size int, overflow string) (*outbox, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+
+	switch overflow {
+	case "":
+		overflow = outboxOverflowError
+	case outboxOverflowError, outboxOverflowDropOldest:
+	default:
+		return nil, errors.Errorf("unknown outbox_overflow: %s, available: error, drop_oldest", overflow)
+	}
+
+	return &outbox{
+		size:     size,
+		overflow: overflow,
+		msgs:     make([]*nats.Msg, 0, size),
+	}, nil
+}
+
+// add buffers the message, the data should be owned by the message
+func (o *outbox) add(m *nats.Msg) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.msgs) >= o.size {
+		if o.overflow != outboxOverflowDropOldest {
+			return errors.Errorf("outbox is full (%d messages)", o.size)
+		}
+
+		o.msgs[0] = nil
+		o.msgs = o.msgs[1:]
+	}
+
+	o.msgs = append(o.msgs, m)
+	return nil
+}
+
+// len returns the number of the buffered messages
+func (o *outbox) len() int {
+	if o == nil {
+		return 0
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.msgs)
+}
+
+// connected checks if the publish might be sent right away
+func (c *Driver) connected() bool {
+	return c.conn.IsConnected()
+}
+
+// pushOutbox buffers the job while the connection is down. The job ID is used as the Nats-Msg-Id, so the server
+// deduplicates the message if it was actually stored before the disconnect.
+func (c *Driver) pushOutbox(subject string, data []byte, hdr nats.Header) error {
+	err := c.outbox.add(&nats.Msg{
+		Subject: subject,
+		// the data buffer is reused after the push
+		Data:   append([]byte(nil), data...),
+		Header: c.withStaticHeaders(hdr),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.log.Debug("message buffered in the outbox", zap.String("subject", subject), zap.Int("buffered", c.outbox.len()))
+
+	// reconnected, but the previous flush failed
+	if c.connected() {
+		go c.flushOutbox()
+	}
+
+	return nil
+}
+
+// flushOutbox publishes the buffered messages in order, the rest is kept on the first error
+func (c *Driver) flushOutbox() {
+	o := c.outbox
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	if o.flushing {
+		o.mu.Unlock()
+		return
+	}
+	o.flushing = true
+	o.mu.Unlock()
+
+	defer func() {
+		o.mu.Lock()
+		o.flushing = false
+		o.mu.Unlock()
+	}()
+
+	flushed := 0
+	for {
+		o.mu.Lock()
+		if len(o.msgs) == 0 {
+			o.mu.Unlock()
+			break
+		}
+		m := o.msgs[0]
+		o.mu.Unlock()
+
+		_, err := c.js.PublishMsg(m)
+		if err != nil {
+			c.log.Error("failed to flush the outbox", zap.Int("flushed", flushed), zap.Int("left", o.len()), zap.Error(err))
+			return
+		}
+
+		o.mu.Lock()
+		// the head might be dropped by the overflow while publishing
+		if len(o.msgs) > 0 && o.msgs[0] == m {
+			o.msgs[0] = nil
+			o.msgs = o.msgs[1:]
+		}
+		o.mu.Unlock()
+
+		flushed++
+	}
+
+	if flushed > 0 {
+		c.log.Info("outbox flushed", zap.Int("messages", flushed))
+	}
+}
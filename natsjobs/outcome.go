@@ -0,0 +1,73 @@
+package natsjobs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// job processing outcomes
+const (
+	outcomeAcked      string = "acked"
+	outcomeNacked     string = "nacked"
+	outcomeRequeued   string = "requeued"
+	outcomeTerminated string = "terminated"
+)
+
+// resultEnvelope is published to the result subject when the worker finished the job
+type resultEnvelope struct {
+	ID       string `json:"id"`
+	Job      string `json:"job"`
+	Pipeline string `json:"pipeline"`
+	Status   string `json:"status"`
+	// Duration from the delivery to the worker response in milliseconds
+	Duration int64 `json:"duration_ms"`
+	// Result is the worker response (if any)
+	Result string `json:"result,omitempty"`
+}
+
+// jobOutcome records the job processing outcome reported by the worker
+func (c *Driver) jobOutcome(item *Item, status string) {
+	if status == outcomeAcked {
+		c.processed.Add(1)
+	} else {
+		c.failed.Add(1)
+	}
+
+	if c.resultSubject != "" {
+		c.publishResult(item, status)
+	}
+
+	c.trackStatus(&JobStatus{
+		ID:       item.Ident,
+		Job:      item.Job,
+		Status:   outcomeStatus(status),
+		Outcome:  status,
+		Attempts: item.Options.delivered,
+	})
+}
+
+// publishResult publishes the job result without waiting for the ack, the worker isn't blocked by the result delivery
+func (c *Driver) publishResult(item *Item, status string) {
+	data, err := json.Marshal(&resultEnvelope{
+		ID:       item.Ident,
+		Job:      item.Job,
+		Pipeline: item.Options.Pipeline,
+		Status:   status,
+		Duration: time.Since(item.Options.received).Milliseconds(),
+		Result:   string(item.Options.result),
+	})
+	if err != nil {
+		c.log.Error("failed to marshal the job result", zap.String("id", item.Ident), zap.Error(err))
+		return
+	}
+
+	// the same job might be finished several times (redeliveries), the sequence makes the ID unique
+	_, err = c.js.PublishAsync(c.resultSubject, data, nats.MsgId(item.Ident+"-"+status+"-"+strconv.FormatUint(item.Options.seq, 10)))
+	if err != nil {
+		c.log.Error("failed to publish the job result", zap.String("id", item.Ident), zap.String("subject", c.resultSubject), zap.Error(err))
+	}
+}
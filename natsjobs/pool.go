@@ -0,0 +1,77 @@
+package natsjobs
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/goccy/go-json"
+)
+
+// buffers larger than this are not returned to the pool to not retain the memory after the large payloads
+const maxPooledBuffer int = 1024 * 1024
+
+// pools reuse the serialization buffers and the JSON encoders to reduce the GC pressure under load.
+// The items aren't pooled, the jobs plugin might use them after Ack.
+type pools struct {
+	buffers  sync.Pool
+	encoders sync.Pool
+}
+
+// encoder is the JSON encoder writing into the swappable buffer
+type encoder struct {
+	out *bytes.Buffer
+	enc *json.Encoder
+}
+
+func (e *encoder) Write(p []byte) (int, error) {
+	return e.out.Write(p)
+}
+
+func (p *pools) getEncoder() *encoder {
+	if e, ok := p.encoders.Get().(*encoder); ok {
+		return e
+	}
+
+	e := &encoder{}
+	e.enc = json.NewEncoder(e)
+	return e
+}
+
+// marshal encodes the value into the pooled buffer, the buffer should be returned via putBuffer after use
+func (p *pools) marshal(v any) (*bytes.Buffer, error) {
+	buf := p.getBuffer()
+	e := p.getEncoder()
+
+	e.out = buf
+	err := e.enc.Encode(v)
+	e.out = nil
+	p.encoders.Put(e)
+
+	if err != nil {
+		p.putBuffer(buf)
+		return nil, err
+	}
+
+	// the encoder adds a trailing newline
+	buf.Truncate(buf.Len() - 1)
+
+	return buf, nil
+}
+
+func (p *pools) getBuffer() *bytes.Buffer {
+	if buf, ok := p.buffers.Get().(*bytes.Buffer); ok {
+		return buf
+	}
+
+	return new(bytes.Buffer)
+}
+
+// putBuffer returns the buffer to the pool, the data should be already copied (e.g. published)
+func (p *pools) putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+
+	buf.Reset()
+	p.buffers.Put(buf)
+}
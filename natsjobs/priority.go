@@ -0,0 +1,225 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// consumer create/update and pull request API subjects (nats-server 2.11+ for the priority groups)
+	apiConsumerCreate string = "$JS.API.CONSUMER.CREATE."
+	apiConsumerNext   string = "$JS.API.CONSUMER.MSG.NEXT."
+
+	priorityPolicyOverflow string = "overflow"
+
+	// pull request statuses ending the batch: no messages, request expired, request terminated (e.g. max_waiting)
+	statusNoMessages string = "404"
+	statusExpired    string = "408"
+	statusConflict   string = "409"
+)
+
+// priorityGroup is the consumer priority group with the overflow policy. The pull requests with the min_pending
+// (min_ack_pending) thresholds are served only if the consumer has more pending (unacknowledged) messages,
+// so the standby RR instances receive the messages only when the primary ones are saturated or down.
+// nil priorityGroup is disabled.
+type priorityGroup struct {
+	name          string
+	minPending    int64
+	minAckPending int64
+}
+
+type pullRequest struct {
+	Batch         int           `json:"batch,omitempty"`
+	Expires       time.Duration `json:"expires,omitempty"`
+	Group         string        `json:"group"`
+	MinPending    int64         `json:"min_pending,omitempty"`
+	MinAckPending int64         `json:"min_ack_pending,omitempty"`
+}
+
+func newPriorityGroup(name string, pull bool, durable string, minPending, minAckPending int64) (*priorityGroup, error) {
+	if name == "" {
+		if minPending != 0 || minAckPending != 0 {
+			return nil, errors.Str("priority_min_pending and priority_min_ack_pending require the priority_group")
+		}
+
+		return nil, nil
+	}
+
+	// the group is shared by the instances via the same durable pull consumer
+	if !pull || durable == "" {
+		return nil, errors.Str("priority_group is supported only by the durable pull consumers (pull and durable options)")
+	}
+
+	if minPending < 0 || minAckPending < 0 {
+		return nil, errors.Str("priority_min_pending and priority_min_ack_pending should not be negative")
+	}
+
+	return &priorityGroup{
+		name:          name,
+		minPending:    minPending,
+		minAckPending: minAckPending,
+	}, nil
+}
+
+// applyPriorityGroup updates the consumer with the priority group and the overflow policy,
+// the client library doesn't support the priority groups yet
+func (c *Driver) applyPriorityGroup(sub *nats.Subscription) (consumerRef, error) {
+	ci, err := sub.ConsumerInfo()
+	if err != nil {
+		return consumerRef{}, err
+	}
+
+	data, err := json.Marshal(ci.Config)
+	if err != nil {
+		return consumerRef{}, err
+	}
+
+	cfg := make(map[string]any)
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return consumerRef{}, err
+	}
+
+	cfg["priority_groups"] = []string{c.group.name}
+	cfg["priority_policy"] = priorityPolicyOverflow
+
+	data, err = json.Marshal(map[string]any{
+		"stream_name": ci.Stream,
+		"config":      cfg,
+	})
+	if err != nil {
+		return consumerRef{}, err
+	}
+
+	msg, err := c.conn.Request(apiConsumerCreate+ci.Stream+"."+ci.Name, data, time.Second*5)
+	if err != nil {
+		return consumerRef{}, err
+	}
+
+	var resp struct {
+		Error *nats.APIError `json:"error,omitempty"`
+	}
+
+	err = json.Unmarshal(msg.Data, &resp)
+	if err != nil {
+		return consumerRef{}, err
+	}
+
+	if resp.Error != nil {
+		return consumerRef{}, resp.Error
+	}
+
+	return consumerRef{stream: ci.Stream, name: ci.Name}, nil
+}
+
+// fetchGroup is the fetch loop sending the pull requests of the priority group. The library doesn't allow to read
+// the pull subscription directly (NextMsg), so the messages are delivered to the private inbox, the pull
+// subscription only holds the consumer.
+func (c *Driver) fetchGroup(sub *nats.Subscription, ref consumerRef, stopCh chan struct{}) {
+	subject := apiConsumerNext + ref.stream + "." + ref.name
+
+	inbox, err := c.conn.SubscribeSync(nats.NewInbox())
+	if err != nil {
+		c.log.Error("failed to subscribe to the pull requests inbox", zap.String("subject", subject), zap.Error(err))
+		return
+	}
+
+	// the pending pull request expires on the server, the late messages are redelivered after the ack_wait
+	defer func() {
+		_ = inbox.Unsubscribe()
+	}()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		// the consumer subscription is drained or closed
+		if !sub.IsValid() {
+			return
+		}
+
+		batch := c.adaptive.batch(c.fetchBatch)
+		data, err := json.Marshal(&pullRequest{
+			Batch:         batch,
+			Expires:       c.fetchTimeout,
+			Group:         c.group.name,
+			MinPending:    c.group.minPending,
+			MinAckPending: c.group.minAckPending,
+		})
+		if err != nil {
+			c.log.Error("failed to marshal the pull request", zap.Error(err))
+			return
+		}
+
+		err = c.conn.PublishRequest(subject, inbox.Subject, data)
+		if err != nil {
+			if stderr.Is(err, nats.ErrConnectionClosed) || stderr.Is(err, nats.ErrConnectionDraining) {
+				return
+			}
+
+			c.log.Warn("pull request error", zap.String("subject", subject), zap.Error(err))
+			select {
+			case <-time.After(time.Second):
+			case <-stopCh:
+				return
+			}
+
+			continue
+		}
+
+		conflict := false
+		msgs := make([]*nats.Msg, 0, batch)
+		// the server ends the request with the status message, the extra second covers the round trip
+		deadline := time.Now().Add(c.fetchTimeout + time.Second)
+		for len(msgs) < batch {
+			m, err := inbox.NextMsg(time.Until(deadline))
+			if err != nil {
+				if stderr.Is(err, nats.ErrTimeout) {
+					break
+				}
+
+				// the connection is closed
+				c.dispatch(msgs, stopCh)
+				return
+			}
+
+			if len(m.Data) == 0 && m.Header.Get(statusHeader) != "" {
+				switch m.Header.Get(statusHeader) {
+				case controlStatus:
+					continue
+				case statusNoMessages, statusExpired:
+				case statusConflict:
+					conflict = true
+					c.log.Warn("pull request terminated", zap.String("subject", subject), zap.String("description", m.Header.Get("Description")))
+				default:
+					c.log.Warn("unexpected pull request status", zap.String("status", m.Header.Get(statusHeader)), zap.String("description", m.Header.Get("Description")))
+				}
+
+				break
+			}
+
+			msgs = append(msgs, m)
+		}
+
+		if !c.dispatch(msgs, stopCh) {
+			return
+		}
+
+		// do not spin on the terminated requests
+		if conflict {
+			select {
+			case <-time.After(time.Second):
+			case <-stopCh:
+				return
+			}
+		}
+	}
+}
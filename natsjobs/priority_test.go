@@ -0,0 +1,78 @@
+package natsjobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// the embedded server predates the priority groups (2.11), the group fields of the pull requests are ignored,
+// so the test covers the pull requests and the delivery of the group consumer
+func TestFetchGroupDelivers(t *testing.T) {
+	srv, err := StartEmbedded(t.TempDir(), 0, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(srv.Shutdown)
+
+	conn, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	require.NoError(t, err)
+
+	_, err = js.AddStream(&nats.StreamConfig{Name: "jobs", Subjects: []string{"jobs.>"}})
+	require.NoError(t, err)
+
+	sub, err := js.PullSubscribe("jobs.default", "group")
+	require.NoError(t, err)
+
+	// the pull subscription can't be read directly
+	_, err = sub.NextMsg(time.Millisecond)
+	require.ErrorIs(t, err, nats.ErrTypeSubscription)
+
+	c, _ := newTestDriver(t, &fakeJS{})
+	c.conn = conn
+	c.fetchBatch = 10
+	c.fetchTimeout = time.Millisecond * 200
+	c.group = &priorityGroup{name: "jobs", minPending: 1}
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.fetchGroup(sub, consumerRef{stream: "jobs", name: "group"}, stopCh)
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		_, err = js.Publish("jobs.default", jobData(t, string(rune('a'+i)), 1))
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case m := <-c.msgCh:
+			meta, errM := m.Metadata()
+			require.NoError(t, errM)
+			assert.Equal(t, "jobs", meta.Stream)
+			assert.EqualValues(t, i+1, meta.Sequence.Stream)
+			require.NoError(t, m.AckSync())
+		case <-time.After(time.Second * 5):
+			t.Fatalf("message %d is not delivered", i)
+		}
+	}
+
+	ci, err := sub.ConsumerInfo()
+	require.NoError(t, err)
+	assert.Zero(t, ci.NumAckPending)
+
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("fetch loop is not stopped")
+	}
+}
@@ -0,0 +1,124 @@
+package natsjobs
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
+	"github.com/roadrunner-server/errors"
+)
+
+// profiles are the grouped defaults of the pipeline options, the explicitly set options take precedence.
+// The presets don't contain the push or pull only options, so they fit both consumer modes.
+var profiles = map[string]map[string]any{
+	// no message loss: individual acks, retried and buffered publishes. The consumers inherit the stream replicas,
+	// so they are replicated in the JetStream cluster and still created by the single server (embedded).
+	"durability": {
+		pipeAckPolicy:           ackPolicyExplicit,
+		pipePrefetch:            10,
+		pipeAckWait:             "60s",
+		pipePublishRetries:      5,
+		pipePublishRetryBackoff: "500ms",
+		pipeOutboxSize:          10000,
+	},
+	// max jobs per second: large prefetch, a single consumer replica, less frequent consumer info requests
+	"throughput": {
+		pipePrefetch:         1000,
+		pipeConsumerReplicas: 1,
+		pipeAckWait:          "5m",
+		pipeStateCacheTTL:    "5s",
+	},
+	// min time to the worker: no buffered messages waiting behind the slow ones
+	"latency": {
+		pipePrefetch:         1,
+		pipeConsumerReplicas: 1,
+		pipeAckWait:          "30s",
+	},
+}
+
+func validateProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+
+	if _, ok := profiles[profile]; !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return errors.Errorf("unknown profile: %s, available: %s", profile, strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// applyPipelineProfile sets the profile defaults of the pipeline options which are not set
+func applyPipelineProfile(pipe jobs.Pipeline) error {
+	profile := pipe.String(pipeProfile, "")
+	err := validateProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	for key, v := range profiles[profile] {
+		if !pipe.Has(key) {
+			pipe.With(key, v)
+		}
+	}
+
+	return nil
+}
+
+// applyProfile sets the profile defaults of the config options which are not set, set reports the option presence
+func (c *config) applyProfile(set func(key string) bool) error {
+	err := validateProfile(c.Profile)
+	if err != nil {
+		return err
+	}
+
+	preset := profiles[c.Profile]
+	if len(preset) == 0 {
+		return nil
+	}
+
+	rv := reflect.ValueOf(c).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		key := rt.Field(i).Tag.Get("mapstructure")
+		v, ok := preset[key]
+		if !ok || set(key) {
+			continue
+		}
+
+		err = setField(rv.Field(i), v)
+		if err != nil {
+			return errors.Errorf("profile %s, option %s: %v", c.Profile, key, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(f reflect.Value, v any) error {
+	if f.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(v.(string))
+		if err != nil {
+			return err
+		}
+
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	val := reflect.ValueOf(v)
+	if !val.CanConvert(f.Type()) {
+		return errors.Errorf("can't set %T to %s", v, f.Type())
+	}
+
+	f.Set(val.Convert(f.Type()))
+	return nil
+}
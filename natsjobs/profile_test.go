@@ -0,0 +1,30 @@
+package natsjobs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// every profile starts the pipeline on the single server
+func TestProfilesSingleServer(t *testing.T) {
+	for name := range profiles {
+		t.Run(name, func(t *testing.T) {
+			c, _ := newEmbeddedDriver(t, testPipeline{pipeProfile: name})
+			// the embedded server (2.7) ignores the consumer replicas, the newer single servers reject more than one
+			assert.LessOrEqual(t, c.consumerReplicas, 1)
+
+			require.NoError(t, c.Run(context.Background(), testPipeline{"name": "bench"}))
+
+			subs := c.subscriptions()
+			require.NotEmpty(t, subs)
+
+			ci, err := subs[0].ConsumerInfo()
+			require.NoError(t, err)
+			// the consumer inherits the stream replicas
+			assert.LessOrEqual(t, ci.Config.Replicas, 1)
+		})
+	}
+}
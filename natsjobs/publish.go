@@ -0,0 +1,227 @@
+package natsjobs
+
+import (
+	"context"
+	stderr "errors"
+	"hash/fnv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// max delay between the publish retries
+	maxPublishBackoff time.Duration = time.Second * 10
+	// max time to wait for the broadcast acks
+	broadcastAckWait time.Duration = time.Second * 5
+)
+
+// publish publishes the data to the JetStream subject with the optional headers, the retries are stopped
+// when the context is done or the pipeline is stopped
+func (c *Driver) publish(ctx context.Context, subject string, data []byte, hdr nats.Header) (*nats.PubAck, error) {
+	err := c.breakerAllow()
+	if err != nil {
+		return nil, err
+	}
+
+	ack, err := c.publishRetry(ctx, subject, data, hdr)
+	if err != nil && c.streamGone(err) {
+		c.recreate("stream not found on publish")
+		if c.recreateStream {
+			ack, err = c.publishMsg(subject, data, hdr)
+		}
+	}
+
+	c.breakerResult(err)
+	if err == nil {
+		c.lastPublish.Store(time.Now().UnixNano())
+	}
+
+	return ack, err
+}
+
+// publishRetry retries the publish on the transient errors with the exponential backoff
+func (c *Driver) publishRetry(ctx context.Context, subject string, data []byte, hdr nats.Header) (*nats.PubAck, error) {
+	backoff := c.publishRetryBackoff
+	for attempt := 0; ; attempt++ {
+		ack, err := c.publishMsg(subject, data, hdr)
+		if err == nil || attempt >= c.publishRetries || !transient(err) || expectsLastSeq(hdr) {
+			return ack, err
+		}
+
+		c.log.Debug("publish failed, retrying", zap.String("subject", subject), zap.Int("attempt", attempt+1), zap.Duration("backoff", backoff), zap.Error(err))
+
+		// the last publish error is returned, the message isn't stored
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ack, err
+		case <-c.closeCh:
+			return ack, err
+		}
+
+		backoff *= 2
+		if backoff > maxPublishBackoff {
+			backoff = maxPublishBackoff
+		}
+	}
+}
+
+// transient checks if the publish error is temporary, e.g. during the stream leader election
+func transient(err error) bool {
+	return stderr.Is(err, nats.ErrTimeout) ||
+		stderr.Is(err, nats.ErrNoResponders) ||
+		stderr.Is(err, nats.ErrNoStreamResponse) ||
+		stderr.Is(err, context.DeadlineExceeded)
+}
+
+// expectsLastSeq checks the optimistic concurrency expectations, such a publish isn't retried: if the first attempt
+// was stored, the retry fails with the wrong last sequence instead of the original error
+func expectsLastSeq(hdr nats.Header) bool {
+	return hdr.Get(nats.ExpectedLastSeqHdr) != "" || hdr.Get(nats.ExpectedLastSubjSeqHdr) != ""
+}
+
+func (c *Driver) publishMsg(subject string, data []byte, hdr nats.Header) (*nats.PubAck, error) {
+	hdr = c.withStaticHeaders(hdr)
+	if len(hdr) == 0 {
+		return c.js.Publish(subject, data)
+	}
+
+	return c.js.PublishMsg(&nats.Msg{
+		Subject: subject,
+		Data:    data,
+		Header:  hdr,
+	})
+}
+
+// pushSubject returns the subject to publish the job to.
+// The job name routes take precedence over the canary routing.
+// When the canary subject is configured, the job ID hash is used to route canaryWeight percent of the jobs
+// to the canary subject, so the same job is always routed to the same subject.
+func (c *Driver) pushSubject(name, id string) string {
+	if subject, ok := c.routeSubject(name); ok {
+		return subject
+	}
+
+	if c.canarySubject == "" || c.canaryWeight == 0 {
+		return c.opts.Load().subject
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+
+	if h.Sum32()%100 < c.canaryWeight {
+		return c.canarySubject
+	}
+
+	return c.opts.Load().subject
+}
+
+// withMsgID adds the Nats-Msg-Id header used by the server for the deduplication
+func withMsgID(hdr nats.Header, id string) nats.Header {
+	if hdr == nil {
+		hdr = nats.Header{}
+	}
+
+	hdr.Set(nats.MsgIdHdr, id)
+	return hdr
+}
+
+// checkPayloadSize rejects the message exceeding the server max_payload before the round trip
+func (c *Driver) checkPayloadSize(size int) error {
+	limit := c.conn.MaxPayload()
+	// not connected yet, the limit is unknown
+	if limit <= 0 || int64(size) <= limit {
+		return nil
+	}
+
+	if c.obs == nil {
+		return errors.Errorf("message size (%d bytes) exceeds the server max_payload (%d bytes), use the max_inline_payload with the object_bucket to store the large payloads in the object store", size, limit)
+	}
+
+	return errors.Errorf("message size (%d bytes) exceeds the server max_payload (%d bytes), decrease the max_inline_payload (%d bytes) to store the large payloads in the object store", size, limit, c.maxInlinePayload)
+}
+
+// publishBroadcast publishes the message to the pipeline subject and all the broadcast subjects asynchronously
+// and waits for all the acks. The message is deduplicated per subject, so the failed push might be retried safely.
+func (c *Driver) publishBroadcast(subject string, data []byte, hdr nats.Header, id string) ([]*nats.PubAck, error) {
+	err := c.breakerAllow()
+	if err != nil {
+		return nil, err
+	}
+
+	acks, err := c.broadcast(subject, data, hdr, id)
+	c.breakerResult(err)
+	if err == nil {
+		c.lastPublish.Store(time.Now().UnixNano())
+	}
+
+	return acks, err
+}
+
+func (c *Driver) broadcast(subject string, data []byte, hdr nats.Header, id string) ([]*nats.PubAck, error) {
+	subjects := make([]string, 0, len(c.broadcastSubjects)+1)
+	subjects = append(subjects, subject)
+	subjects = append(subjects, c.broadcastSubjects...)
+
+	futures := make([]nats.PubAckFuture, 0, len(subjects))
+	for i := 0; i < len(subjects); i++ {
+		h := make(nats.Header, len(hdr)+1)
+		for k, v := range c.withStaticHeaders(hdr) {
+			h[k] = v
+		}
+		h.Set(nats.MsgIdHdr, id+":"+subjects[i])
+
+		f, err := c.js.PublishMsgAsync(&nats.Msg{
+			Subject: subjects[i],
+			Data:    data,
+			Header:  h,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		futures = append(futures, f)
+	}
+
+	timer := time.NewTimer(broadcastAckWait)
+	defer timer.Stop()
+
+	acks := make([]*nats.PubAck, 0, len(futures))
+	for i := 0; i < len(futures); i++ {
+		select {
+		case ack := <-futures[i].Ok():
+			acks = append(acks, ack)
+		case err := <-futures[i].Err():
+			return nil, errors.Errorf("broadcast to the subject %s failed: %v", subjects[i], err)
+		case <-timer.C:
+			return nil, errors.Errorf("broadcast to the subject %s: %v", subjects[i], nats.ErrTimeout)
+		}
+	}
+
+	return acks, nil
+}
+
+// published logs the stream sequences of the pushed job, so the producers might correlate the jobs with the stream.
+// The jobs API doesn't allow to return the publish ack to the caller.
+func (c *Driver) published(id string, acks []*nats.PubAck) {
+	for i := 0; i < len(acks); i++ {
+		if acks[i] == nil {
+			continue
+		}
+
+		// duplicate - the message with the same ID was already stored, the sequence is of the stored message
+		c.log.Debug("job published", zap.String("id", id), zap.String("stream", acks[i].Stream), zap.Uint64("sequence", acks[i].Sequence), zap.Bool("duplicate", acks[i].Duplicate))
+	}
+}
+
+func validateBroadcast(subjects []string, ob *outbox) error {
+	// the buffered messages are flushed to the pipeline subject only
+	if len(subjects) > 0 && ob != nil {
+		return errors.Str("broadcast_subjects can't be used with the outbox_size")
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// the options supported only by the push or by the pull consumers
+var (
+	pushOptions = [...]string{pipeRateLimit, pipeIdleHeartbeat, pipeFlowControl, pipeDeliverGroup}
+	pullOptions = [...]string{pipeMaxWaiting, pipeFetchBatch, pipeFetchTimeout, pipePriorityGroup, pipePriorityMinPending, pipePriorityMinAckPending}
+)
+
+// validatePull checks that the configured options are supported by the consumer mode, set reports the option presence
+func validatePull(pull bool, set func(key string) bool) error {
+	if pull {
+		for i := 0; i < len(pushOptions); i++ {
+			if set(pushOptions[i]) {
+				return errors.Errorf("%s is supported only by the push consumers, remove it or set pull: false", pushOptions[i])
+			}
+		}
+
+		return nil
+	}
+
+	for i := 0; i < len(pullOptions); i++ {
+		if set(pullOptions[i]) {
+			return errors.Errorf("%s is supported only by the pull consumers, remove it or set pull: true", pullOptions[i])
+		}
+	}
+
+	return nil
+}
+
+// pullSubscribe creates the pull consumer, the fetched messages are sent to the listener channel
+func (c *Driver) pullSubscribe(subject, durable string, opts []nats.SubOpt) (*nats.Subscription, error) {
+	if c.maxWaiting > 0 {
+		opts = append(opts, nats.PullMaxWaiting(c.maxWaiting))
+	}
+
+	sub, err := c.js.PullSubscribe(subject, durable, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.group != nil {
+		ref, err := c.applyPriorityGroup(sub)
+		if err != nil {
+			_ = sub.Unsubscribe()
+			return nil, err
+		}
+
+		go c.fetchGroup(sub, ref, c.fetchStop)
+		return sub, nil
+	}
+
+	go c.fetch(sub, c.fetchStop)
+
+	return sub, nil
+}
+
+// fetch long-polls the pull consumer until the subscription is drained or the stop channel is closed
+func (c *Driver) fetch(sub *nats.Subscription, stopCh chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		msgs, err := sub.Fetch(c.adaptive.batch(c.fetchBatch), nats.MaxWait(c.fetchTimeout))
+		if err != nil {
+			switch {
+			case stderr.Is(err, nats.ErrTimeout):
+				// no messages within the fetch_timeout
+				continue
+			case stderr.Is(err, nats.ErrBadSubscription), stderr.Is(err, nats.ErrConnectionClosed), stderr.Is(err, nats.ErrConnectionDraining):
+				return
+			default:
+				c.log.Warn("fetch error", zap.String("subject", sub.Subject), zap.Error(err))
+				// do not spin on the persistent errors
+				select {
+				case <-time.After(time.Second):
+				case <-stopCh:
+					return
+				}
+
+				continue
+			}
+		}
+
+		if !c.dispatch(msgs, stopCh) {
+			return
+		}
+	}
+}
+
+// dispatch sends the fetched messages to the listener, returns false if the stop channel was closed
+func (c *Driver) dispatch(msgs []*nats.Msg, stopCh chan struct{}) bool {
+	for i := 0; i < len(msgs); i++ {
+		select {
+		case c.msgCh <- msgs[i]:
+		case <-stopCh:
+			// let the rest be redelivered
+			for j := i; j < len(msgs); j++ {
+				_ = msgs[j].Nak()
+			}
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// stopFetch stops the pull consumers fetch loops
+func (c *Driver) stopFetch() {
+	if c.fetchStop != nil {
+		close(c.fetchStop)
+		c.fetchStop = nil
+	}
+}
@@ -0,0 +1,76 @@
+package natsjobs
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// PurgeRequest is the pipeline stream purge request
+type PurgeRequest struct {
+	// Pipeline to purge the stream of
+	Pipeline string `json:"pipeline"`
+	// Subject to purge the messages of, default - the pipeline subject
+	Subject string `json:"subject"`
+	// Keep is the number of the latest messages to keep
+	Keep uint64 `json:"keep"`
+	// Sequence to purge the messages up to (not including), can't be used with the keep
+	Sequence uint64 `json:"sequence"`
+}
+
+// PurgeResult reports the number of the purged messages
+type PurgeResult struct {
+	Pipeline string `json:"pipeline"`
+	Stream   string `json:"stream"`
+	Subject  string `json:"subject"`
+	// Purged is calculated from the stream state, might be inaccurate if the messages were published during the purge
+	Purged uint64 `json:"purged"`
+}
+
+// Purge removes the messages from the pipeline stream, the stream and the consumers are kept.
+// The consumers skip the purged messages, the ones being processed can't be acknowledged anymore.
+func (c *Driver) Purge(req *PurgeRequest) (*PurgeResult, error) {
+	const op = errors.Op("nats_purge")
+
+	if req.Keep > 0 && req.Sequence > 0 {
+		return nil, errors.E(op, errors.Str("keep and sequence can't be used together"))
+	}
+
+	subject := req.Subject
+	if subject == "" {
+		subject = c.opts.Load().subject
+	}
+
+	before, err := c.js.StreamInfo(c.stream)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = c.js.PurgeStream(c.stream, &nats.StreamPurgeRequest{
+		Subject:  subject,
+		Keep:     req.Keep,
+		Sequence: req.Sequence,
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	after, err := c.js.StreamInfo(c.stream)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	res := &PurgeResult{
+		Pipeline: (*c.pipeline.Load()).Name(),
+		Stream:   c.stream,
+		Subject:  subject,
+	}
+
+	if before.State.Msgs > after.State.Msgs {
+		res.Purged = before.State.Msgs - after.State.Msgs
+	}
+
+	c.log.Info("stream purged", zap.String("pipeline", res.Pipeline), zap.String("stream", c.stream), zap.String("subject", subject), zap.Uint64("purged", res.Purged))
+
+	return res, nil
+}
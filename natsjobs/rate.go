@@ -0,0 +1,72 @@
+package natsjobs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// tokenBucket limits the number of the consumed jobs per second, shared by all the listener workers
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(perSecond, burst int) (*tokenBucket, error) {
+	if perSecond < 0 || burst < 0 {
+		return nil, errors.Errorf("jobs_per_second (%d) and jobs_burst (%d) should not be negative; jobs_per_second is the max number of the consumed jobs per second for both push and pull consumers, rate_limit is the push consumer delivery rate in bits per second", perSecond, burst)
+	}
+
+	if perSecond == 0 {
+		return nil, nil
+	}
+
+	if burst == 0 {
+		burst = perSecond
+	}
+
+	return &tokenBucket{
+		rate:   float64(perSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}, nil
+}
+
+// wait blocks until the token is available, returns false if the stopCh was triggered while waiting
+func (tb *tokenBucket) wait(stopCh <-chan struct{}) bool {
+	if tb == nil {
+		return true
+	}
+
+	for {
+		tb.mu.Lock()
+		now := time.Now()
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+		tb.last = now
+
+		if tb.tokens >= 1 {
+			tb.tokens--
+			tb.mu.Unlock()
+			return true
+		}
+
+		delay := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+		tb.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-stopCh:
+			timer.Stop()
+			return false
+		}
+	}
+}
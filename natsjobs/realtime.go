@@ -0,0 +1,72 @@
+package natsjobs
+
+import (
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// realtimePriority is the highest priority, lower values are taken from the priority queue first
+	realtimePriority int64 = 0
+	// realtimeWorkers is the number of goroutines handling the realtime messages out of the listener order
+	realtimeWorkers int = 8
+)
+
+// newRealtime returns the tokenized realtime subjects (might contain * and > wildcards)
+func newRealtime(subjects []string) [][]string {
+	if len(subjects) == 0 {
+		return nil
+	}
+
+	rt := make([][]string, 0, len(subjects))
+	for i := 0; i < len(subjects); i++ {
+		rt = append(rt, strings.Split(subjects[i], "."))
+	}
+
+	return rt
+}
+
+// realtime checks if the message subject is the latency-critical one. Such messages are handled out of the
+// listener order, skip the jobs rate limit and the queue backpressure, and take the head of the priority queue.
+func (c *Driver) realtime(subject string) bool {
+	if len(c.realtimeSubjects) == 0 {
+		return false
+	}
+
+	tokens := strings.Split(subject, ".")
+	for i := 0; i < len(c.realtimeSubjects); i++ {
+		if subjectMatch(c.realtimeSubjects[i], tokens) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// realtimeStart starts the fixed pool of the realtime handlers, they exit when the stopCh is closed.
+// The listener blocks on the channel when all the handlers are busy, so the burst of the realtime messages
+// doesn't spawn a goroutine per message. Returns nil if there are no realtime subjects.
+func (c *Driver) realtimeStart(stopCh <-chan struct{}) chan<- *nats.Msg {
+	if len(c.realtimeSubjects) == 0 {
+		return nil
+	}
+
+	rt := make(chan *nats.Msg, realtimeWorkers)
+	for i := 0; i < realtimeWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case m := <-rt:
+					if !c.handle(m, stopCh) {
+						return
+					}
+				case <-stopCh:
+					return
+				}
+			}
+		}()
+	}
+
+	return rt
+}
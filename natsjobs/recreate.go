@@ -0,0 +1,87 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	advisoryStreamDeleted   string = "$JS.EVENT.ADVISORY.STREAM.DELETED."
+	advisoryConsumerDeleted string = "$JS.EVENT.ADVISORY.CONSUMER.DELETED."
+)
+
+// watchDeletion subscribes to the stream and consumer deleted advisories, so the pipeline doesn't die quietly
+// when the stream or consumer is deleted at runtime
+func (c *Driver) watchDeletion() {
+	_, err := c.conn.Subscribe(advisoryStreamDeleted+c.stream, func(*nats.Msg) {
+		go c.recreate("stream was deleted")
+	})
+	if err != nil {
+		c.log.Warn("failed to subscribe to the stream deleted advisories", zap.Error(err))
+	}
+
+	_, err = c.conn.Subscribe(advisoryConsumerDeleted+c.stream+".*", func(m *nats.Msg) {
+		name := m.Subject[strings.LastIndexByte(m.Subject, '.')+1:]
+		names, _ := c.consumerNames.Load().(map[string]struct{})
+		if _, ok := names[name]; !ok {
+			return
+		}
+
+		go c.recreate("consumer was deleted")
+	})
+	if err != nil {
+		c.log.Warn("failed to subscribe to the consumer deleted advisories", zap.Error(err))
+	}
+}
+
+// recreate recreates the stream and the consumer (if the listener is active)
+func (c *Driver) recreate(reason string) {
+	if c.stopping.Load() {
+		return
+	}
+
+	pipe := (*c.pipeline.Load()).Name()
+	c.log.Error(reason, zap.String("pipeline", pipe), zap.String("stream", c.stream))
+	c.event(EventStreamDeleted, reason+", pipeline: "+pipe)
+
+	if !c.recreateStream {
+		c.log.Error("recreate_stream is disabled, pipeline won't consume until the stream and consumer are recreated", zap.String("pipeline", pipe))
+		return
+	}
+
+	// the subscriptions are replaced, serialized with the pipeline transitions
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	_, err := ensureStream(c.js, c.conn, c.log, c.streamOpts)
+	if err != nil {
+		c.log.Error("failed to recreate the stream", zap.String("pipeline", pipe), zap.Error(err))
+		return
+	}
+
+	if c.listening() {
+		// the consumer is gone, just remove the interest
+		c.unsubscribe()
+
+		err = c.listenerInit()
+		if err != nil {
+			c.log.Error("failed to recreate the consumer", zap.String("pipeline", pipe), zap.Error(err))
+			return
+		}
+	}
+
+	c.log.Warn("stream and consumer were recreated", zap.String("pipeline", pipe), zap.String("stream", c.stream))
+}
+
+// streamGone checks if the publish error was caused by the deleted stream
+func (c *Driver) streamGone(err error) bool {
+	if !stderr.Is(err, nats.ErrNoStreamResponse) {
+		return false
+	}
+
+	_, err = c.js.StreamInfo(c.stream)
+	return stderr.Is(err, nats.ErrStreamNotFound)
+}
@@ -0,0 +1,30 @@
+package natsjobs
+
+import (
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// redelivery counts the redelivered messages and reports the ones delivered redelivery_threshold times or more
+func (c *Driver) redelivery(item *Item, delivered uint64) {
+	if delivered <= 1 {
+		return
+	}
+
+	pipe := (*c.pipeline.Load()).Name()
+	c.metrics.redelivered(pipe)
+
+	if c.redeliveryThreshold == 0 || delivered < c.redeliveryThreshold {
+		return
+	}
+
+	c.log.Warn("message redelivery threshold reached, possible poison message",
+		zap.String("pipeline", pipe),
+		zap.String("id", item.ID()),
+		zap.String("job", item.Job),
+		zap.Uint64("delivered", delivered),
+		zap.Uint64("sequence", item.Options.seq),
+	)
+	c.event(EventRedeliveryThreshold, "message "+item.ID()+" delivered "+strconv.FormatUint(delivered, 10)+" times, pipeline: "+pipe)
+}
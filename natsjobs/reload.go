@@ -0,0 +1,137 @@
+package natsjobs
+
+import (
+	"time"
+
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// reloadOpts are the pipeline options applied by the Reload, the whole struct is swapped atomically
+// so the publishers and the consumers never see a half-applied configuration
+type reloadOpts struct {
+	subject    string
+	prefetch   int
+	ackWait    time.Duration
+	maxDeliver int
+}
+
+// Reload re-reads the pipeline configuration and applies the changed prefetch, ack_wait, max_deliver and subject
+// without restarting the pipeline. The consumers are updated in place, the stream subjects are reconciled
+// according to the update_stream option. The prefetch is applied as the consumer max_ack_pending only
+// with the backpressure enabled, the listener buffer keeps its size until the restart.
+// Pipelines declared at runtime are not reloaded.
+func (c *Driver) Reload() error {
+	const op = errors.Op("nats_reload")
+
+	if c.configKey == "" {
+		return nil
+	}
+
+	conf, err := readConfig(c.cfg, c.configKey)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	cur := c.opts.Load()
+	changes := make([]string, 0, 4)
+	if conf.Prefetch != cur.prefetch {
+		changes = append(changes, pipePrefetch)
+	}
+
+	if conf.AckWait != cur.ackWait {
+		changes = append(changes, pipeAckWait)
+	}
+
+	if conf.MaxDeliver != cur.maxDeliver {
+		changes = append(changes, pipeMaxDeliver)
+	}
+
+	subjectChanged := conf.Subject != cur.subject
+	if subjectChanged {
+		changes = append(changes, pipeSubject)
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if subjectChanged {
+		if len(c.lanes) > 0 {
+			return errors.E(op, errors.Str("subject can't be reloaded for the pipeline with the priority_map"))
+		}
+
+		so := *c.streamOpts
+		so.subject = conf.Subject
+
+		_, err = ensureStream(c.js, c.conn, c.log, &so)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		c.streamOpts = &so
+	}
+
+	opts := &reloadOpts{
+		subject:    conf.Subject,
+		prefetch:   conf.Prefetch,
+		ackWait:    conf.AckWait,
+		maxDeliver: conf.MaxDeliver,
+	}
+
+	// the options are swapped only when the consumers are updated, so the driver matches the server on errors
+	if c.listening() {
+		err = c.updateConsumers(opts, subjectChanged)
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+
+	c.opts.Store(opts)
+
+	c.log.Info("pipeline configuration reloaded", zap.String("pipeline", (*c.pipeline.Load()).Name()), zap.Strings("changed", changes))
+
+	return nil
+}
+
+// updateConsumers applies the pipeline options to the active consumers
+func (c *Driver) updateConsumers(opts *reloadOpts, subjectChanged bool) error {
+	subs := c.subscriptions()
+	for i := 0; i < len(subs); i++ {
+		ci, err := subs[i].ConsumerInfo()
+		if err != nil {
+			return err
+		}
+
+		cfg := ci.Config
+		if opts.ackWait > 0 {
+			cfg.AckWait = opts.ackWait
+		} else {
+			// server default
+			cfg.AckWait = time.Second * 30
+		}
+
+		if opts.maxDeliver != 0 {
+			cfg.MaxDeliver = opts.maxDeliver
+		}
+
+		if c.highWatermark > 0 {
+			cfg.MaxAckPending = c.maxAckPending()
+		}
+
+		// lanes and extra streams consumers keep their own filter subjects
+		if subjectChanged && ci.Stream == c.stream && len(c.lanes) == 0 {
+			cfg.FilterSubject = opts.subject
+		}
+
+		_, err = c.js.UpdateConsumer(ci.Stream, &cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
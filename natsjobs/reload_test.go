@@ -0,0 +1,97 @@
+package natsjobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfig is the configuration with the pipeline section, the set keys are reported by Has
+type testConfig struct {
+	pipeline config
+	set      map[string]bool
+}
+
+func (c *testConfig) UnmarshalKey(name string, out any) error {
+	conf := out.(**config)
+	if name == pluginName {
+		(*conf).Addr = nats.DefaultURL
+		return nil
+	}
+
+	pipe := c.pipeline
+	*conf = &pipe
+	return nil
+}
+
+func (c *testConfig) Has(name string) bool {
+	return c.set[name]
+}
+
+// newReloadDriver returns the running driver started from the configuration as FromConfig does
+func newReloadDriver(t *testing.T, cfg *testConfig) (*Driver, *fakeJS, *fakeSub) {
+	js := &fakeJS{}
+	c, _ := newTestDriver(t, js)
+	c.cfg = cfg
+	c.configKey = "jobs.pipelines.test.config"
+
+	conf, err := readConfig(cfg, c.configKey)
+	require.NoError(t, err)
+	c.opts.Store(&reloadOpts{subject: conf.Subject, prefetch: conf.Prefetch, ackWait: conf.AckWait, maxDeliver: conf.MaxDeliver})
+
+	sub := &fakeSub{info: &nats.ConsumerInfo{Stream: "jobs", Config: nats.ConsumerConfig{Durable: "test", AckWait: conf.AckWait}}}
+	subs := []subscription{sub}
+	c.subs.Store(&subs)
+	c.storeState(stateRunning)
+
+	return c, js, sub
+}
+
+// the profile defaults are not reported as the changed options
+func TestReloadKeepsProfileDefaults(t *testing.T) {
+	c, js, _ := newReloadDriver(t, &testConfig{pipeline: config{Profile: "throughput", Subject: "jobs.default"}})
+
+	assert.Equal(t, 1000, c.opts.Load().prefetch)
+	assert.Equal(t, time.Minute*5, c.opts.Load().ackWait)
+
+	require.NoError(t, c.Reload())
+	assert.Empty(t, js.updated)
+}
+
+func TestReloadUpdatesConsumers(t *testing.T) {
+	cfg := &testConfig{pipeline: config{Profile: "throughput", Subject: "jobs.default"}}
+	c, js, _ := newReloadDriver(t, cfg)
+
+	// the explicit option overrides the profile
+	cfg.pipeline.AckWait = time.Minute
+	cfg.set = map[string]bool{c.configKey + "." + pipeAckWait: true}
+
+	require.NoError(t, c.Reload())
+	require.Len(t, js.updated, 1)
+	assert.Equal(t, time.Minute, js.updated[0].AckWait)
+	assert.Equal(t, time.Minute, c.opts.Load().ackWait)
+	// the rest of the profile is kept
+	assert.Equal(t, 1000, c.opts.Load().prefetch)
+}
+
+func TestReloadKeepsOptionsOnUpdateError(t *testing.T) {
+	cfg := &testConfig{pipeline: config{Subject: "jobs.default"}}
+	c, js, _ := newReloadDriver(t, cfg)
+	before := c.opts.Load()
+
+	js.updateErr = nats.ErrJetStreamNotEnabled
+	cfg.pipeline.MaxDeliver = 5
+
+	err := c.Reload()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), nats.ErrJetStreamNotEnabled.Error())
+	// the driver options match the consumer on the server
+	assert.Same(t, before, c.opts.Load())
+
+	js.updateErr = nil
+	require.NoError(t, c.Reload())
+	assert.Equal(t, 5, c.opts.Load().maxDeliver)
+}
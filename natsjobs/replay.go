@@ -0,0 +1,66 @@
+package natsjobs
+
+import (
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// ReplayStatus reports the deliver_all replay progress
+type ReplayStatus struct {
+	Pipeline string `json:"pipeline"`
+	// Sequence is the stream sequence of the last consumed message
+	Sequence uint64 `json:"sequence"`
+	// Pending is the number of the messages left to replay
+	Pending uint64 `json:"pending"`
+	// Done is true once the consumer caught up with the stream
+	Done bool `json:"done"`
+}
+
+func validateDeliverPolicy(deliverAll, deliverNew, deliverLastPerSubject bool) error {
+	n := 0
+	for _, set := range [...]bool{deliverAll, deliverNew, deliverLastPerSubject} {
+		if set {
+			n++
+		}
+	}
+
+	if n > 1 {
+		return errors.Str("deliver_all, deliver_new and deliver_last_per_subject are mutually exclusive")
+	}
+
+	return nil
+}
+
+// replayProgress records the replay progress from the consumed message metadata
+// and notifies once the consumer caught up with the stream
+func (c *Driver) replayProgress(meta *nats.MsgMetadata) {
+	if !c.deliverAll {
+		return
+	}
+
+	c.replaySeq.Store(meta.Sequence.Stream)
+	c.replayPending.Store(meta.NumPending)
+
+	if meta.NumPending == 0 && c.replayDone.CompareAndSwap(false, true) {
+		pipe := (*c.pipeline.Load()).Name()
+		c.log.Info("replay completed, consumer caught up with the stream", zap.String("pipeline", pipe), zap.Uint64("sequence", meta.Sequence.Stream))
+		c.event(EventReplayCompleted, "replay completed at the stream sequence "+strconv.FormatUint(meta.Sequence.Stream, 10)+", pipeline: "+pipe)
+	}
+}
+
+// ReplayStatus returns the deliver_all replay progress, nil if the replay mode is disabled
+func (c *Driver) ReplayStatus() *ReplayStatus {
+	if !c.deliverAll {
+		return nil
+	}
+
+	return &ReplayStatus{
+		Pipeline: (*c.pipeline.Load()).Name(),
+		Sequence: c.replaySeq.Load(),
+		Pending:  c.replayPending.Load(),
+		Done:     c.replayDone.Load(),
+	}
+}
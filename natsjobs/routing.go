@@ -0,0 +1,76 @@
+package natsjobs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/roadrunner-server/errors"
+)
+
+// route binds the job name pattern to the subject the job is pushed to
+type route struct {
+	pattern string
+	subject string
+}
+
+// newRoutes validates the routing map, the more specific (longer) patterns are matched first
+func newRoutes(routing map[string]string) ([]route, error) {
+	routes := make([]route, 0, len(routing))
+	for pattern, subject := range routing {
+		if pattern == "" || subject == "" {
+			return nil, errors.Errorf("routing: job name pattern (%q) and subject (%q) should not be empty", pattern, subject)
+		}
+
+		if strings.ContainsAny(subject, "*>") {
+			return nil, errors.Errorf("routing: subject %s for the pattern %s should not contain wildcards", subject, pattern)
+		}
+
+		routes = append(routes, route{pattern: pattern, subject: subject})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if len(routes[i].pattern) != len(routes[j].pattern) {
+			return len(routes[i].pattern) > len(routes[j].pattern)
+		}
+
+		return routes[i].pattern < routes[j].pattern
+	})
+
+	return routes, nil
+}
+
+// routeSubject returns the subject of the first route matching the job name
+func (c *Driver) routeSubject(job string) (string, bool) {
+	for i := 0; i < len(c.routes); i++ {
+		if matchJob(c.routes[i].pattern, job) {
+			return c.routes[i].subject, true
+		}
+	}
+
+	return "", false
+}
+
+// matchJob matches the job name against the pattern, * matches any sequence of characters.
+// The backslashes of the PHP class names are matched literally.
+func matchJob(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for i := 1; i < len(parts)-1; i++ {
+		idx := strings.Index(name, parts[i])
+		if idx < 0 {
+			return false
+		}
+		name = name[idx+len(parts[i]):]
+	}
+
+	return len(name) >= len(last) && strings.HasSuffix(name, last)
+}
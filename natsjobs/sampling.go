@@ -0,0 +1,85 @@
+package natsjobs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// advisoryAckSample is the subject of the consumer ack sampling metrics
+const advisoryAckSample string = "$JS.EVENT.METRIC.CONSUMER.ACK."
+
+// ackSample is the JetStream consumer ack metric, sent for the sample_freq percent of the acks
+type ackSample struct {
+	Consumer string `json:"consumer"`
+	// AckTime is the time between the delivery and the ack in nanoseconds
+	AckTime    int64  `json:"ack_time"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+// validateSampleFreq checks the sample frequency, 1-100 with the optional % suffix
+func validateSampleFreq(freq string) error {
+	if freq == "" {
+		return nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSuffix(freq, "%"))
+	if err != nil || n < 1 || n > 100 {
+		return errors.Errorf("sample_freq should be a percentage in the [1..100] range, e.g. 10%%, got: %s", freq)
+	}
+
+	return nil
+}
+
+// applySampleFreq enables the ack sampling of the consumer, the client library doesn't support setting it on subscribe
+func (c *Driver) applySampleFreq(ci *nats.ConsumerInfo) {
+	if c.sampleFreq == "" || ci.Config.SampleFrequency == c.sampleFreq {
+		return
+	}
+
+	cfg := ci.Config
+	cfg.SampleFrequency = c.sampleFreq
+
+	_, err := c.js.UpdateConsumer(ci.Stream, &cfg)
+	if err != nil {
+		c.log.Warn("failed to enable the consumer ack sampling", zap.String("consumer", ci.Name), zap.Error(err))
+	}
+}
+
+// watchAckSamples records the sampled server-side ack latencies of the pipeline consumers
+func (c *Driver) watchAckSamples() {
+	if c.sampleFreq == "" || c.metrics == nil {
+		return
+	}
+
+	pipe := (*c.pipeline.Load()).Name()
+	streams := []string{c.stream}
+	for i := 0; i < len(c.extraStreams); i++ {
+		streams = append(streams, c.extraStreams[i].stream)
+	}
+
+	for i := 0; i < len(streams); i++ {
+		_, err := c.conn.Subscribe(advisoryAckSample+streams[i]+".*", func(m *nats.Msg) {
+			var s ackSample
+			err := json.Unmarshal(m.Data, &s)
+			if err != nil {
+				return
+			}
+
+			names, _ := c.consumerNames.Load().(map[string]struct{})
+			if _, ok := names[s.Consumer]; !ok {
+				return
+			}
+
+			c.metrics.ackSample(pipe, time.Duration(s.AckTime), s.Deliveries)
+		})
+		if err != nil {
+			c.log.Warn("failed to subscribe to the consumer ack samples", zap.String("stream", streams[i]), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package natsjobs
+
+import (
+	"context"
+	stderr "errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// schedule is the recurring job published by the pipeline
+type schedule struct {
+	// Name of the schedule, should be unique within the pipeline
+	Name string `mapstructure:"name"`
+	// Cron is the standard 5 fields cron expression, CRON_TZ=<zone> prefix and @every <duration> are supported
+	Cron string `mapstructure:"cron"`
+	// Job is the job name passed to the worker
+	Job string `mapstructure:"job"`
+	// Payload of the job
+	Payload string `mapstructure:"payload"`
+	// Headers of the job
+	Headers map[string][]string `mapstructure:"headers"`
+	// Subject to publish the job to, default - the pipeline subject
+	Subject string `mapstructure:"subject"`
+	// Priority of the job, default - the pipeline priority
+	Priority int64 `mapstructure:"priority"`
+
+	spec cron.Schedule
+}
+
+// ttl of the tick locks, should be greater than the clock skew between the instances
+const scheduleLockTTL time.Duration = time.Hour * 24
+
+func parseSchedules(schedules []*schedule, bucket string) error {
+	if len(schedules) == 0 {
+		return nil
+	}
+
+	if bucket == "" {
+		return errors.Str("schedule_bucket should be set for the schedules")
+	}
+
+	names := make(map[string]struct{}, len(schedules))
+	for i := 0; i < len(schedules); i++ {
+		s := schedules[i]
+		if s.Name == "" || s.Job == "" {
+			return errors.Errorf("schedule #%d: name and job should be set", i)
+		}
+
+		if _, ok := names[s.Name]; ok {
+			return errors.Errorf("duplicate schedule name: %s", s.Name)
+		}
+		names[s.Name] = struct{}{}
+
+		spec, err := cron.ParseStandard(s.Cron)
+		if err != nil {
+			return errors.Errorf("schedule %s: invalid cron expression %q: %v", s.Name, s.Cron, err)
+		}
+
+		// @every is relative to the instance start time, the ticks are aligned to the interval so every instance
+		// computes the same tick and only one of them acquires the lock
+		if every, ok := spec.(cron.ConstantDelaySchedule); ok {
+			spec = alignedSchedule{every: every.Delay}
+		}
+
+		s.spec = spec
+	}
+
+	return nil
+}
+
+// alignedSchedule fires on the multiples of the interval
+type alignedSchedule struct {
+	every time.Duration
+}
+
+func (a alignedSchedule) Next(t time.Time) time.Time {
+	return t.Truncate(a.every).Add(a.every)
+}
+
+// initScheduleLocks creates (or binds to) the KV bucket used to elect the instance publishing the schedule tick
+func initScheduleLocks(js bucketAdmin, bucket string) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(bucket)
+	if err == nil {
+		return kv, nil
+	}
+
+	if !stderr.Is(err, nats.ErrBucketNotFound) {
+		return nil, err
+	}
+
+	return js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "RoadRunner schedules locks",
+		TTL:         scheduleLockTTL,
+	})
+}
+
+// startSchedules publishes the recurring jobs until the driver is stopped. Every instance runs the schedules,
+// the instance which creates the tick key in the KV bucket first publishes the job.
+func (c *Driver) startSchedules(schedules []*schedule, locks nats.KeyValue, priority int64) {
+	if len(schedules) == 0 {
+		return
+	}
+
+	owner, _ := os.Hostname()
+	owner += ":" + strconv.Itoa(os.Getpid())
+
+	for i := 0; i < len(schedules); i++ {
+		s := schedules[i]
+		if s.Priority == 0 {
+			s.Priority = priority
+		}
+
+		if s.Subject == "" {
+			s.Subject = c.opts.Load().subject
+		}
+
+		go func() {
+			for {
+				next := s.spec.Next(time.Now())
+				if next.IsZero() {
+					c.log.Warn("schedule has no next activation time", zap.String("schedule", s.Name))
+					return
+				}
+
+				timer := time.NewTimer(time.Until(next))
+				select {
+				case <-timer.C:
+					c.fire(s, locks, owner, next)
+				case <-c.closeCh:
+					timer.Stop()
+					return
+				}
+			}
+		}()
+	}
+}
+
+// fire publishes the schedule job if the tick lock is acquired
+func (c *Driver) fire(s *schedule, locks nats.KeyValue, owner string, tick time.Time) {
+	tickID := strconv.FormatInt(tick.Unix(), 10)
+
+	_, err := locks.Create(uniqueKey(s.Name)+"."+tickID, []byte(owner))
+	if err != nil {
+		if !stderr.Is(err, nats.ErrKeyExists) {
+			c.log.Error("failed to acquire the schedule lock", zap.String("schedule", s.Name), zap.Error(err))
+		}
+
+		// the tick is published by another instance
+		return
+	}
+
+	id := s.Name + "-" + tickID
+	buf, err := c.pools.marshal(&Item{
+		Job:     s.Job,
+		Ident:   id,
+		Payload: s.Payload,
+		Headers: s.Headers,
+		Options: &Options{
+			Priority: s.Priority,
+			Pipeline: (*c.pipeline.Load()).Name(),
+		},
+	})
+	if err != nil {
+		c.log.Error("failed to marshal the scheduled job", zap.String("schedule", s.Name), zap.Error(err))
+		return
+	}
+
+	// the deterministic message ID protects from the duplicates within the stream duplicates window
+	_, err = c.publish(context.Background(), s.Subject, buf.Bytes(), withMsgID(nil, id))
+	c.pools.putBuffer(buf)
+	if err != nil {
+		c.log.Error("failed to publish the scheduled job", zap.String("schedule", s.Name), zap.Time("tick", tick), zap.Error(err))
+		return
+	}
+
+	c.log.Debug("scheduled job published", zap.String("schedule", s.Name), zap.String("id", id))
+}
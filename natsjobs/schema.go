@@ -0,0 +1,294 @@
+package natsjobs
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	schemaDraft string = "http://json-schema.org/draft-07/schema#"
+
+	// struct tags
+	tagKey    string = "mapstructure"
+	tagScope  string = "scope"
+	tagServer string = "server"
+
+	scopeGlobal string = "global"
+)
+
+// pipeline option kinds, the way FromPipeline reads the option
+const (
+	optString   string = "string"
+	optInt      string = "integer"
+	optBool     string = "boolean"
+	optDuration string = "duration"
+	optList     string = "array"
+	optMap      string = "object"
+)
+
+// pipelineOption is the option of the pipeline declared via the jobs plugin (FromPipeline)
+type pipelineOption struct {
+	key  string
+	kind string
+	// default used by FromPipeline, nil if it's derived from the other options
+	def any
+	// minimal nats-server version
+	server string
+}
+
+// pipelineOptions are the keys read by FromPipeline with their defaults, the order follows the pipeXxx consts
+var pipelineOptions = [...]pipelineOption{
+	{key: pipeSubject, kind: optString, def: "default"},
+	{key: pipeStream, kind: optString, def: "default-stream"},
+	{key: pipePrefetch, kind: optInt, def: 100},
+	{key: pipeDeleteAfterAck, kind: optBool, def: false},
+	{key: pipeDeliverNew, kind: optBool, def: false},
+	{key: pipeDeliverLastPerSubject, kind: optBool, def: false},
+	{key: pipeRateLimit, kind: optInt, def: 1000},
+	{key: pipeDeleteStreamOnStop, kind: optBool, def: false},
+	{key: pipeConsumeAll, kind: optBool, def: false},
+	{key: pipeTermOnNack, kind: optBool, def: false},
+	{key: pipeDLQSubject, kind: optString, def: ""},
+	{key: pipeProgressInterval, kind: optDuration, def: "0s"},
+	{key: pipeCanarySubject, kind: optString, def: ""},
+	{key: pipeCanaryWeight, kind: optInt, def: 0},
+	{key: pipeRequeueRepublish, kind: optBool, def: false},
+	{key: pipeIDGenerator, kind: optString, def: nil},
+	{key: pipeJobName, kind: optString, def: auto},
+	{key: pipeDefaultPriority, kind: optInt, def: 10},
+	{key: pipeJobSubjects, kind: optMap, def: nil},
+	{key: pipeRawPayload, kind: optString, def: ""},
+	{key: pipeTTL, kind: optDuration, def: "0s"},
+	{key: pipeServerTTL, kind: optBool, def: false, server: "2.11.0"},
+	{key: pipeIdleHeartbeat, kind: optDuration, def: "0s"},
+	{key: pipeFlowControl, kind: optBool, def: false},
+	{key: pipeSlowConsumerReduce, kind: optBool, def: false},
+	{key: pipeConsumerReplicas, kind: optInt, def: 0},
+	{key: pipeInactiveThreshold, kind: optDuration, def: "0s"},
+	{key: pipeDescription, kind: optString, def: ""},
+	{key: pipeDurable, kind: optString, def: ""},
+	{key: pipeEphemeral, kind: optBool, def: false},
+	{key: pipeAckWait, kind: optDuration, def: "0s"},
+	{key: pipeMaxDeliver, kind: optInt, def: 0},
+	{key: pipeConsumerUpdate, kind: optBool, def: false},
+	{key: pipeUpdateStream, kind: optBool, def: false},
+	{key: pipeRecreateStream, kind: optBool, def: false},
+	{key: pipePriorityHeader, kind: optString, def: ""},
+	{key: pipePriorityMap, kind: optMap, def: nil},
+	{key: pipeWorkers, kind: optInt, def: 1},
+	{key: pipeQueueHighWatermark, kind: optInt, def: 0},
+	{key: pipeQueueLowWatermark, kind: optInt, def: 0},
+	{key: pipeOutboxSize, kind: optInt, def: 0},
+	{key: pipeOutboxOverflow, kind: optString, def: ""},
+	{key: pipePublishRetries, kind: optInt, def: 0},
+	{key: pipePublishRetryBackoff, kind: optDuration, def: "100ms"},
+	{key: pipeBreakerThreshold, kind: optInt, def: 0},
+	{key: pipeBreakerProbeInterval, kind: optDuration, def: "5s"},
+	{key: pipeUniqueJobs, kind: optBool, def: false},
+	{key: pipeKVBucket, kind: optString, def: ""},
+	{key: pipeUniqueTTL, kind: optDuration, def: "1h0m0s"},
+	{key: pipeMaxInlinePayload, kind: optInt, def: 0},
+	{key: pipeObjectBucket, kind: optString, def: ""},
+	{key: pipeSchemaFile, kind: optString, def: ""},
+	{key: pipeHeaders, kind: optMap, def: nil},
+	{key: pipeMirror, kind: optString, def: ""},
+	{key: pipeSources, kind: optList, def: nil},
+	{key: pipeSourceDomain, kind: optString, def: ""},
+	{key: pipeSourceAPIPrefix, kind: optString, def: ""},
+	{key: pipeSourceDeliverPrefix, kind: optString, def: ""},
+	{key: pipeRePublishSource, kind: optString, def: ""},
+	{key: pipeRePublishDestination, kind: optString, def: ""},
+	{key: pipeRePublishHeadersOnly, kind: optBool, def: false},
+	{key: pipeStateCacheTTL, kind: optDuration, def: "1s"},
+	{key: pipeDeliverAll, kind: optBool, def: false},
+	{key: pipeAckPolicy, kind: optString, def: ackPolicyExplicit},
+	{key: pipePull, kind: optBool, def: false},
+	{key: pipeMaxWaiting, kind: optInt, def: 0},
+	{key: pipeFetchBatch, kind: optInt, def: nil},
+	{key: pipeFetchTimeout, kind: optDuration, def: "5s"},
+	{key: pipeJobsPerSecond, kind: optInt, def: 0},
+	{key: pipeJobsBurst, kind: optInt, def: 0},
+	{key: pipeArchiveSubject, kind: optString, def: ""},
+	{key: pipeArchiveBatch, kind: optInt, def: 100},
+	{key: pipeArchiveInterval, kind: optDuration, def: "1s"},
+	{key: pipeRedeliveryThreshold, kind: optInt, def: 0},
+	{key: pipeBroadcastSubjects, kind: optList, def: nil},
+	{key: pipeRouting, kind: optMap, def: nil},
+	{key: pipeCompression, kind: optString, def: "", server: "2.10.0"},
+	{key: pipePlacementCluster, kind: optString, def: ""},
+	{key: pipePlacementTags, kind: optList, def: nil},
+	{key: pipeSubjectTransformSource, kind: optString, def: "", server: "2.10.0"},
+	{key: pipeSubjectTransformDestination, kind: optString, def: "", server: "2.10.0"},
+	{key: pipeAllowRollupHdrs, kind: optBool, def: false},
+	{key: pipeDenyDelete, kind: optBool, def: false},
+	{key: pipeDenyPurge, kind: optBool, def: false},
+	{key: pipeHeadersOnly, kind: optBool, def: false},
+	{key: pipeSampleFreq, kind: optString, def: ""},
+	{key: pipeMicroService, kind: optString, def: ""},
+	{key: pipeMicroVersion, kind: optString, def: "1.0.0"},
+	{key: pipeResultSubject, kind: optString, def: ""},
+	{key: pipeStatusBucket, kind: optString, def: ""},
+	{key: pipeStatusTTL, kind: optDuration, def: "24h0m0s"},
+	{key: pipeConsumerPause, kind: optBool, def: false, server: "2.11.0"},
+	{key: pipePauseDeadline, kind: optDuration, def: "0s"},
+	{key: pipeDrainTimeout, kind: optDuration, def: "30s"},
+	{key: pipeAccountCreds, kind: optString, def: ""},
+	{key: pipeFormat, kind: optString, def: formatJSON},
+	{key: pipeCompat, kind: optString, def: ""},
+	{key: pipeContentTypes, kind: optMap, def: nil},
+	{key: pipeForwardHeaders, kind: optList, def: nil},
+	{key: pipeDropHeaders, kind: optList, def: nil},
+	{key: pipeRealtimeSubjects, kind: optList, def: nil},
+	{key: pipeInsertTimeout, kind: optDuration, def: "0s"},
+	{key: pipeQueueCapacity, kind: optInt, def: defaultQueueCapacity},
+	{key: pipeMiddleware, kind: optList, def: nil},
+	{key: pipeAckStrategy, kind: optString, def: ""},
+	{key: pipeAckMaxRetries, kind: optInt, def: 0},
+	{key: pipeAckBackoff, kind: optDuration, def: "1s"},
+	{key: pipeAckMaxBackoff, kind: optDuration, def: "1m0s"},
+	{key: pipeMaxLag, kind: optInt, def: 0},
+	{key: pipeLagCheckInterval, kind: optDuration, def: "10s"},
+	{key: pipeAdaptivePrefetch, kind: optBool, def: false},
+	{key: pipePrefetchMin, kind: optInt, def: 1},
+	{key: pipePrefetchMax, kind: optInt, def: nil},
+	{key: pipeAdaptiveInterval, kind: optDuration, def: "10s"},
+	{key: pipePriorityGroup, kind: optString, def: ""},
+	{key: pipePriorityMinPending, kind: optInt, def: 0},
+	{key: pipePriorityMinAckPending, kind: optInt, def: 0},
+	{key: pipeDeliverGroup, kind: optString, def: ""},
+	{key: pipeProfile, kind: optString, def: ""},
+	{key: pipeOnDecodeError, kind: optString, def: nil},
+	{key: pipeDeleteBatch, kind: optInt, def: 0},
+	{key: pipeDeleteInterval, kind: optDuration, def: "100ms"},
+	{key: pipeEraseAfterAck, kind: optBool, def: false},
+}
+
+// Schema returns the JSON schema of the global and pipeline options. The global options are generated
+// from the configuration structs (defaults from the InitDefaults), the pipeline options from the FromPipeline keys.
+// plugin is the plugin-level (global) configuration struct.
+func Schema(plugin any) ([]byte, error) {
+	conf := &config{}
+	conf.InitDefaults()
+
+	global := make(map[string]any)
+
+	schemaProperties(reflect.ValueOf(plugin), global, global)
+	// the pipeline scoped fields of the config are described by the pipelineOptions
+	schemaProperties(reflect.ValueOf(conf).Elem(), global, make(map[string]any))
+
+	return json.Marshal(map[string]any{
+		"$schema": schemaDraft,
+		"title":   pluginName,
+		"type":    "object",
+		"properties": map[string]any{
+			scopeGlobal: map[string]any{
+				"type":       "object",
+				"properties": global,
+			},
+			"pipeline": map[string]any{
+				"type":       "object",
+				"properties": pipelineProperties(),
+			},
+		},
+	})
+}
+
+func pipelineProperties() map[string]any {
+	props := make(map[string]any, len(pipelineOptions))
+	for i := 0; i < len(pipelineOptions); i++ {
+		o := pipelineOptions[i]
+
+		var prop map[string]any
+		switch o.kind {
+		case optDuration:
+			prop = map[string]any{"type": "string", "format": "duration"}
+		case optList:
+			prop = map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+		default:
+			prop = map[string]any{"type": o.kind}
+		}
+
+		if o.def != nil {
+			prop["default"] = o.def
+		}
+
+		if o.server != "" {
+			prop["x-nats-server"] = o.server
+		}
+
+		props[o.key] = prop
+	}
+
+	return props
+}
+
+func schemaProperties(v reflect.Value, global, pipeline map[string]any) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get(tagKey)
+		if key == "" || key == "-" {
+			continue
+		}
+
+		prop := schemaType(f.Type)
+		prop["default"] = schemaDefault(v.Field(i))
+		if srv := f.Tag.Get(tagServer); srv != "" {
+			prop["x-nats-server"] = srv
+		}
+
+		if f.Tag.Get(tagScope) == scopeGlobal {
+			global[key] = prop
+			continue
+		}
+
+		pipeline[key] = prop
+	}
+}
+
+func schemaType(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]any{"type": "string", "format": "duration"}
+	}
+
+	switch t.Kind() { //nolint:exhaustive
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaType(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaType(t.Elem())}
+	case reflect.Ptr:
+		return schemaType(t.Elem())
+	case reflect.Struct:
+		props := make(map[string]any)
+		schemaProperties(reflect.New(t).Elem(), props, props)
+		return map[string]any{"type": "object", "properties": props}
+	default:
+		return map[string]any{}
+	}
+}
+
+func schemaDefault(v reflect.Value) any {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() { //nolint:exhaustive
+	case reflect.Map, reflect.Slice, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+	}
+
+	return v.Interface()
+}
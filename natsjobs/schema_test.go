@@ -0,0 +1,221 @@
+package natsjobs
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeConsts returns the pipeXxx consts declared in the config.go: name -> key
+func pipeConsts(t *testing.T) map[string]string {
+	t.Helper()
+
+	f, err := parser.ParseFile(token.NewFileSet(), "config.go", nil, 0)
+	require.NoError(t, err)
+
+	consts := make(map[string]string)
+	ast.Inspect(f, func(n ast.Node) bool {
+		vs, ok := n.(*ast.ValueSpec)
+		if !ok || len(vs.Names) != 1 || len(vs.Values) != 1 || !strings.HasPrefix(vs.Names[0].Name, "pipe") {
+			return true
+		}
+
+		lit, ok := vs.Values[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+
+		key, err := strconv.Unquote(lit.Value)
+		require.NoError(t, err)
+		consts[vs.Names[0].Name] = key
+		return true
+	})
+
+	return consts
+}
+
+func TestPipelineOptionsCoverConsts(t *testing.T) {
+	consts := pipeConsts(t)
+	require.NotEmpty(t, consts)
+
+	seen := make(map[string]int, len(pipelineOptions))
+	for i := 0; i < len(pipelineOptions); i++ {
+		seen[pipelineOptions[i].key]++
+	}
+
+	for name, key := range consts {
+		assert.Equalf(t, 1, seen[key], "%s (%s) should be described exactly once", name, key)
+	}
+
+	assert.Len(t, seen, len(consts))
+}
+
+// durationExpr evaluates the time.Unit, time.Unit*N and 0 default expressions
+func durationExpr(e ast.Expr) (time.Duration, bool) {
+	units := map[string]time.Duration{
+		"Millisecond": time.Millisecond,
+		"Second":      time.Second,
+		"Minute":      time.Minute,
+		"Hour":        time.Hour,
+	}
+
+	switch v := e.(type) {
+	case *ast.BasicLit:
+		n, err := strconv.Atoi(v.Value)
+		return time.Duration(n), err == nil
+	case *ast.SelectorExpr:
+		d, ok := units[v.Sel.Name]
+		return d, ok
+	case *ast.BinaryExpr:
+		l, okL := durationExpr(v.X)
+		r, okR := durationExpr(v.Y)
+		return l * r, okL && okR && v.Op == token.MUL
+	default:
+		return 0, false
+	}
+}
+
+// literal returns the value of the string, int and bool literals
+func literal(e ast.Expr) (any, bool) {
+	switch v := e.(type) {
+	case *ast.BasicLit:
+		switch v.Kind { //nolint:exhaustive
+		case token.STRING:
+			s, err := strconv.Unquote(v.Value)
+			return s, err == nil
+		case token.INT:
+			n, err := strconv.Atoi(v.Value)
+			return n, err == nil
+		}
+	case *ast.Ident:
+		switch v.Name {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+
+	return nil, false
+}
+
+// TestPipelineOptionsDefaults checks the kinds and the literal defaults against the FromPipeline reads
+func TestPipelineOptionsDefaults(t *testing.T) {
+	consts := pipeConsts(t)
+
+	options := make(map[string]pipelineOption, len(pipelineOptions))
+	for i := 0; i < len(pipelineOptions); i++ {
+		options[pipelineOptions[i].key] = pipelineOptions[i]
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), "driver.go", nil, 0)
+	require.NoError(t, err)
+
+	var from *ast.FuncDecl
+	for _, d := range f.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fd.Name.Name == "FromPipeline" {
+			from = fd
+		}
+	}
+	require.NotNil(t, from)
+
+	kinds := map[string]string{
+		"String": optString,
+		"Int":    optInt,
+		"Bool":   optBool,
+		"Map":    optMap,
+	}
+
+	checked := 0
+	ast.Inspect(from, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		var kind string
+		var args []ast.Expr
+		switch fn := call.Fun.(type) {
+		case *ast.SelectorExpr:
+			recv, ok := fn.X.(*ast.Ident)
+			if !ok || recv.Name != "pipe" || kinds[fn.Sel.Name] == "" {
+				return true
+			}
+			kind, args = kinds[fn.Sel.Name], call.Args
+		case *ast.Ident:
+			switch fn.Name {
+			case "pipeDuration":
+				kind = optDuration
+			case "pipeList":
+				kind = optList
+			default:
+				return true
+			}
+			args = call.Args[1:]
+		default:
+			return true
+		}
+
+		name, ok := args[0].(*ast.Ident)
+		if !ok {
+			return true
+		}
+
+		key, ok := consts[name.Name]
+		require.Truef(t, ok, "%s isn't the pipeline option const", name.Name)
+		o, ok := options[key]
+		require.Truef(t, ok, "%s isn't described", key)
+		assert.Equalf(t, kind, o.kind, "%s kind", key)
+
+		if len(args) < 2 {
+			return true
+		}
+
+		switch kind {
+		case optDuration:
+			if d, ok := durationExpr(args[1]); ok {
+				assert.Equalf(t, d.String(), o.def, "%s default", key)
+			}
+		case optMap:
+		default:
+			if v, ok := literal(args[1]); ok {
+				assert.Equalf(t, v, o.def, "%s default", key)
+			}
+		}
+
+		checked++
+		return true
+	})
+
+	assert.Greater(t, checked, len(consts)/2)
+}
+
+func TestSchemaPipelineKeys(t *testing.T) {
+	data, err := Schema(struct{}{})
+	require.NoError(t, err)
+
+	var schema struct {
+		Properties struct {
+			Pipeline struct {
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"pipeline"`
+		} `json:"properties"`
+	}
+	require.NoError(t, json.Unmarshal(data, &schema))
+
+	props := schema.Properties.Pipeline.Properties
+	assert.Len(t, props, len(pipelineOptions))
+	assert.EqualValues(t, 100, props[pipePrefetch]["default"])
+	assert.Contains(t, props, pipePlacementCluster)
+	assert.Contains(t, props, pipeSubjectTransformSource)
+	assert.NotContains(t, props, "placement")
+	assert.Equal(t, "2.10.0", props[pipeCompression]["x-nats-server"])
+}
@@ -0,0 +1,27 @@
+package natsjobs
+
+import (
+	"github.com/roadrunner-server/sdk/v4/events"
+)
+
+// Shared contains the plugin-level state shared by all NATS pipelines
+type Shared struct {
+	// Limiter limits the in-flight messages across all pipelines, might be nil
+	Limiter *Limiter
+	// Accounts run a single account watcher per JetStream account, might be nil
+	Accounts *Accounts
+	// StopOrder orders the pipelines shutdown by priority, might be nil
+	StopOrder *StopOrder
+	// IDGenerators are the ID generators provided by other plugins
+	IDGenerators map[string]IDGenerator
+	// Middlewares are the job middlewares provided by other plugins
+	Middlewares map[string]Middleware
+	// AckStrategies are the ack strategies provided by other plugins
+	AckStrategies map[string]AckStrategy
+	// Metrics are the driver metrics, might be nil
+	Metrics *Metrics
+	// Events is the RR events bus, might be nil
+	Events *events.Bus
+	// EmbeddedURL is the URL of the embedded NATS server, overrides the addr if set
+	EmbeddedURL string
+}
@@ -0,0 +1,184 @@
+package natsjobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// used when the Stop context has no deadline
+	defaultStopTimeout time.Duration = time.Second * 30
+	inflightPollPeriod time.Duration = time.Millisecond * 100
+)
+
+// StopOrder orders the pipelines shutdown by priority. The least important pipelines (bigger priority value) are
+// stopped first and get the smallest part of the stop deadline, the most important ones are stopped last and
+// get the bulk of it to finish the in-flight jobs.
+type StopOrder struct {
+	mu      sync.Mutex
+	members map[*stopMember]struct{}
+	// schedule shared by the concurrently stopped pipelines, set by the first Stop call and reset when the last
+	// of them is stopped, so the pipelines destroyed at runtime don't shift the next shutdown
+	start    time.Time
+	total    time.Duration
+	stopping int
+}
+
+type stopMember struct {
+	order    *StopOrder
+	priority int64
+	// the member is waiting for its slot or stopping, guarded by the order mutex
+	stopping bool
+	once     sync.Once
+	doneCh   chan struct{}
+}
+
+func NewStopOrder() *StopOrder {
+	return &StopOrder{
+		members: make(map[*stopMember]struct{}),
+	}
+}
+
+func (s *StopOrder) register(priority int64) *stopMember {
+	if s == nil {
+		return nil
+	}
+
+	m := &stopMember{
+		order:    s,
+		priority: priority,
+		doneCh:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.members[m] = struct{}{}
+	s.mu.Unlock()
+
+	return m
+}
+
+// wait blocks until all less important pipelines are stopped or their part of the deadline is elapsed.
+// It returns the deadline for the pipeline to finish its in-flight jobs.
+func (s *StopOrder) wait(ctx context.Context, m *stopMember) time.Time {
+	if s == nil || m == nil {
+		if dl, ok := ctx.Deadline(); ok {
+			return dl
+		}
+
+		return time.Now().Add(defaultStopTimeout)
+	}
+
+	s.mu.Lock()
+	if s.start.IsZero() {
+		s.start = time.Now()
+		s.total = defaultStopTimeout
+		if dl, ok := ctx.Deadline(); ok {
+			s.total = time.Until(dl)
+		}
+	}
+
+	if !m.stopping {
+		m.stopping = true
+		s.stopping++
+	}
+
+	// distinct priorities, the least important first
+	prios := make([]int64, 0, len(s.members))
+	seen := make(map[int64]struct{}, len(s.members))
+	waitFor := make([]chan struct{}, 0, len(s.members))
+	for mm := range s.members {
+		if mm.priority > m.priority {
+			waitFor = append(waitFor, mm.doneCh)
+		}
+
+		if _, ok := seen[mm.priority]; !ok {
+			seen[mm.priority] = struct{}{}
+			prios = append(prios, mm.priority)
+		}
+	}
+
+	sort.Slice(prios, func(i, j int) bool {
+		return prios[i] > prios[j]
+	})
+
+	rank := sort.Search(len(prios), func(i int) bool {
+		return prios[i] <= m.priority
+	})
+
+	startAt := s.slot(len(prios), rank)
+	deadline := s.slot(len(prios), rank+1)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(startAt))
+	defer timer.Stop()
+
+	for i := 0; i < len(waitFor); i++ {
+		select {
+		case <-waitFor[i]:
+		case <-timer.C:
+			return deadline
+		case <-ctx.Done():
+			return deadline
+		}
+	}
+
+	return deadline
+}
+
+// slot returns the end of the k-th rank slot, the slot size grows linearly with the rank,
+// so the most important pipelines get the biggest part of the deadline
+func (s *StopOrder) slot(n, k int) time.Time {
+	if n == 0 {
+		return s.start.Add(s.total)
+	}
+
+	share := float64(k*(k+1)) / float64(n*(n+1))
+	return s.start.Add(time.Duration(float64(s.total) * share))
+}
+
+// done releases the less important pipelines slots and removes the stopped pipeline from the order
+func (m *stopMember) done() {
+	if m == nil {
+		return
+	}
+
+	m.once.Do(func() {
+		close(m.doneCh)
+		m.order.unregister(m)
+	})
+}
+
+func (s *StopOrder) unregister(m *stopMember) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.members, m)
+	if !m.stopping {
+		return
+	}
+
+	s.stopping--
+	if s.stopping == 0 {
+		s.start = time.Time{}
+		s.total = 0
+	}
+}
+
+// waitInflight waits for the in-flight jobs of the pipeline to be acked/nacked until the deadline
+func (c *Driver) waitInflight(deadline time.Time) {
+	ticker := time.NewTicker(inflightPollPeriod)
+	defer ticker.Stop()
+
+	for c.inflight.Load() > 0 {
+		if time.Now().After(deadline) {
+			c.log.Warn("stop deadline exceeded, in-flight jobs abandoned", zap.Int64("abandoned", c.inflight.Load()))
+			return
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,87 @@
+package natsjobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStopOrderPriorities(t *testing.T) {
+	s := NewStopOrder()
+	important, regular := s.register(1), s.register(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var importantDL time.Time
+	stopped := make(chan struct{})
+	go func() {
+		importantDL = s.wait(ctx, important)
+		important.done()
+		close(stopped)
+	}()
+
+	// the important pipeline waits for the less important one
+	select {
+	case <-stopped:
+		t.Fatal("important pipeline stopped first")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	regularDL := s.wait(ctx, regular)
+	regular.done()
+	<-stopped
+
+	assert.True(t, regularDL.Before(importantDL))
+	assert.Empty(t, s.members)
+}
+
+// the pipeline destroyed at runtime doesn't affect the schedule of the shutdown
+func TestStopOrderRuntimeStop(t *testing.T) {
+	s := NewStopOrder()
+	important, regular, destroyed := s.register(1), s.register(5), s.register(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	s.wait(ctx, destroyed)
+	destroyed.done()
+	cancel()
+
+	s.mu.Lock()
+	assert.Len(t, s.members, 2)
+	assert.True(t, s.start.IsZero())
+	s.mu.Unlock()
+
+	// the first schedule is over
+	time.Sleep(time.Millisecond * 150)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	deadlines := make(map[*stopMember]time.Time, 2)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, m := range []*stopMember{important, regular} {
+		wg.Add(1)
+		go func(m *stopMember) {
+			defer wg.Done()
+			dl := s.wait(ctx, m)
+			mu.Lock()
+			deadlines[m] = dl
+			mu.Unlock()
+			m.done()
+		}(m)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	require.Len(t, deadlines, 2)
+	// two priority tiers: a third and the whole of the deadline
+	assert.True(t, deadlines[regular].After(now), "no drain time for the regular pipeline")
+	assert.WithinDuration(t, now.Add(time.Second/3), deadlines[regular], time.Millisecond*200)
+	assert.WithinDuration(t, now.Add(time.Second), deadlines[important], time.Millisecond*200)
+	assert.Empty(t, s.members)
+}
@@ -0,0 +1,73 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const (
+	// min interval between the automatic prefetch reductions
+	prefetchReduceInterval time.Duration = time.Second * 10
+)
+
+// asyncError handles the asynchronous subscription errors
+func (c *Driver) asyncError(sub *nats.Subscription, err error) {
+	pipe := (*c.pipeline.Load()).Name()
+
+	var subject string
+	if sub != nil {
+		subject = sub.Subject
+	}
+
+	switch {
+	case stderr.Is(err, nats.ErrSlowConsumer):
+		var dropped int
+		if sub != nil {
+			dropped, _ = sub.Dropped()
+		}
+
+		c.metrics.slowConsumer(pipe)
+		c.event(EventSlowConsumer, "slow consumer, subject: "+subject+", pipeline: "+pipe)
+		c.log.Warn("slow consumer, messages were dropped and will be redelivered after the ack wait", zap.String("pipeline", pipe), zap.String("subject", subject), zap.Int("dropped", dropped))
+
+		if c.slowConsumerReduce && sub != nil {
+			go c.reducePrefetch(sub)
+		}
+	case stderr.Is(err, nats.ErrConsumerNotActive):
+		c.log.Error("push consumer missed the idle heartbeats, delivery might be dead", zap.String("pipeline", pipe), zap.String("subject", subject), zap.Error(err))
+	default:
+		c.log.Error("nats async error", zap.String("pipeline", pipe), zap.String("subject", subject), zap.Error(err))
+	}
+}
+
+// reducePrefetch halves the consumer MaxAckPending, so the server pushes fewer messages than the listener can handle
+func (c *Driver) reducePrefetch(sub *nats.Subscription) {
+	last := c.lastReduce.Load()
+	now := time.Now().UnixNano()
+	if now-last < int64(prefetchReduceInterval) || !c.lastReduce.CompareAndSwap(last, now) {
+		return
+	}
+
+	ci, err := sub.ConsumerInfo()
+	if err != nil {
+		c.log.Error("failed to get the consumer info", zap.Error(err))
+		return
+	}
+
+	cfg := ci.Config
+	if cfg.MaxAckPending <= 1 {
+		return
+	}
+
+	cfg.MaxAckPending /= 2
+	_, err = c.js.UpdateConsumer(ci.Stream, &cfg)
+	if err != nil {
+		c.log.Error("failed to reduce the consumer max_ack_pending", zap.String("consumer", ci.Name), zap.Error(err))
+		return
+	}
+
+	c.log.Warn("consumer max_ack_pending reduced because of the slow consumer", zap.String("consumer", ci.Name), zap.Int("max_ack_pending", cfg.MaxAckPending))
+}
@@ -0,0 +1,65 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"net"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+const maxStartupBackoff time.Duration = time.Second * 30
+
+// startupRetry retries the initial connect and stream setup with the exponential backoff until the startup_timeout
+// expires, so the RR boot survives the rolling NATS upgrade. Without the startup_timeout fn is called once.
+func startupRetry(conf *config, log *zap.Logger, what string, fn func() error) error {
+	err := fn()
+	if err == nil || conf.StartupTimeout == 0 {
+		return err
+	}
+
+	deadline := time.Now().Add(conf.StartupTimeout)
+	backoff := conf.StartupBackoff
+
+	for attempt := 1; startupRetriable(err); attempt++ {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return err
+		}
+
+		if backoff > left {
+			backoff = left
+		}
+
+		log.Warn("nats is unavailable, retrying", zap.String("step", what), zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+		time.Sleep(backoff)
+
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		backoff *= 2
+		if backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
+		}
+	}
+
+	return err
+}
+
+// startupRetriable checks if the error is caused by the unavailable server (not by the configuration or permissions)
+func startupRetriable(err error) bool {
+	var netErr net.Error
+	if stderr.As(err, &netErr) {
+		return true
+	}
+
+	return stderr.Is(err, nats.ErrNoServers) ||
+		stderr.Is(err, nats.ErrTimeout) ||
+		stderr.Is(err, nats.ErrConnectionClosed) ||
+		stderr.Is(err, nats.ErrNoResponders) ||
+		stderr.Is(err, nats.ErrNoStreamResponse) ||
+		stderr.Is(err, nats.ErrJetStreamNotEnabled)
+}
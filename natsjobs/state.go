@@ -0,0 +1,81 @@
+package natsjobs
+
+import (
+	"context"
+	stderr "errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+type forceStateKey struct{}
+
+// WithForceState returns the context to bypass the State() consumer info cache
+func WithForceState(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceStateKey{}, true)
+}
+
+func forceState(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+
+	v, _ := ctx.Value(forceStateKey{}).(bool)
+	return v
+}
+
+// consumerStats are the consumers counters reported by State()
+type consumerStats struct {
+	active   int64
+	reserved int64
+}
+
+// stateCache keeps the last known consumers counters
+type stateCache struct {
+	mu    sync.Mutex
+	stats consumerStats
+	at    time.Time
+	valid bool
+}
+
+// consumerStats returns the consumers counters, cached for the state_cache_ttl. The last known counters are
+// returned if the JetStream API call timed out.
+func (c *Driver) consumerStats(ctx context.Context) (consumerStats, error) {
+	c.stateCache.mu.Lock()
+	defer c.stateCache.mu.Unlock()
+
+	if c.stateCache.valid && c.stateCacheTTL > 0 && !forceState(ctx) && time.Since(c.stateCache.at) < c.stateCacheTTL {
+		return c.stateCache.stats, nil
+	}
+
+	var stats consumerStats
+	subs := c.subscriptions()
+	for i := 0; i < len(subs); i++ {
+		ci, err := subs[i].ConsumerInfo()
+		if err != nil {
+			if c.stateCache.valid && (stderr.Is(err, nats.ErrTimeout) || stderr.Is(err, context.DeadlineExceeded)) {
+				c.log.Warn("consumer info timed out, last known state is reported", zap.Time("updated", c.stateCache.at), zap.Error(err))
+				return c.stateCache.stats, nil
+			}
+
+			return consumerStats{}, err
+		}
+
+		if ci != nil {
+			stats.active += int64(ci.NumAckPending)
+			stats.reserved += int64(ci.NumWaiting)
+			// messages left to replay
+			if c.deliverAll {
+				stats.reserved += int64(ci.NumPending)
+			}
+		}
+	}
+
+	c.stateCache.stats = stats
+	c.stateCache.at = time.Now()
+	c.stateCache.valid = true
+
+	return stats, nil
+}
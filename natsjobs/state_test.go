@@ -0,0 +1,93 @@
+package natsjobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerStatsSum(t *testing.T) {
+	c, _ := newTestDriver(t, &fakeJS{})
+
+	subs := []subscription{
+		&fakeSub{info: &nats.ConsumerInfo{NumAckPending: 3, NumWaiting: 1, NumPending: 100}},
+		&fakeSub{info: &nats.ConsumerInfo{NumAckPending: 2, NumWaiting: 4, NumPending: 50}},
+	}
+	c.subs.Store(&subs)
+
+	stats, err := c.consumerStats(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, stats.active)
+	assert.EqualValues(t, 5, stats.reserved)
+
+	// the messages left to replay are reported as reserved
+	c.deliverAll = true
+	stats, err = c.consumerStats(WithForceState(context.Background()))
+	require.NoError(t, err)
+	assert.EqualValues(t, 155, stats.reserved)
+}
+
+func TestConsumerStatsCache(t *testing.T) {
+	c, _ := newTestDriver(t, &fakeJS{})
+	c.stateCacheTTL = time.Minute
+
+	sub := &fakeSub{info: &nats.ConsumerInfo{NumAckPending: 1}}
+	subs := []subscription{sub}
+	c.subs.Store(&subs)
+
+	_, err := c.consumerStats(context.Background())
+	require.NoError(t, err)
+
+	sub.info = &nats.ConsumerInfo{NumAckPending: 10}
+	stats, err := c.consumerStats(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, stats.active)
+	assert.Equal(t, 1, sub.calls)
+
+	// forced state bypasses the cache
+	stats, err = c.consumerStats(WithForceState(context.Background()))
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, stats.active)
+	assert.Equal(t, 2, sub.calls)
+}
+
+func TestConsumerStatsTimeout(t *testing.T) {
+	c, _ := newTestDriver(t, &fakeJS{})
+
+	sub := &fakeSub{err: nats.ErrTimeout}
+	subs := []subscription{sub}
+	c.subs.Store(&subs)
+
+	// nothing known yet
+	_, err := c.consumerStats(context.Background())
+	require.ErrorIs(t, err, nats.ErrTimeout)
+
+	sub.err = nil
+	sub.info = &nats.ConsumerInfo{NumAckPending: 7}
+	_, err = c.consumerStats(context.Background())
+	require.NoError(t, err)
+
+	// the last known counters are reported on timeout
+	sub.err = nats.ErrTimeout
+	stats, err := c.consumerStats(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, stats.active)
+
+	// other errors are returned
+	sub.err = nats.ErrConsumerNotFound
+	_, err = c.consumerStats(context.Background())
+	require.ErrorIs(t, err, nats.ErrConsumerNotFound)
+}
+
+func TestPendingMsgs(t *testing.T) {
+	c, _ := newTestDriver(t, &fakeJS{})
+	assert.Zero(t, c.pendingMsgs())
+
+	subs := []subscription{&fakeSub{pending: 3}, &fakeSub{pending: 4}}
+	c.subs.Store(&subs)
+	assert.Equal(t, 7, c.pendingMsgs())
+}
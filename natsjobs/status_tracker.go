@@ -0,0 +1,111 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// job lifecycle states written to the status bucket
+const (
+	jobQueued string = "queued"
+	jobActive string = "active"
+	jobDone   string = "done"
+	jobFailed string = "failed"
+)
+
+// JobStatus is the job lifecycle state stored in the status_bucket under the base64url encoded job ID
+type JobStatus struct {
+	ID       string `json:"id"`
+	Job      string `json:"job"`
+	Pipeline string `json:"pipeline"`
+	// Status is one of: queued, active (consumed by RR), done, failed
+	Status string `json:"status"`
+	// Outcome is the worker result: acked, nacked, requeued or terminated
+	Outcome   string    `json:"outcome,omitempty"`
+	Attempts  uint64    `json:"attempts,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// initStatusBucket creates (or binds to) the KV bucket used to track the jobs states
+func initStatusBucket(js bucketAdmin, bucket string, ttl time.Duration) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(bucket)
+	if err == nil {
+		return kv, nil
+	}
+
+	if !stderr.Is(err, nats.ErrBucketNotFound) {
+		return nil, err
+	}
+
+	return js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "RoadRunner jobs statuses",
+		TTL:         ttl,
+	})
+}
+
+// trackStatus writes the job state, the failures are logged only, the job processing doesn't depend on the tracker
+func (c *Driver) trackStatus(st *JobStatus) {
+	if c.statusKV == nil {
+		return
+	}
+
+	st.Pipeline = (*c.pipeline.Load()).Name()
+	st.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		c.log.Error("failed to marshal the job status", zap.String("id", st.ID), zap.Error(err))
+		return
+	}
+
+	_, err = c.statusKV.Put(uniqueKey(st.ID), data)
+	if err != nil {
+		c.log.Warn("failed to write the job status", zap.String("id", st.ID), zap.String("status", st.Status), zap.Error(err))
+	}
+}
+
+// outcomeStatus maps the worker outcome to the job state
+func outcomeStatus(outcome string) string {
+	switch outcome {
+	case outcomeAcked:
+		return jobDone
+	case outcomeRequeued:
+		return jobQueued
+	default:
+		return jobFailed
+	}
+}
+
+// JobStatus returns the tracked state of the job
+func (c *Driver) JobStatus(id string) (*JobStatus, error) {
+	const op = errors.Op("nats_job_status")
+
+	if c.statusKV == nil {
+		return nil, errors.E(op, errors.Str("status_bucket is not configured"))
+	}
+
+	e, err := c.statusKV.Get(uniqueKey(id))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	st := &JobStatus{}
+	err = json.Unmarshal(e.Value(), st)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return st, nil
+}
+
+// JobStatusRequest is the job status RPC request
+type JobStatusRequest struct {
+	Pipeline string `json:"pipeline"`
+	ID       string `json:"id"`
+}
@@ -0,0 +1,278 @@
+package natsjobs
+
+import (
+	stderr "errors"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+	"go.uber.org/zap"
+)
+
+// streamOptions are the options of the pipeline stream
+type streamOptions struct {
+	name    string
+	subject string
+	// update the existing stream subjects if they don't cover the pipeline subject
+	update bool
+	// mirror is the name of the stream to mirror, the mirror has no subjects
+	mirror string
+	// sources are the names of the streams to source the messages from
+	sources []string
+	// external is the API prefix of the mirrored or sourced streams in another domain or account
+	external *nats.ExternalStream
+	// republish republishes the stored messages to another subject, nil - disabled
+	republish *nats.RePublish
+	// compression of the created stream (nats-server 2.10+), empty - server default (none)
+	compression string
+	// placement of the created stream in the supercluster, nil - the cluster of the connected server
+	placement *nats.Placement
+	// transform of the ingested subjects (nats-server 2.10+), nil - disabled
+	transform *subjectTransform
+	// allowRollup allows the Nats-Rollup header to purge the subject or the stream on publish
+	allowRollup bool
+	// denyDelete and denyPurge make the stream an immutable log
+	denyDelete bool
+	denyPurge  bool
+	// deleteAfterAck deletes the acknowledged messages from the stream
+	deleteAfterAck bool
+}
+
+// placement is the cluster and the server tags the stream replicas are placed on
+type placement struct {
+	Cluster string   `mapstructure:"cluster"`
+	Tags    []string `mapstructure:"tags"`
+}
+
+// newPlacement returns the stream placement, nil if neither the cluster nor the tags are set
+func newPlacement(cluster string, tags []string) *nats.Placement {
+	if cluster == "" && len(tags) == 0 {
+		return nil
+	}
+
+	return &nats.Placement{
+		Cluster: cluster,
+		Tags:    tags,
+	}
+}
+
+// newRePublish returns the stream RePublish setting, nil if the destination is empty
+func newRePublish(source, destination string, headersOnly bool) *nats.RePublish {
+	if destination == "" {
+		return nil
+	}
+
+	return &nats.RePublish{
+		Source:      source,
+		Destination: destination,
+		HeadersOnly: headersOnly,
+	}
+}
+
+// newExternal returns the external stream for the domain or the API prefix, nil if both are empty
+func newExternal(domain, apiPrefix, deliverPrefix string) *nats.ExternalStream {
+	if apiPrefix == "" && domain != "" {
+		apiPrefix = "$JS." + domain + ".API"
+	}
+
+	if apiPrefix == "" {
+		return nil
+	}
+
+	return &nats.ExternalStream{
+		APIPrefix:     apiPrefix,
+		DeliverPrefix: deliverPrefix,
+	}
+}
+
+func (so *streamOptions) validate() error {
+	err := validateCompression(so.compression)
+	if err != nil {
+		return err
+	}
+
+	if so.denyDelete && so.deleteAfterAck {
+		return errors.Str("delete_after_ack can't be used with the deny_delete stream")
+	}
+
+	if so.mirror != "" && len(so.sources) > 0 {
+		return errors.Str("stream can't be a mirror and have sources at the same time")
+	}
+
+	// the transformed subjects should be still consumed by the pipeline
+	if so.transform != nil && so.mirror == "" && !subjectCovered(so.subject, so.transform.Destination) {
+		return errors.Errorf("subject_transform destination %s should be covered by the pipeline subject %s", so.transform.Destination, so.subject)
+	}
+
+	// the republished messages would be stored in the same stream again
+	if so.republish != nil && so.mirror == "" && subjectCovered(so.subject, so.republish.Destination) {
+		return errors.Errorf("republish_destination %s should not overlap with the stream subject %s", so.republish.Destination, so.subject)
+	}
+
+	return nil
+}
+
+// retentionDeleteAfterAck checks the delete_after_ack against the stream retention. The work-queue and interest
+// streams remove the acknowledged messages themselves, the deletes are redundant and fail, so they are skipped.
+// The erase_after_ack can't be honored by such streams, the messages are removed without the overwrite.
+func retentionDeleteAfterAck(si *nats.StreamInfo, deleteAfterAck, erase bool, log *zap.Logger) (bool, error) {
+	if !deleteAfterAck || si == nil || si.Config.Retention == nats.LimitsPolicy {
+		return deleteAfterAck, nil
+	}
+
+	if erase {
+		return false, errors.Errorf("erase_after_ack can't be used with the %s retention stream %s, the acknowledged messages are removed without the erase", si.Config.Retention, si.Config.Name)
+	}
+
+	log.Warn("delete_after_ack is ignored, the stream retention removes the acknowledged messages", zap.String("stream", si.Config.Name), zap.String("retention", si.Config.Retention.String()))
+	return false, nil
+}
+
+// ensureStream returns the pipeline stream, creating it if needed
+func ensureStream(js streamAdmin, nc natsConn, log *zap.Logger, so *streamOptions) (*nats.StreamInfo, error) {
+	const op = errors.Op("nats_ensure_stream")
+
+	si, err := js.StreamInfo(so.name)
+	if err != nil {
+		if !stderr.Is(err, nats.ErrStreamNotFound) {
+			return nil, errors.E(op, err)
+		}
+
+		if ext := so.extensions(); ext != nil {
+			si, err = addStreamExt(nc, so.config(), ext)
+		} else {
+			si, err = js.AddStream(so.config())
+		}
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	if si == nil {
+		return nil, errors.E(op, errors.Str("failed to create a stream"))
+	}
+
+	return reconcileSubjects(js, log, si, so)
+}
+
+func (so *streamOptions) config() *nats.StreamConfig {
+	if so.mirror != "" {
+		return &nats.StreamConfig{
+			Name: so.name,
+			Mirror: &nats.StreamSource{
+				Name:     so.mirror,
+				External: so.external,
+			},
+			RePublish:   so.republish,
+			Placement:   so.placement,
+			AllowRollup: so.allowRollup,
+			DenyDelete:  so.denyDelete,
+			DenyPurge:   so.denyPurge,
+		}
+	}
+
+	cfg := &nats.StreamConfig{
+		Name:        so.name,
+		Subjects:    []string{so.subject},
+		RePublish:   so.republish,
+		Placement:   so.placement,
+		AllowRollup: so.allowRollup,
+		DenyDelete:  so.denyDelete,
+		DenyPurge:   so.denyPurge,
+	}
+
+	for i := 0; i < len(so.sources); i++ {
+		cfg.Sources = append(cfg.Sources, &nats.StreamSource{
+			Name:     so.sources[i],
+			External: so.external,
+		})
+	}
+
+	return cfg
+}
+
+// reconcileSubjects checks that the existing stream covers the pipeline subject, otherwise the publishes fail
+// with "no responders". The subject is added to the stream if update_stream is enabled.
+func reconcileSubjects(js streamAdmin, log *zap.Logger, si *nats.StreamInfo, so *streamOptions) (*nats.StreamInfo, error) {
+	const op = errors.Op("nats_reconcile_subjects")
+
+	// mirrors have no subjects
+	if si.Config.Mirror != nil {
+		return si, nil
+	}
+
+	for i := 0; i < len(si.Config.Subjects); i++ {
+		if subjectCovered(si.Config.Subjects[i], so.subject) {
+			return si, nil
+		}
+	}
+
+	if !so.update {
+		return nil, errors.E(op, errors.Errorf("stream %s subjects [%s] don't cover the pipeline subject %s, add the subject to the stream or enable update_stream",
+			si.Config.Name, strings.Join(si.Config.Subjects, ", "), so.subject))
+	}
+
+	cfg := si.Config
+	cfg.Subjects = append(cfg.Subjects, so.subject)
+	si, err := js.UpdateStream(&cfg)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	log.Warn("pipeline subject added to the stream", zap.String("stream", cfg.Name), zap.String("subject", so.subject))
+
+	return si, nil
+}
+
+// subjectCovered checks that all the subjects matching the subject (might contain wildcards) match the pattern
+func subjectCovered(pattern, subject string) bool {
+	if pattern == subject {
+		return true
+	}
+
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(subject, ".")
+
+	for i := 0; i < len(pt); i++ {
+		if pt[i] == ">" {
+			return len(st) > i
+		}
+
+		if i >= len(st) {
+			return false
+		}
+
+		switch pt[i] {
+		case "*":
+			// * doesn't cover the multi-token wildcard
+			if st[i] == ">" {
+				return false
+			}
+		default:
+			if pt[i] != st[i] {
+				return false
+			}
+		}
+	}
+
+	return len(pt) == len(st)
+}
+
+// checkDLQ verifies the dead letter subject is captured by a stream. Otherwise the DLQ publish fails, the terminated
+// message is Nak'ed to not lose it and redelivered forever.
+func checkDLQ(js streamAdmin, subject string) error {
+	if subject == "" {
+		return nil
+	}
+
+	_, err := js.StreamNameBySubject(subject)
+	if err != nil {
+		if stderr.Is(err, nats.ErrNoMatchingStream) {
+			return errors.Errorf("no stream captures the dlq_subject %s, create the dead letter stream first", subject)
+		}
+
+		return err
+	}
+
+	return nil
+}
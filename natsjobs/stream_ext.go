@@ -0,0 +1,92 @@
+package natsjobs
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+)
+
+// stream create API subject, the JetStream domains are not supported for the extended settings
+const apiStreamCreate string = "$JS.API.STREAM.CREATE."
+
+// addStreamExt creates the stream via the JetStream API request with the settings
+// the client library doesn't support yet (nats-server 2.10+)
+func addStreamExt(nc natsConn, cfg *nats.StreamConfig, ext map[string]any) (*nats.StreamInfo, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	req := make(map[string]any)
+	err = json.Unmarshal(data, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range ext {
+		req[k] = v
+	}
+
+	data, err = json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := nc.Request(apiStreamCreate+cfg.Name, data, time.Second*5)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		nats.StreamInfo
+		Error *nats.APIError `json:"error,omitempty"`
+	}
+
+	err = json.Unmarshal(msg.Data, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	return &resp.StreamInfo, nil
+}
+
+// extensions returns the stream settings not supported by the client library, nil - the stream is created by the library
+func (so *streamOptions) extensions() map[string]any {
+	ext := make(map[string]any, 2)
+	if so.compression != "" && so.compression != compressionNone {
+		ext["compression"] = so.compression
+	}
+
+	if so.transform != nil {
+		ext["subject_transform"] = so.transform
+	}
+
+	if len(ext) == 0 {
+		return nil
+	}
+
+	return ext
+}
+
+// subjectTransform maps the ingested subjects to the canonical ones before the messages are stored
+type subjectTransform struct {
+	Source      string `json:"src" mapstructure:"source"`
+	Destination string `json:"dest" mapstructure:"destination"`
+}
+
+// newSubjectTransform returns the stream subject transform, nil if the destination is empty
+func newSubjectTransform(source, destination string) *subjectTransform {
+	if destination == "" {
+		return nil
+	}
+
+	return &subjectTransform{
+		Source:      source,
+		Destination: destination,
+	}
+}
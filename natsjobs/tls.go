@@ -0,0 +1,58 @@
+package natsjobs
+
+import (
+	"crypto/tls"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+)
+
+type tlsConfig struct {
+	// Cert and Key are the client certificate and its private key
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+	// RootCA is the CA used to verify the server certificate
+	RootCA string `mapstructure:"root_ca"`
+	// ServerName overrides the SNI and the name used to verify the server certificate (e.g. connecting by IP)
+	ServerName string `mapstructure:"server_name"`
+	// HandshakeFirst performs the TLS handshake before the server INFO (handshake_first in the server tls block)
+	HandshakeFirst bool `mapstructure:"handshake_first"`
+}
+
+// tlsOptions returns the TLS connection options, nil if TLS isn't configured
+func tlsOptions(conf *tlsConfig) ([]nats.Option, error) {
+	const op = errors.Op("nats_tls")
+	if conf == nil {
+		return nil, nil
+	}
+
+	// the client reads the INFO before the handshake, the TLS-first servers are supported by nats.go v1.31+
+	if conf.HandshakeFirst {
+		return nil, errors.E(op, errors.Str("tls handshake_first is not supported by the NATS client used by the driver"))
+	}
+
+	if (conf.Cert == "") != (conf.Key == "") {
+		return nil, errors.E(op, errors.Str("both tls cert and key should be set"))
+	}
+
+	// SNI, the server name is taken from the URL if empty
+	tc := &tls.Config{ServerName: conf.ServerName, MinVersion: tls.VersionTLS12} //nolint:gosec
+	if conf.Cert != "" {
+		// loaded on every handshake, the rotated certificate is used on reconnect
+		tc.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+			if err != nil {
+				return nil, err
+			}
+
+			return &cert, nil
+		}
+	}
+
+	opts := []nats.Option{nats.Secure(tc)}
+	if conf.RootCA != "" {
+		opts = append(opts, nats.RootCAs(conf.RootCA))
+	}
+
+	return opts, nil
+}
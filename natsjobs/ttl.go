@@ -0,0 +1,98 @@
+package natsjobs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
+	"go.uber.org/zap"
+)
+
+const (
+	// job headers to set the expiration
+	jobHeaderTTL       string = "ttl"
+	jobHeaderExpiresAt string = "expires_at"
+
+	// NATS message headers
+	headerExpiresAt string = "Rr-Expires-At"
+	// per-message TTL, supported since nats-server 2.11 for the streams with AllowMsgTTL
+	headerNatsTTL string = "Nats-TTL"
+)
+
+// expiresAt calculates the job expiration time from the job headers or the pipeline TTL, zero - never expires
+func (c *Driver) expiresAt(job jobs.Job) time.Time {
+	hdr := job.Headers()
+
+	if v, ok := hdr[jobHeaderExpiresAt]; ok && len(v) > 0 {
+		if t, err := time.Parse(time.RFC3339, v[0]); err == nil {
+			return t
+		}
+
+		if sec, err := strconv.ParseInt(v[0], 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+
+		c.log.Warn("failed to parse the expires_at header", zap.String("id", job.ID()), zap.String("value", v[0]))
+	}
+
+	if v, ok := hdr[jobHeaderTTL]; ok && len(v) > 0 {
+		if d, err := time.ParseDuration(v[0]); err == nil {
+			return time.Now().Add(d)
+		}
+
+		if sec, err := strconv.ParseInt(v[0], 10, 64); err == nil {
+			return time.Now().Add(time.Second * time.Duration(sec))
+		}
+
+		c.log.Warn("failed to parse the ttl header", zap.String("id", job.ID()), zap.String("value", v[0]))
+	}
+
+	if c.ttl > 0 {
+		return time.Now().Add(c.ttl)
+	}
+
+	return time.Time{}
+}
+
+// expirationHeaders returns the NATS headers for the job expiration, nil if the job never expires
+func (c *Driver) expirationHeaders(job jobs.Job) nats.Header {
+	exp := c.expiresAt(job)
+	if exp.IsZero() {
+		return nil
+	}
+
+	hdr := nats.Header{}
+	hdr.Set(headerExpiresAt, exp.UTC().Format(time.RFC3339Nano))
+
+	if c.serverTTL {
+		// whole seconds, at least 1s
+		sec := int64(time.Until(exp).Round(time.Second) / time.Second)
+		if sec < 1 {
+			sec = 1
+		}
+
+		hdr.Set(headerNatsTTL, strconv.FormatInt(sec, 10)+"s")
+	}
+
+	return hdr
+}
+
+// expired checks the expiration header of the consumed message
+func expired(m *nats.Msg) bool {
+	if m.Header == nil {
+		return false
+	}
+
+	v := m.Header.Get(headerExpiresAt)
+	if v == "" {
+		return false
+	}
+
+	exp, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return false
+	}
+
+	return time.Now().After(exp)
+}
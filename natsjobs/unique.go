@@ -0,0 +1,60 @@
+package natsjobs
+
+import (
+	"encoding/base64"
+	stderr "errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
+)
+
+// initUniqueJobs creates (or binds to) the KV bucket used to record the pushed job IDs
+func initUniqueJobs(js bucketAdmin, bucket string, ttl time.Duration) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(bucket)
+	if err == nil {
+		return kv, nil
+	}
+
+	if !stderr.Is(err, nats.ErrBucketNotFound) {
+		return nil, err
+	}
+
+	return js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      bucket,
+		Description: "RoadRunner unique jobs",
+		TTL:         ttl,
+	})
+}
+
+// reserve records the job ID in the KV bucket, returns false if the job with the same ID was pushed within the TTL
+func (c *Driver) reserve(id string) (bool, error) {
+	_, err := c.kv.Create(uniqueKey(id), nil)
+	if err != nil {
+		if stderr.Is(err, nats.ErrKeyExists) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// release removes the job ID from the KV bucket, so the job might be pushed again (e.g. after the failed publish)
+func (c *Driver) release(id string) {
+	_ = c.kv.Delete(uniqueKey(id))
+}
+
+// uniqueKey encodes the job ID, the KV keys allow only a limited set of characters
+func uniqueKey(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func validateUniqueBucket(bucket string) error {
+	if bucket == "" {
+		return errors.Str("kv_bucket should be set for the unique_jobs")
+	}
+
+	return nil
+}
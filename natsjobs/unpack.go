@@ -1,8 +1,13 @@
 package natsjobs
 
 import (
+	"encoding/base64"
+	"strconv"
+	"time"
+
 	"github.com/goccy/go-json"
-	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/roadrunner-server/errors"
 	"github.com/roadrunner-server/sdk/v4/utils"
 	"go.uber.org/zap"
 )
@@ -10,45 +15,136 @@ import (
 const (
 	// consume all
 	auto string = "deduced_by_rr"
+
+	// raw payload modes
+	rawPayloadBytes  string = "bytes"
+	rawPayloadBase64 string = "base64"
+
+	// NATS message metadata headers
+	headerStream      string = "rr_nats_stream"
+	headerStreamSeq   string = "rr_nats_stream_seq"
+	headerConsumerSeq string = "rr_nats_consumer_seq"
+	headerTimestamp   string = "rr_nats_timestamp"
+	headerDelivered   string = "rr_nats_delivered"
 )
 
-func (c *Driver) unpack(data []byte, item *Item) error {
+func (c *Driver) unpack(m *nats.Msg, meta *nats.MsgMetadata, item *Item) error {
+	if c.headersOnly {
+		c.headersItem(m, item)
+	} else {
+		err := c.decode(m, item)
+		if err != nil {
+			return &malformedError{err: err}
+		}
+
+		// the RR job without the options, e.g. {} or a foreign JSON object
+		if item.Options == nil {
+			return &malformedError{err: errors.Str("job options are missing")}
+		}
+
+		err = c.resolveClaim(m, item)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the driver headers (metadata, attempts) are added after the filtering
+	item.Headers = c.headerFilter.apply(item.Headers)
+
+	// foreign message, the producer might set the priority via the header
+	if c.priorityHeader != "" && item.Options.Pipeline == auto {
+		c.headerPriority(m.Header, item)
+	}
+
+	if meta != nil {
+		if item.Headers == nil {
+			item.Headers = make(map[string][]string, 5)
+		}
+
+		item.Headers[headerStream] = []string{meta.Stream}
+		item.Headers[headerStreamSeq] = []string{strconv.FormatUint(meta.Sequence.Stream, 10)}
+		item.Headers[headerConsumerSeq] = []string{strconv.FormatUint(meta.Sequence.Consumer, 10)}
+		item.Headers[headerTimestamp] = []string{meta.Timestamp.UTC().Format(time.RFC3339Nano)}
+		item.Headers[headerDelivered] = []string{strconv.FormatUint(meta.NumDelivered, 10)}
+	}
+
+	return nil
+}
+
+func (c *Driver) unmarshal(data []byte, subject string, item *Item) error {
 	err := json.Unmarshal(data, item)
 	if err != nil {
 		if c.consumeAll {
 			c.log.Debug("unmarshal error", zap.Error(err))
+			return c.rawItem(data, subject, item)
+		}
 
-			uid := uuid.NewString()
-			c.log.Debug("get raw payload", zap.String("assigned ID", uid))
+		return err
+	}
 
-			if isJSONEncoded(data) != nil {
-				data, err = json.Marshal(data)
-				if err != nil {
-					return err
-				}
-			}
+	return nil
+}
 
-			*item = Item{
-				Job:     auto,
-				Ident:   uid,
-				Payload: utils.AsString(data),
-				Headers: nil,
-				Options: &Options{
-					Priority: 10,
-					Pipeline: auto,
-				},
-			}
+// rawItem wraps the foreign (non-RR) payload into the item
+func (c *Driver) rawItem(data []byte, subject string, item *Item) error {
+	uid := c.genID()
+	c.log.Debug("get raw payload", zap.String("assigned ID", uid))
 
-			return nil
+	switch c.rawPayload {
+	case rawPayloadBytes:
+		// pass the bytes through without copying, the payload is not touched
+	case rawPayloadBase64:
+		data = []byte(base64.StdEncoding.EncodeToString(data))
+	default:
+		if isJSONEncoded(data) != nil {
+			var err error
+			data, err = json.Marshal(data)
+			if err != nil {
+				return err
+			}
 		}
+	}
 
-		return err
+	*item = Item{
+		Job:     c.foreignJobName(subject),
+		Ident:   uid,
+		Payload: utils.AsString(data),
+		Headers: nil,
+		Options: &Options{
+			Priority: c.defaultPriority,
+			Pipeline: auto,
+		},
 	}
 
 	return nil
 }
 
+// headerPriority overrides the default priority with the priority from the message header
+func (c *Driver) headerPriority(hdr nats.Header, item *Item) {
+	v := hdr.Get(c.priorityHeader)
+	if v == "" {
+		return
+	}
+
+	p, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || p <= 0 {
+		c.log.Warn("invalid priority header, default priority is used", zap.String("header", c.priorityHeader), zap.String("value", v))
+		return
+	}
+
+	item.Options.Priority = p
+}
+
 func isJSONEncoded(data []byte) error {
 	var a any
 	return json.Unmarshal(data, &a)
 }
+
+func validateRawPayload(mode string) error {
+	switch mode {
+	case "", rawPayloadBytes, rawPayloadBase64:
+		return nil
+	default:
+		return errors.Errorf("unknown raw_payload mode: %s, available: bytes, base64", mode)
+	}
+}
@@ -0,0 +1,113 @@
+package natsjobs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newUnpackDriver(t testing.TB, consumeAll bool) *Driver {
+	ct, err := newContentTypes(nil)
+	require.NoError(t, err)
+
+	c, _ := newTestDriver(t, &fakeJS{})
+	c.contentTypes = ct
+	c.consumeAll = consumeAll
+	c.compat = compatAuto
+	c.defaultPriority = 10
+	c.priorityHeader = "X-Priority"
+	c.ackPolicy = ackPolicyNone
+	c.onDecodeError = onDecodeErrorTerm
+	c.genID = func() string { return "generated" }
+
+	return c
+}
+
+func unpackSeeds(t testing.TB) [][]byte {
+	return [][]byte{
+		jobData(t, "1", 1),
+		[]byte(`{"job":"job","id":"1","payload":"p","headers":{"a":["b"]},"options":{"priority":5,"pipeline":"test"}}`),
+		// missing options
+		[]byte(`{"job":"job","id":"1","payload":"p"}`),
+		[]byte(`{}`),
+		[]byte(`{"options":null}`),
+		// malformed
+		[]byte(`{`),
+		[]byte(`{"job":1}`),
+		[]byte(`{"options":{"priority":"high"}}`),
+		[]byte(`[]`),
+		[]byte(``),
+		// huge headers
+		[]byte(`{"job":"job","id":"1","headers":{"` + strings.Repeat("h", 1<<12) + `":["` + strings.Repeat("v", 1<<12) + `"]},"options":{}}`),
+		// binary
+		{0x00, 0xff, 0xfe, 0x01, 0x80, 0x7f},
+		// cloud event and compat
+		[]byte(`{"specversion":"1.0","type":"created","id":"1","source":"s","data_base64":"!!"}`),
+		[]byte(`{"job":"Illuminate\\Queue\\CallQueuedHandler@call","displayName":"SendMail","uuid":"1"}`),
+		// protobuf envelopes
+		appendEnvelope(nil, &Item{Job: "job", Ident: "1", Payload: "p", Headers: map[string][]string{"a": {"b"}}, Options: &Options{Priority: 1}}),
+		appendEnvelope(nil, &Item{Job: "job", Ident: "1"})[:3],
+		{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+}
+
+func FuzzUnpack(f *testing.F) {
+	for _, seed := range unpackSeeds(f) {
+		for _, ct := range []string{"", contentTypeRRJSON, contentTypeProto, contentTypeCloudEvents, contentTypeOctetStream} {
+			f.Add(seed, ct, "5", false)
+		}
+		f.Add(seed, "", "", true)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, contentType, priority string, consumeAll bool) {
+		c := newUnpackDriver(t, consumeAll)
+		// the priority header lookup must not hide the missing options
+		if priority == "" {
+			c.priorityHeader = ""
+		}
+
+		m := jsMsg("jobs.default", data, 1)
+		m.Header.Set(headerContentType, contentType)
+		m.Header.Set("X-Priority", priority)
+		meta, err := m.Metadata()
+		require.NoError(t, err)
+
+		item := &Item{}
+		err = c.safeUnpack(m, meta, item)
+		if err != nil {
+			// no claim checks, so every unpack error is the malformed payload
+			require.True(t, isMalformed(err), "not a malformed error: %v", err)
+			// the malformed message is rejected without the panic
+			c.rejectMalformed(m, err)
+			return
+		}
+
+		require.NotNil(t, item.Options)
+		assert.Equal(t, []string{"1"}, item.Headers[headerStreamSeq])
+	})
+}
+
+func TestUnpackMalformed(t *testing.T) {
+	c := newUnpackDriver(t, false)
+	c.priorityHeader = ""
+
+	for _, data := range [][]byte{[]byte(`{`), []byte(`{"job":"job","id":"1"}`), []byte(`{}`), {0x00, 0xff}} {
+		m := jsMsg("jobs.default", data, 1)
+		meta, err := m.Metadata()
+		require.NoError(t, err)
+
+		err = c.safeUnpack(m, meta, &Item{})
+		assert.Truef(t, isMalformed(err), "%q: %v", data, err)
+	}
+
+	// the envelope is decoded by the Content-Type
+	m := jsMsg("jobs.default", appendEnvelope(nil, &Item{Job: "job", Ident: "1", Options: &Options{Priority: 3}}), 1)
+	m.Header = nats.Header{headerContentType: []string{contentTypeProto}}
+	item := &Item{}
+	require.NoError(t, c.safeUnpack(m, nil, item))
+	assert.Equal(t, "1", item.ID())
+	assert.EqualValues(t, 3, item.Priority())
+}
@@ -0,0 +1,49 @@
+package natsjobs
+
+import (
+	"bytes"
+
+	"github.com/goccy/go-json"
+	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
+	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/sdk/v4/utils"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// loadSchema compiles the JSON Schema used to validate the pushed jobs payloads, nil if not configured
+func loadSchema(file string) (*jsonschema.Schema, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	sch, err := jsonschema.Compile(file)
+	if err != nil {
+		return nil, errors.Errorf("failed to compile the schema_file %s: %v", file, err)
+	}
+
+	return sch, nil
+}
+
+// validatePayload validates the job payload against the pipeline schema
+func (c *Driver) validatePayload(job jobs.Job) error {
+	if c.schema == nil {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(utils.AsBytes(job.Payload())))
+	// the validator expects json.Number for the numbers
+	dec.UseNumber()
+
+	var v any
+	err := dec.Decode(&v)
+	if err != nil {
+		return errors.Errorf("job %s payload is not a valid JSON: %v", job.ID(), err)
+	}
+
+	err = c.schema.Validate(v)
+	if err != nil {
+		return errors.Errorf("job %s payload doesn't match the schema: %v", job.ID(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package natsjobs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// serverMinVersion checks that the connected server version is at least major.minor.patch
+func serverMinVersion(conn natsConn, major, minor, patch int) bool {
+	// strip the pre-release/build part, e.g.: 2.11.0-beta.1
+	v := strings.SplitN(conn.ConnectedServerVersion(), "-", 2)[0]
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	want := [3]int{major, minor, patch}
+	for i := 0; i < 3; i++ {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return false
+		}
+
+		if n != want[i] {
+			return n > want[i]
+		}
+	}
+
+	return true
+}
@@ -1,10 +1,16 @@
 package nats
 
 import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/roadrunner-server/api/v4/plugins/v1/jobs"
 	pq "github.com/roadrunner-server/api/v4/plugins/v1/priority_queue"
+	"github.com/roadrunner-server/endure/v2/dep"
 	"github.com/roadrunner-server/errors"
 	"github.com/roadrunner-server/nats/v4/natsjobs"
+	"github.com/roadrunner-server/sdk/v4/events"
 	"go.uber.org/zap"
 )
 
@@ -21,9 +27,35 @@ type Logger interface {
 	NamedLogger(name string) *zap.Logger
 }
 
+type config struct {
+	// MaxInflight limits the number of consumed but not yet processed messages across all NATS pipelines
+	MaxInflight int64 `mapstructure:"max_inflight" scope:"global"`
+	// MaxInflightBytes limits the total size of such messages
+	MaxInflightBytes int64 `mapstructure:"max_inflight_bytes" scope:"global"`
+	// Embedded starts the in-process NATS server with JetStream and connects the pipelines to it (development and tests only)
+	Embedded bool `mapstructure:"embedded" scope:"global"`
+	// EmbeddedDir is the JetStream store dir of the embedded server, default - temp dir removed on stop
+	EmbeddedDir string `mapstructure:"embedded_dir" scope:"global"`
+	// EmbeddedPort is the port of the embedded server, default - random
+	EmbeddedPort int `mapstructure:"embedded_port" scope:"global"`
+}
+
 type Plugin struct {
-	log *zap.Logger
-	cfg Configurer
+	log    *zap.Logger
+	cfg    Configurer
+	shared *natsjobs.Shared
+	// in-process NATS server, might be nil
+	embedded *natsjobs.EmbeddedServer
+	// collected ID generators
+	idGenerators map[string]natsjobs.IDGenerator
+	// collected job middlewares
+	middlewares map[string]natsjobs.Middleware
+	// collected ack strategies
+	ackStrategies map[string]natsjobs.AckStrategy
+
+	mu sync.RWMutex
+	// drivers by the pipeline name
+	drivers map[string]*natsjobs.Driver
 }
 
 func (p *Plugin) Init(log Logger, cfg Configurer) error {
@@ -31,8 +63,56 @@ func (p *Plugin) Init(log Logger, cfg Configurer) error {
 		return errors.E(errors.Disabled)
 	}
 
+	const op = errors.Op("nats_plugin_init")
+
+	var conf config
+	err := cfg.UnmarshalKey(pluginName, &conf)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
 	p.log = log.NamedLogger(pluginName)
 	p.cfg = cfg
+	p.drivers = make(map[string]*natsjobs.Driver)
+	p.shared = &natsjobs.Shared{
+		Limiter:   natsjobs.NewLimiter(conf.MaxInflight, conf.MaxInflightBytes),
+		StopOrder: natsjobs.NewStopOrder(),
+		Accounts:  natsjobs.NewAccounts(),
+		Metrics:   natsjobs.NewMetrics(),
+	}
+
+	// NewEventBus doesn't fail, the second value is the subscriber ID, not an error.
+	// The drivers only publish, so the plugin never subscribes with it.
+	p.shared.Events, _ = events.NewEventBus()
+
+	if conf.Embedded {
+		// the embedded server replaces the NATS URL, the explicit one would be silently ignored
+		if cfg.Has(pluginName + ".addr") {
+			return errors.E(op, errors.Str("embedded can't be used with the addr, remove one of them"))
+		}
+
+		p.embedded, err = natsjobs.StartEmbedded(conf.EmbeddedDir, conf.EmbeddedPort, p.log)
+		if err != nil {
+			return errors.E(op, err)
+		}
+
+		p.shared.EmbeddedURL = p.embedded.ClientURL()
+	}
+
+	if p.idGenerators == nil {
+		p.idGenerators = make(map[string]natsjobs.IDGenerator)
+	}
+	p.shared.IDGenerators = p.idGenerators
+
+	if p.middlewares == nil {
+		p.middlewares = make(map[string]natsjobs.Middleware)
+	}
+	p.shared.Middlewares = p.middlewares
+
+	if p.ackStrategies == nil {
+		p.ackStrategies = make(map[string]natsjobs.AckStrategy)
+	}
+	p.shared.AckStrategies = p.ackStrategies
 	return nil
 }
 
@@ -40,10 +120,127 @@ func (p *Plugin) Name() string {
 	return pluginName
 }
 
+// Serve is a no-op, the pipelines are served by the jobs plugin
+func (p *Plugin) Serve() chan error {
+	return make(chan error, 1)
+}
+
+// Stop shuts down the embedded NATS server (if any) after the pipelines are stopped
+func (p *Plugin) Stop(context.Context) error {
+	if p.embedded != nil {
+		p.embedded.Shutdown()
+	}
+
+	return nil
+}
+
+// RPC returns the plugin RPC methods
+func (p *Plugin) RPC() any {
+	return &rpc{p: p}
+}
+
+// MetricsCollector returns the driver metrics for the metrics plugin
+func (p *Plugin) MetricsCollector() []prometheus.Collector {
+	return p.shared.Metrics.Collectors()
+}
+
+// Collects collects the ID generators, job middlewares and ack strategies provided by other plugins
+func (p *Plugin) Collects() []*dep.In {
+	return []*dep.In{
+		dep.Fits(func(pp any) {
+			g := pp.(natsjobs.IDGenerator)
+			if p.idGenerators == nil {
+				p.idGenerators = make(map[string]natsjobs.IDGenerator)
+			}
+
+			p.idGenerators[g.Name()] = g
+		}, (*natsjobs.IDGenerator)(nil)),
+		dep.Fits(func(pp any) {
+			mw := pp.(natsjobs.Middleware)
+			if p.middlewares == nil {
+				p.middlewares = make(map[string]natsjobs.Middleware)
+			}
+
+			p.middlewares[mw.Name()] = mw
+		}, (*natsjobs.Middleware)(nil)),
+		dep.Fits(func(pp any) {
+			s := pp.(natsjobs.AckStrategy)
+			if p.ackStrategies == nil {
+				p.ackStrategies = make(map[string]natsjobs.AckStrategy)
+			}
+
+			p.ackStrategies[s.Name()] = s
+		}, (*natsjobs.AckStrategy)(nil)),
+	}
+}
+
 func (p *Plugin) DriverFromConfig(configKey string, pq pq.Queue, pipeline jobs.Pipeline, cmder chan<- jobs.Commander) (jobs.Driver, error) {
-	return natsjobs.FromConfig(configKey, p.log, p.cfg, pipeline, pq, cmder)
+	d, err := natsjobs.FromConfig(configKey, p.log, p.cfg, pipeline, pq, p.shared, cmder)
+	if err != nil {
+		return nil, err
+	}
+
+	p.addDriver(pipeline.Name(), d)
+	return d, nil
 }
 
 func (p *Plugin) DriverFromPipeline(pipe jobs.Pipeline, pq pq.Queue, cmder chan<- jobs.Commander) (jobs.Driver, error) {
-	return natsjobs.FromPipeline(pipe, p.log, p.cfg, pq, cmder)
+	d, err := natsjobs.FromPipeline(pipe, p.log, p.cfg, pq, p.shared, cmder)
+	if err != nil {
+		return nil, err
+	}
+
+	p.addDriver(pipe.Name(), d)
+	return d, nil
+}
+
+func (p *Plugin) addDriver(pipeline string, d *natsjobs.Driver) {
+	p.mu.Lock()
+	p.drivers[pipeline] = d
+	p.mu.Unlock()
+}
+
+func (p *Plugin) driver(pipeline string) (*natsjobs.Driver, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	d, ok := p.drivers[pipeline]
+	if !ok || d.Stopped() {
+		return nil, false
+	}
+
+	return d, true
+}
+
+// activeDrivers removes the stopped (destroyed) pipelines and returns the rest
+func (p *Plugin) activeDrivers() map[string]*natsjobs.Driver {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	active := make(map[string]*natsjobs.Driver, len(p.drivers))
+	for name, d := range p.drivers {
+		if d.Stopped() {
+			delete(p.drivers, name)
+			continue
+		}
+
+		active[name] = d
+	}
+
+	return active
+}
+
+// Reset is called on rr reset, the pipelines configuration is reloaded in place
+func (p *Plugin) Reset() error {
+	const op = errors.Op("nats_plugin_reset")
+
+	for name, d := range p.activeDrivers() {
+		err := d.Reload()
+		if err != nil {
+			p.log.Error("failed to reload the pipeline", zap.String("pipeline", name), zap.Error(err))
+			return errors.E(op, err)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,144 @@
+package nats
+
+import (
+	"github.com/roadrunner-server/errors"
+	"github.com/roadrunner-server/nats/v4/natsjobs"
+)
+
+type rpc struct {
+	p *Plugin
+}
+
+// Schema returns the JSON schema of all supported global and pipeline options
+func (r *rpc) Schema(_ bool, out *string) error {
+	const op = errors.Op("nats_rpc_schema")
+
+	data, err := natsjobs.Schema(config{})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	*out = string(data)
+	return nil
+}
+
+// MigrateSTAN starts the migration of the legacy NATS Streaming channel into the pipeline stream
+func (r *rpc) MigrateSTAN(req *natsjobs.MigrationRequest, out *natsjobs.MigrationStatus) error {
+	const op = errors.Op("nats_rpc_migrate_stan")
+
+	d, ok := r.p.driver(req.Pipeline)
+	if !ok {
+		return errors.E(op, errors.Errorf("no such pipeline: %s", req.Pipeline))
+	}
+
+	err := d.MigrateSTAN(req)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	*out = *d.MigrationStatus()
+	return nil
+}
+
+// MigrationStatus returns the NATS Streaming migration progress of the pipeline
+func (r *rpc) MigrationStatus(pipeline string, out *natsjobs.MigrationStatus) error {
+	const op = errors.Op("nats_rpc_migration_status")
+
+	d, ok := r.p.driver(pipeline)
+	if !ok {
+		return errors.E(op, errors.Errorf("no such pipeline: %s", pipeline))
+	}
+
+	st := d.MigrationStatus()
+	if st == nil {
+		return errors.E(op, errors.Errorf("no migrations for the pipeline: %s", pipeline))
+	}
+
+	*out = *st
+	return nil
+}
+
+// Health returns the connection and subscriptions state of the pipeline
+func (r *rpc) Health(pipeline string, out *natsjobs.Health) error {
+	const op = errors.Op("nats_rpc_health")
+
+	d, ok := r.p.driver(pipeline)
+	if !ok {
+		return errors.E(op, errors.Errorf("no such pipeline: %s", pipeline))
+	}
+
+	*out = *d.Health()
+	return nil
+}
+
+// Reload re-reads the pipeline configuration and applies the changed options in place
+func (r *rpc) Reload(pipeline string, out *bool) error {
+	const op = errors.Op("nats_rpc_reload")
+
+	d, ok := r.p.driver(pipeline)
+	if !ok {
+		return errors.E(op, errors.Errorf("no such pipeline: %s", pipeline))
+	}
+
+	err := d.Reload()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	*out = true
+	return nil
+}
+
+// ReplayStatus returns the deliver_all replay progress of the pipeline
+func (r *rpc) ReplayStatus(pipeline string, out *natsjobs.ReplayStatus) error {
+	const op = errors.Op("nats_rpc_replay_status")
+
+	d, ok := r.p.driver(pipeline)
+	if !ok {
+		return errors.E(op, errors.Errorf("no such pipeline: %s", pipeline))
+	}
+
+	st := d.ReplayStatus()
+	if st == nil {
+		return errors.E(op, errors.Errorf("deliver_all is not enabled for the pipeline: %s", pipeline))
+	}
+
+	*out = *st
+	return nil
+}
+
+// Purge removes the messages from the pipeline stream keeping the stream and the consumers state
+func (r *rpc) Purge(req *natsjobs.PurgeRequest, out *natsjobs.PurgeResult) error {
+	const op = errors.Op("nats_rpc_purge")
+
+	d, ok := r.p.driver(req.Pipeline)
+	if !ok {
+		return errors.E(op, errors.Errorf("no such pipeline: %s", req.Pipeline))
+	}
+
+	res, err := d.Purge(req)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	*out = *res
+	return nil
+}
+
+// JobStatus returns the job state tracked in the pipeline status_bucket
+func (r *rpc) JobStatus(req *natsjobs.JobStatusRequest, out *natsjobs.JobStatus) error {
+	const op = errors.Op("nats_rpc_job_status")
+
+	d, ok := r.p.driver(req.Pipeline)
+	if !ok {
+		return errors.E(op, errors.Errorf("no such pipeline: %s", req.Pipeline))
+	}
+
+	st, err := d.JobStatus(req.ID)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	*out = *st
+	return nil
+}
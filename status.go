@@ -0,0 +1,29 @@
+package nats
+
+import (
+	"net/http"
+
+	"github.com/roadrunner-server/api/v4/plugins/v1/status"
+)
+
+// Status is used by the status plugin, the plugin is not alive if any pipeline connection is closed permanently
+func (p *Plugin) Status() (*status.Status, error) {
+	for _, d := range p.activeDrivers() {
+		if d.Health().Closed {
+			return &status.Status{Code: http.StatusServiceUnavailable}, nil
+		}
+	}
+
+	return &status.Status{Code: http.StatusOK}, nil
+}
+
+// Ready is used by the status plugin, the plugin is not ready if any pipeline can't publish or consume
+func (p *Plugin) Ready() (*status.Status, error) {
+	for _, d := range p.activeDrivers() {
+		if !d.Health().Healthy() {
+			return &status.Status{Code: http.StatusServiceUnavailable}, nil
+		}
+	}
+
+	return &status.Status{Code: http.StatusOK}, nil
+}